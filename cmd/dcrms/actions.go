@@ -0,0 +1,1087 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// argType is the type of the value of an action argument.
+type argType int
+
+const (
+	argString argType = iota
+	argList           // Comma separated strings
+	argInt
+	argUint
+	argAmount // Decimal amount, optionally suffixed with DCR, mDCR or atoms
+	argBool
+	argHeight // Block height or, prefixed with +, blocks from the tip
+)
+
+// check returns an error describing why v is not a valid value of the type.
+func (t argType) check(v string) error {
+	switch t {
+	case argList:
+		for _, s := range strings.Split(v, ",") {
+			if s == "" {
+				return fmt.Errorf("empty list element")
+			}
+		}
+	case argInt:
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("not an integer")
+		}
+	case argUint:
+		if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+			return fmt.Errorf("not an unsigned integer")
+		}
+	case argAmount:
+		if _, err := multisig.ParseAmount(v); err != nil {
+			return fmt.Errorf("not an amount, e.g. 1.5, 1500mDCR " +
+				"or 150000000atoms")
+		}
+	case argBool:
+		switch strings.ToLower(v) {
+		case "1", "0", "true", "false":
+		default:
+			return fmt.Errorf("not a boolean, use true or false")
+		}
+	case argHeight:
+		_, err := strconv.ParseUint(strings.TrimPrefix(v, "+"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("not a block height or +blocks")
+		}
+	}
+	return nil
+}
+
+// argSpec describes a single name=value argument of an action.
+type argSpec struct {
+	name     string
+	typ      argType
+	value    string   // Placeholder of the value in usage
+	values   []string // Allowed values, any value when empty
+	required bool
+	def      string // Default value, none when empty
+	help     string
+}
+
+// usage returns the argument as it is shown in usage, e.g. to=<address>.
+func (s *argSpec) usage() string {
+	value := "<" + s.value + ">"
+	switch {
+	case len(s.values) > 0:
+		value = strings.Join(s.values, "|")
+	case s.typ == argList:
+		value += ",<...>"
+	}
+	u := s.name + "=" + value
+	if !s.required {
+		u = "[" + u + "]"
+	}
+	return u
+}
+
+// action describes a command line action and its arguments.
+type action struct {
+	name string
+	sub  string // Positional sub action, e.g. verify of auditlog
+	args []argSpec
+	help string
+	run  func(c *client, ctx context.Context, a map[string]string) error
+
+	// offline actions need no wallet and run on air-gapped computers.
+	offline bool
+}
+
+// usage returns the action and its arguments as they are shown in usage.
+func (ac *action) usage() string {
+	u := []string{ac.name}
+	if ac.sub != "" {
+		u = append(u, ac.sub)
+	}
+	for _, req := range []bool{true, false} {
+		for k := range ac.args {
+			if ac.args[k].required == req {
+				u = append(u, ac.args[k].usage())
+			}
+		}
+	}
+	return strings.Join(u, " ")
+}
+
+// arg returns the specification of the named argument or nil if the action
+// does not take it.
+func (ac *action) arg(name string) *argSpec {
+	for k := range ac.args {
+		if ac.args[k].name == name {
+			return &ac.args[k]
+		}
+	}
+	return nil
+}
+
+// parse parses and validates the arguments of the action. Unknown arguments,
+// values of the wrong type and missing required arguments are rejected.
+// Defaults are filled in for absent arguments.
+func (ac *action) parse(args []string) (map[string]string, error) {
+	a, err := ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range a {
+		s := ac.arg(name)
+		if s == nil {
+			names := make([]string, 0, len(ac.args))
+			for k := range ac.args {
+				names = append(names, ac.args[k].name)
+			}
+			return nil, fmt.Errorf("unknown %v argument: %v%v",
+				ac.name, name, suggest(name, names))
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid %v argument %v: empty "+
+				"value", ac.name, name)
+		}
+		if len(s.values) > 0 && !contains(s.values, value) {
+			return nil, fmt.Errorf("invalid %v argument %v=%q: "+
+				"use %v", ac.name, name, value,
+				strings.Join(s.values, " or "))
+		}
+		if err := s.typ.check(value); err != nil {
+			return nil, fmt.Errorf("invalid %v argument %v=%q: %v",
+				ac.name, name, value, err)
+		}
+	}
+	for k := range ac.args {
+		s := &ac.args[k]
+		if _, ok := a[s.name]; ok {
+			continue
+		}
+		if s.required {
+			return nil, fmt.Errorf("missing %v argument: %v",
+				ac.name, s.usage())
+		}
+		if s.def != "" {
+			a[s.name] = s.def
+		}
+	}
+	return a, nil
+}
+
+// contains returns true if s is one of values.
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggest returns a hint naming the candidate closest to the misspelled
+// name, if any is close enough.
+func suggest(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, c := range candidates {
+		if d := distance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %v?)", best)
+}
+
+// required returns a copy of the shared argument that is required.
+func required(s argSpec) argSpec {
+	s.required = true
+	return s
+}
+
+// withDefault returns a copy of the shared argument with another default.
+func withDefault(s argSpec, def string) argSpec {
+	s.def = def
+	return s
+}
+
+// Arguments shared by several actions.
+var (
+	argConfirmations = argSpec{
+		name:  "confirmations",
+		typ:   argInt,
+		value: "number",
+		def:   strconv.Itoa(defaultConfirmations),
+		help:  "Minimum number of confirmations of the spent utxos",
+	}
+	argInputs = argSpec{
+		name:  "inputs",
+		typ:   argList,
+		value: "txid:vout",
+		help:  "Spend exactly these outpoints",
+	}
+	argExclude = argSpec{
+		name:  "exclude",
+		typ:   argList,
+		value: "txid:vout",
+		help:  "Never spend these outpoints",
+	}
+	argExpiry = argSpec{
+		name:  "expiry",
+		typ:   argHeight,
+		value: "height|+blocks",
+		help: "Block height, or number of blocks relative to the " +
+			"tip, after which the transaction can no longer be mined",
+	}
+	argLockTime = argSpec{
+		name:  "locktime",
+		typ:   argUint,
+		value: "height or timestamp",
+		help:  "The transaction can not be mined before the lock time",
+	}
+	argMemo = argSpec{
+		name:  "memo",
+		typ:   argString,
+		value: "text|0xhex",
+		help: "Add a zero value OP_RETURN output carrying the memo, " +
+			"at most 256 bytes",
+	}
+	argOverride = argSpec{
+		name:  "override",
+		typ:   argString,
+		value: "reason",
+		help: "Sign even though the transaction violates a " +
+			"contract policy; the reason is logged",
+	}
+	argContract = argSpec{
+		name:  "contract",
+		typ:   argString,
+		value: "name",
+		help:  "Contract name the cosigner keys are signed for",
+	}
+	argM = argSpec{
+		name:     "m",
+		typ:      argUint,
+		value:    "number of signatures required",
+		required: true,
+		help:     "Number of signatures required to spend",
+	}
+	argXPubs = argSpec{
+		name:     "xpubs",
+		typ:      argList,
+		value:    "xpub",
+		required: true,
+		help:     "Account extended public keys of all cosigners",
+	}
+	argPath = argSpec{
+		name:  "path",
+		typ:   argString,
+		value: "path",
+		help: "Non-hardened derivation path from the extended keys " +
+			"to the branches, e.g. 0/5; none uses the account " +
+			"branches, the only ones the wallet can sign for",
+	}
+	argGapLimit = argSpec{
+		name:  "gaplimit",
+		typ:   argInt,
+		value: "number",
+		def:   strconv.Itoa(multisig.DefaultGapLimit),
+		help: "Consecutive unused addresses after which scanning " +
+			"stops",
+	}
+	argAccount = argSpec{
+		name:  "account",
+		typ:   argString,
+		value: "name",
+		def:   "default",
+		help:  "Wallet account",
+	}
+	argCoordinator = argSpec{
+		name:  "coordinator",
+		typ:   argString,
+		value: "url",
+		help:  "Coordinator URL",
+	}
+)
+
+// actions are all command line actions, in usage order.
+var actions = []action{
+	{
+		name: "getmultisigbalance",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Multisig address or descriptor",
+		}},
+		help: "Print the balance of the multisig address.",
+		run:  (*client).getMultiSigBalance,
+	},
+	{
+		name: "getwalletbalance",
+		help: "Print total spendable wallet amount.",
+		run:  (*client).getWalletBalance,
+	},
+	{
+		name: "getnewkey",
+		args: []argSpec{argContract},
+		help: "Obtain a new public key for a multisig contract. With " +
+			"contract the wallet also signs a statement that proves " +
+			"possession of the key, which is printed as the last " +
+			"line and is what cosigners pass to " +
+			"createmultisigaddress.",
+		run: (*client).getNewKey,
+	},
+	{
+		name: "createmultisigaddress",
+		args: []argSpec{{
+			name:     "n",
+			typ:      argUint,
+			value:    "number of signatures required",
+			required: true,
+			help:     "Number of signatures required to spend",
+		}, {
+			name:     "keys",
+			typ:      argList,
+			value:    "statement|public key",
+			required: true,
+			help: "Key statements of all cosigners, or with " +
+				"unverifiedkeys their public keys",
+		},
+			argContract, {
+				name:  "unverifiedkeys",
+				typ:   argString,
+				value: "reason",
+				help: "Accept public keys without proof of " +
+					"possession; the reason is logged",
+			},
+		},
+		help: "Create a multisig address that requires n signatures " +
+			"out of number of keys. Duplicate keys and keys of " +
+			"another network are refused. Every key statement " +
+			"must be signed for the contract name; bare public " +
+			"keys are only accepted with unverifiedkeys. " +
+			"Prints the address, redeem script and descriptor.",
+		run: (*client).createMultisigAddress,
+	},
+	{
+		name: "sendtomultisig",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Destination address",
+		}, {
+			name:     "amount",
+			typ:      argAmount,
+			value:    "amount",
+			required: true,
+			help:     "Amount to send",
+		}},
+		help: "Send funds to an address; wallet must be unlocked.",
+		run:  (*client).sendToMultisig,
+	},
+	{
+		name: "createmultisigtx",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argList,
+			value:    "address",
+			required: true,
+			help: "Multisig addresses or descriptors to spend " +
+				"from, change is sent to the first one",
+		}, {
+			name:     "to",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Destination address",
+		}, {
+			name:     "amount",
+			typ:      argAmount,
+			value:    "amount",
+			required: true,
+			help:     "Amount paid to the destination",
+		},
+			argConfirmations, argInputs, argExclude, argMemo,
+			argExpiry, argLockTime,
+		},
+		help: "Create an unsigned multisig transaction that spends " +
+			"from one or more multisig addresses; change is sent to " +
+			"the first address, dust change goes to the miners. " +
+			"When inputs is provided exactly those outpoints are " +
+			"spent. Excluded outpoints are never spent.",
+		run: (*client).createMultisigTx,
+	},
+	{
+		name: "decodemultisigtx",
+		args: []argSpec{{
+			name:     "tx",
+			typ:      argString,
+			value:    "transaction",
+			required: true,
+			help:     "Hex encoded transaction",
+		}},
+		help: "Print inputs, signing status, outputs, fee and expiry " +
+			"of a multisig transaction for review.",
+		run: (*client).decodeMultisigTx,
+	},
+	{
+		name: "signmultisigtx",
+		args: []argSpec{{
+			name:  "tx",
+			typ:   argString,
+			value: "partially signed transaction",
+			help:  "Hex encoded transaction to sign",
+		}, argCoordinator, {
+			name:  "id",
+			typ:   argString,
+			value: "txid",
+			help:  "Proposal to fetch from the coordinator",
+		}, argOverride},
+		help: "Partially, or fully, sign a multisig transaction and " +
+			"print the signing status of every input. Either tx, or " +
+			"coordinator and id, are required; the latter fetches " +
+			"a proposal from a coordinator, signs it and posts the " +
+			"signatures back. Warns when the transaction has no " +
+			"expiry or is about to expire. Refuses to sign when the " +
+			"transaction violates the policy of a contract it " +
+			"spends from unless an override reason is provided; " +
+			"overrides are logged.",
+		run: (*client).signMultiSigTx,
+	},
+	{
+		name: "broadcastmultisigtx",
+		args: []argSpec{{
+			name:  "tx",
+			typ:   argString,
+			value: "signed multisig tx",
+			help:  "Hex encoded transaction to broadcast",
+		}, argCoordinator, {
+			name:  "id",
+			typ:   argString,
+			value: "txid",
+			help: "Proposal to broadcast, from the coordinator " +
+				"when provided or else from the proposal directory",
+		}},
+		help: "Broadcast a multi signature transaction to the " +
+			"network. With coordinator and id the coordinator " +
+			"broadcasts a fully signed proposal, with only id a " +
+			"fully signed proposal from the proposal directory is " +
+			"broadcast.",
+		run: (*client).broadcastMultisigTx,
+	},
+	{
+		name: "propose",
+		args: []argSpec{{
+			name:     "tx",
+			typ:      argString,
+			value:    "unsigned multisig tx",
+			required: true,
+			help:     "Hex encoded transaction",
+		}, {
+			name:  "description",
+			typ:   argString,
+			value: "text",
+			help:  "Description shown to cosigners",
+		}},
+		help: "Write a transaction to the proposal directory for " +
+			"cosigners to sign.",
+		run: (*client).propose,
+	},
+	{
+		name: "pending",
+		help: "List open proposals that still need a signature from " +
+			"our wallet.",
+		run: (*client).pending,
+	},
+	{
+		name: "approve",
+		args: []argSpec{{
+			name:     "id",
+			typ:      argString,
+			value:    "txid",
+			required: true,
+			help:     "Proposal to sign",
+		}, argOverride},
+		help: "Sign a proposal with the wallet, as signmultisigtx " +
+			"does, and write the signatures back.",
+		run: (*client).approve,
+	},
+	{
+		name: "reject",
+		args: []argSpec{{
+			name:     "id",
+			typ:      argString,
+			value:    "txid",
+			required: true,
+			help:     "Proposal to reject",
+		}, {
+			name:     "reason",
+			typ:      argString,
+			value:    "text",
+			required: true,
+			help:     "Why the proposal is rejected",
+		}},
+		help: "Reject a proposal.",
+		run:  (*client).reject,
+	},
+	{
+		name: "serve",
+		args: []argSpec{{
+			name:  "listen",
+			typ:   argString,
+			value: "host:port",
+			def:   defaultCoordinatorListen,
+			help:  "Address the coordinator listens on",
+		}},
+		help: "Run a coordinator that collects signatures for " +
+			"proposals.",
+		run: (*client).serve,
+	},
+	{
+		name: "proposemultisigtx",
+		args: []argSpec{
+			required(argCoordinator), {
+				name:     "tx",
+				typ:      argString,
+				value:    "unsigned multisig tx",
+				required: true,
+				help:     "Hex encoded transaction",
+			}},
+		help: "Upload a transaction to a coordinator for cosigners to " +
+			"sign.",
+		run: (*client).proposeMultisigTx,
+	},
+	{
+		name: "proposalstatus",
+		args: []argSpec{
+			required(argCoordinator), {
+				name:  "id",
+				typ:   argString,
+				value: "txid",
+				help:  "Proposal, all proposals when omitted",
+			}},
+		help: "Print the signing status of one or all proposals of a " +
+			"coordinator.",
+		run: (*client).proposalStatus,
+	},
+	{
+		name: "auditlog",
+		sub:  "verify",
+		help: "Verify that the audit log of all multisig operations " +
+			"is intact.",
+		run: func(c *client, ctx context.Context, a map[string]string) error {
+			return c.auditLog("verify", a)
+		},
+	},
+	{
+		name: "auditlog",
+		sub:  "export",
+		args: []argSpec{{
+			name:   "format",
+			typ:    argString,
+			values: []string{"json", "csv"},
+			def:    "json",
+			help:   "Output format",
+		}},
+		help: "Verify and print the audit log for compliance reviews.",
+		run: func(c *client, ctx context.Context, a map[string]string) error {
+			return c.auditLog("export", a)
+		},
+	},
+	{
+		name: "multisiginfo",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Multisig address or descriptor",
+		}},
+		help: "Print information about the multisig address, " +
+			"including its descriptor.",
+		run: (*client).multisigInfo,
+	},
+	{
+		name: "sweepmultisig",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argList,
+			value:    "address",
+			required: true,
+			help:     "Multisig addresses or descriptors to sweep",
+		}, {
+			name:     "to",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Destination address",
+		},
+			argConfirmations, argInputs, argExclude, argMemo,
+			argExpiry, argLockTime,
+		},
+		help: "Create an unsigned multisig transaction that sends the " +
+			"entire balance, minus the fee, to the destination " +
+			"address.",
+		run: (*client).sweepMultisig,
+	},
+	{
+		name: "listmultisigutxos",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argList,
+			value:    "address",
+			required: true,
+			help:     "Multisig addresses or descriptors",
+		}, withDefault(argConfirmations, "0"), argInputs, argExclude},
+		help: "Print outpoint, amount, confirmations, tree and address " +
+			"of every utxo.",
+		run: (*client).listMultisigUtxos,
+	},
+	{
+		name: "consolidatemultisig",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help: "Multisig address or descriptor, consolidated " +
+				"funds return to it",
+		}, {
+			name:     "minvalue",
+			typ:      argAmount,
+			value:    "amount",
+			required: true,
+			help:     "Merge utxos below this amount",
+		}, {
+			name:  "maxinputs",
+			typ:   argInt,
+			value: "number",
+			def:   strconv.Itoa(defaultMaxInputs),
+			help:  "Maximum number of inputs per transaction",
+		}, argConfirmations, {
+			name:  "dryrun",
+			typ:   argBool,
+			value: "bool",
+			def:   "false",
+			help:  "Only print the estimate",
+		},
+			argInputs, argExclude, argExpiry, argLockTime,
+		},
+		help: "Create unsigned multisig transactions that merge all " +
+			"utxos below minvalue back into the multisig address. " +
+			"Fees and the fee saved on future spends are printed on " +
+			"stderr.",
+		run: (*client).consolidateMultisig,
+	},
+	{
+		name: "backupcontract",
+		args: []argSpec{{
+			name:     "address",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Multisig address or descriptor",
+		}, {
+			name:  "labels",
+			typ:   argList,
+			value: "label",
+			help: "Names of the cosigners in redeem script order, " +
+				"Cosigner 1 and so on when omitted",
+		}, {
+			name:  "height",
+			typ:   argUint,
+			value: "height",
+			help: "Creation height, the oldest utxo or the chain " +
+				"tip when omitted",
+		}, {
+			name:   "format",
+			typ:    argString,
+			values: []string{"text", "pdf"},
+			def:    "text",
+			help:   "Output format",
+		}},
+		help: "Print a paper backup kit of the contract: the network, " +
+			"address, creation height, every cosigner key and " +
+			"label, the redeem script and descriptor with a " +
+			"checksum per line, and step by step recovery " +
+			"instructions.",
+		run: (*client).backupContract,
+	},
+	{
+		name: "verifybackup",
+		args: []argSpec{{
+			name:  "kit",
+			typ:   argString,
+			value: "filename",
+			help:  "Typed in kit, read from stdin when omitted",
+		}},
+		help: "Verify that a typed in backup kit recomputes its " +
+			"address. Mistyped lines are reported by line number. " +
+			"No wallet or network access is needed.",
+		run:     (*client).verifyBackup,
+		offline: true,
+	},
+	{
+		name: "getxpub",
+		args: []argSpec{argAccount},
+		help: "Obtain the extended public key of a wallet account for " +
+			"an HD multisig contract.",
+		run: (*client).getXPub,
+	},
+	{
+		name: "hdaddress",
+		args: []argSpec{argM, argXPubs, argPath, {
+			name:  "index",
+			typ:   argUint,
+			value: "number",
+			help: "Deposit address index, the first unused one " +
+				"when omitted",
+		}, argGapLimit},
+		help: "Print a deposit address of an HD multisig contract. " +
+			"Every address uses the keys xpub/path/0/index.",
+		run: (*client).hdAddress,
+	},
+	{
+		name: "hdscan",
+		args: []argSpec{argM, argXPubs, argPath, argGapLimit,
+			withDefault(argConfirmations, "0")},
+		help: "Print the used deposit and change addresses of an HD " +
+			"multisig contract, their balance and the next unused " +
+			"indexes.",
+		run: (*client).hdScanAction,
+	},
+	{
+		name: "createhdmultisigtx",
+		args: []argSpec{argM, argXPubs, argPath, {
+			name:     "to",
+			typ:      argString,
+			value:    "address",
+			required: true,
+			help:     "Destination address",
+		}, {
+			name:     "amount",
+			typ:      argAmount,
+			value:    "amount",
+			required: true,
+			help:     "Amount to send",
+		},
+			argConfirmations, argInputs, argExclude, argMemo,
+			argExpiry, argLockTime, argGapLimit,
+		},
+		help: "Create an unsigned transaction that spends from the " +
+			"used addresses of an HD multisig contract. Change goes " +
+			"to the next unused change address, xpub/path/1/index.",
+		run: (*client).createHDMultisigTx,
+	},
+	{
+		name: "signhdmultisigtx",
+		args: []argSpec{{
+			name:     "tx",
+			typ:      argString,
+			value:    "hex",
+			required: true,
+			help:     "Transaction to sign",
+		}, argM, argXPubs, argPath, argAccount, argGapLimit,
+			argOverride},
+		help: "Sign a transaction that spends from an HD multisig " +
+			"contract. The wallet account must be a cosigner; it " +
+			"derives the keys of every input before signing. The " +
+			"policy of the contract, named as printed by hdscan, " +
+			"is enforced.",
+		run: (*client).signHDMultisigTx,
+	},
+	{
+		name: "wizard",
+		help: "Interactively create or import a contract, fund it, " +
+			"propose a spend, sign it and broadcast it. Inputs are " +
+			"validated and a summary is shown before sending funds " +
+			"or broadcasting.",
+		run: (*client).wizard,
+	},
+}
+
+// findActions returns all actions with the provided name.
+func findActions(name string) []*action {
+	var found []*action
+	for k := range actions {
+		if actions[k].name == name {
+			found = append(found, &actions[k])
+		}
+	}
+	return found
+}
+
+// actionNames returns the sorted, unique names of all actions.
+func actionNames() []string {
+	var names []string
+	for k := range actions {
+		if !contains(names, actions[k].name) {
+			names = append(names, actions[k].name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupAction returns the action requested on the command line and its
+// name=value arguments.
+func lookupAction(args []string) (*action, []string, error) {
+	found := findActions(args[0])
+	if len(found) == 0 {
+		return nil, nil, fmt.Errorf("invalid action: %v%v", args[0],
+			suggest(args[0], actionNames()))
+	}
+	if found[0].sub == "" {
+		return found[0], args[1:], nil
+	}
+	subs := make([]string, 0, len(found))
+	for _, ac := range found {
+		if len(args) > 1 && args[1] == ac.sub {
+			return ac, args[2:], nil
+		}
+		subs = append(subs, ac.sub)
+	}
+	return nil, nil, fmt.Errorf("%v requires %v", args[0],
+		strings.Join(subs, " or "))
+}
+
+// wrap writes text as lines of at most width columns, each prefixed with
+// indent.
+func wrap(w io.Writer, indent, text string, width int) {
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			fmt.Fprintf(w, "%v%v\n", indent, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		fmt.Fprintf(w, "%v%v\n", indent, line)
+	}
+}
+
+// printFlags writes the flags of fs and their help.
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		name, help := flag.UnquoteUsage(f)
+		if name != "" {
+			fmt.Fprintf(w, "  -%v <%v>\n", f.Name, name)
+		} else {
+			fmt.Fprintf(w, "  -%v\n", f.Name)
+		}
+		switch f.DefValue {
+		case "", "0", "false":
+		default:
+			help += fmt.Sprintf(", default %v", f.DefValue)
+		}
+		wrap(w, "\t", help, 70)
+	})
+}
+
+// printUsage writes the general usage of dcrms.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage of dcrms:\n")
+	fmt.Fprintf(w, "  dcrms [flags] action <args...>\n")
+	fmt.Fprintf(w, "  dcrms help [action]\n")
+	fmt.Fprintf(w, "  dcrms completion bash\n")
+	fmt.Fprintf(w, "Flags:\n")
+	printFlags(w, (&config{}).FlagSet())
+	fmt.Fprintf(w, "Actions:\n")
+	for k := range actions {
+		fmt.Fprintf(w, "  %v\n", actions[k].usage())
+		wrap(w, "\t", actions[k].help, 70)
+	}
+	fmt.Fprintf(w, "Run dcrms help <action> for the arguments of an "+
+		"action.\n")
+}
+
+// usage prints the general usage on stderr and exits.
+func usage() {
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
+
+// help writes the usage of the named action, or the general usage when no
+// action is named.
+func help(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		printUsage(w)
+		return nil
+	}
+	found := findActions(args[0])
+	if len(found) == 0 {
+		return fmt.Errorf("invalid action: %v%v", args[0],
+			suggest(args[0], actionNames()))
+	}
+	for k, ac := range found {
+		if k > 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "Usage: dcrms [flags] %v\n", ac.usage())
+		wrap(w, "  ", ac.help, 72)
+		if len(ac.args) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "Arguments:\n")
+		for _, s := range ac.args {
+			h := s.help
+			switch {
+			case s.required:
+				h += ", required"
+			case s.def != "":
+				h += ", default " + s.def
+			}
+			fmt.Fprintf(w, "  %v\n", strings.Trim(s.usage(), "[]"))
+			wrap(w, "\t", h, 70)
+		}
+	}
+	return nil
+}
+
+// completion writes a bash completion script for dcrms.
+func completion(w io.Writer, args []string) error {
+	if len(args) != 1 || args[0] != "bash" {
+		return fmt.Errorf("completion requires bash")
+	}
+
+	var flags, valueFlags []string
+	(&config{}).FlagSet().VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok &&
+			b.IsBoolFlag() {
+			return
+		}
+		valueFlags = append(valueFlags, "-"+f.Name)
+	})
+	names := actionNames()
+	argNames := func(ac *action) string {
+		a := make([]string, 0, len(ac.args))
+		for _, s := range ac.args {
+			a = append(a, s.name+"=")
+		}
+		return strings.Join(a, " ")
+	}
+
+	fmt.Fprintf(w, `# bash completion for dcrms, install with:
+#	dcrms completion bash > /etc/bash_completion.d/dcrms
+_dcrms()
+{
+	local line="${COMP_LINE:0:COMP_POINT}" cur action sub words i
+	read -ra words <<< "$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	cur="${words[${#words[@]}-1]}"
+	for ((i = 1; i < ${#words[@]} - 1; i++)); do
+		case "${words[i]}" in
+		%v) ((i++)) ;;
+		-*) ;;
+		*)
+			if [[ -z $action ]]; then
+				action="${words[i]}"
+			elif [[ -z $sub ]]; then
+				sub="${words[i]}"
+			fi
+			;;
+		esac
+	done
+
+	local candidates
+	case "$action" in
+	"")
+		if [[ $cur == -* ]]; then
+			candidates="%v"
+		else
+			candidates="%v"
+		fi
+		;;
+	help) [[ -z $sub ]] && candidates="%v" ;;
+	completion) [[ -z $sub ]] && candidates="bash" ;;
+`, strings.Join(valueFlags, "|"), strings.Join(flags, " "),
+		strings.Join(append([]string{"help", "completion"}, names...),
+			" "), strings.Join(names, " "))
+	for _, name := range names {
+		found := findActions(name)
+		if found[0].sub == "" {
+			fmt.Fprintf(w, "\t%v) candidates=\"%v\" ;;\n", name,
+				argNames(found[0]))
+			continue
+		}
+		subs := make([]string, 0, len(found))
+		for _, ac := range found {
+			subs = append(subs, ac.sub)
+		}
+		fmt.Fprintf(w, "\t%v)\n\t\tcase \"$sub\" in\n", name)
+		fmt.Fprintf(w, "\t\t\"\") candidates=\"%v\" ;;\n",
+			strings.Join(subs, " "))
+		for _, ac := range found {
+			fmt.Fprintf(w, "\t\t%v) candidates=\"%v\" ;;\n", ac.sub,
+				argNames(ac))
+		}
+		fmt.Fprintf(w, "\t\tesac\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, `	esac
+	COMPREPLY=($(compgen -W "$candidates" -- "$cur"))
+	[[ ${COMPREPLY[0]} == *= ]] && compopt -o nospace
+}
+complete -F _dcrms dcrms
+`)
+	return nil
+}
+
+// runBuiltin handles the help and completion actions, which need neither a
+// wallet nor an explorer. It returns false for all other actions.
+func runBuiltin(w io.Writer, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "help":
+		return true, help(w, args[1:])
+	case "completion":
+		return true, completion(w, args[1:])
+	}
+	return false, nil
+}
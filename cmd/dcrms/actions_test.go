@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestActionParse(t *testing.T) {
+	tests := []struct {
+		args []string
+		want map[string]string
+		err  string
+	}{
+		{
+			args: []string{"createmultisigtx", "address=a,b", "to=c",
+				"amount=1.5"},
+			want: map[string]string{"address": "a,b", "to": "c",
+				"amount": "1.5", "confirmations": "6"},
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"ammount=5"},
+			err: "unknown createmultisigtx argument: ammount " +
+				"(did you mean amount?)",
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"amount=5", "confirmation=1"},
+			err: "(did you mean confirmations?)",
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"amount=5", "confirmations=six"},
+			err: `invalid createmultisigtx argument ` +
+				`confirmations="six": not an integer`,
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"amount=0.000000001"},
+			err: "not an amount",
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"amount=1500mDCR"},
+			want: map[string]string{"address": "a", "to": "c",
+				"amount": "1500mDCR", "confirmations": "6"},
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c"},
+			err:  "missing createmultisigtx argument: amount=<amount>",
+		},
+		{
+			args: []string{"createmultisigtx", "address=a,,b", "to=c",
+				"amount=5"},
+			err: "empty list element",
+		},
+		{
+			args: []string{"createmultisigtx", "address=a", "to=c",
+				"amount=5", "expiry=+x"},
+			err: "not a block height",
+		},
+		{
+			args: []string{"consolidatemultisig", "address=a",
+				"minvalue=0.1", "dryrun=yes"},
+			err: "not a boolean",
+		},
+		{
+			args: []string{"getmultisigbalance", "address"},
+			err:  "empty value",
+		},
+		{
+			args: []string{"auditlog", "export", "format=xml"},
+			err:  "use json or csv",
+		},
+		{
+			args: []string{"auditlog", "export"},
+			want: map[string]string{"format": "json"},
+		},
+		{
+			args: []string{"auditlog", "verify", "format=csv"},
+			err:  "unknown auditlog argument: format",
+		},
+		{
+			args: []string{"auditlog"},
+			err:  "auditlog requires verify or export",
+		},
+		{
+			args: []string{"createmultisig"},
+			err: "invalid action: createmultisig (did you mean " +
+				"createmultisigtx?)",
+		},
+	}
+	for _, tt := range tests {
+		ac, args, err := lookupAction(tt.args)
+		var a map[string]string
+		if err == nil {
+			a, err = ac.parse(args)
+		}
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%v: got %v, want %v", tt.args, err,
+					tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if len(a) != len(tt.want) {
+			t.Fatalf("%v: got %v, want %v", tt.args, a, tt.want)
+		}
+		for k, v := range tt.want {
+			if a[k] != v {
+				t.Fatalf("%v: got %v, want %v", tt.args, a,
+					tt.want)
+			}
+		}
+	}
+}
+
+func TestActionSchema(t *testing.T) {
+	for _, ac := range actions {
+		if ac.help == "" || ac.run == nil {
+			t.Fatalf("%v: missing help or handler", ac.usage())
+		}
+		seen := make(map[string]bool)
+		for _, s := range ac.args {
+			if seen[s.name] {
+				t.Fatalf("%v: duplicate argument %v", ac.name,
+					s.name)
+			}
+			seen[s.name] = true
+			if s.help == "" {
+				t.Fatalf("%v: %v has no help", ac.name, s.name)
+			}
+			if s.required && s.def != "" {
+				t.Fatalf("%v: %v is required and has a default",
+					ac.name, s.name)
+			}
+			if s.def != "" && s.typ.check(s.def) != nil {
+				t.Fatalf("%v: %v has an invalid default",
+					ac.name, s.name)
+			}
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	var b bytes.Buffer
+	ok, err := runBuiltin(&b, []string{"help", "consolidatemultisig"})
+	if !ok || err != nil {
+		t.Fatalf("got %v %v", ok, err)
+	}
+	for _, want := range []string{
+		"Usage: dcrms [flags] consolidatemultisig address=<address>",
+		"[dryrun=<bool>]",
+		"maxinputs=<number>",
+		"default 100",
+	} {
+		expect(t, b.String(), want)
+	}
+
+	// The general usage documents the flags as they are parsed.
+	b.Reset()
+	_, err = runBuiltin(&b, []string{"help"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, b.String(), "-key <password>")
+	expect(t, b.String(), "auditlog export [format=json|csv]")
+
+	_, err = runBuiltin(&b, []string{"help", "nosuchaction"})
+	if err == nil {
+		t.Fatal("expected invalid action error")
+	}
+
+	b.Reset()
+	_, err = runBuiltin(&b, []string{"completion", "bash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, b.String(), "complete -F _dcrms dcrms")
+	expect(t, b.String(), `createmultisigtx) candidates="address= to= `+
+		`amount= `)
+
+	ok, _ = runBuiltin(&b, []string{"getnewkey"})
+	if ok {
+		t.Fatal("getnewkey is not a builtin")
+	}
+}
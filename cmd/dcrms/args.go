@@ -6,7 +6,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/decred/dcrd/dcrutil/v3"
 	"github.com/inhies/go-bytesize"
+	"github.com/marcopeereboom/dcrms/multisig"
 )
 
 // HasTrailingSlashes returns an error if any system has a trailing slash.
@@ -92,6 +94,7 @@ func ArgAsBool(arg string, args map[string]string) (bool, error) {
 		if a == "0" || strings.ToLower(a) == "false" {
 			return false, nil
 		}
+		return false, fmt.Errorf("invalid value for %v: %q", arg, a)
 	}
 	return false, fmt.Errorf("argument not found: %v", arg)
 }
@@ -118,9 +121,11 @@ func ArgAsSize(arg string, args map[string]string) (bytesize.ByteSize, error) {
 	return 0, fmt.Errorf("argument not found: %v", arg)
 }
 
-func ArgAsFloat(arg string, args map[string]string) (float64, error) {
+// ArgAsAmount parses a decimal amount, optionally suffixed with DCR, mDCR or
+// atoms, into atoms.
+func ArgAsAmount(arg string, args map[string]string) (dcrutil.Amount, error) {
 	if a, ok := args[arg]; ok {
-		return strconv.ParseFloat(a, 64)
+		return multisig.ParseAmount(a)
 	}
 	return 0, fmt.Errorf("argument not found: %v", arg)
 }
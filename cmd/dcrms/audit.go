@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	auditLogFile  = "audit.log"
+	auditHeadFile = "audit.head"
+	auditLockFile = "audit.lock"
+
+	// auditLockTimeout is how long to wait for another process to
+	// finish appending to the audit log.
+	auditLockTimeout = 10 * time.Second
+
+	// Audit events.
+	auditContract  = "contract"
+	auditProposal  = "proposal"
+	auditSignature = "signature"
+	auditBroadcast = "broadcast"
+	auditOverride  = "override"
+	auditRejection = "rejection"
+)
+
+// auditEntry is a single record of the audit log. Every entry commits to its
+// predecessor by including its hash, which makes edits, insertions and
+// reordering detectable. Truncation is detected by comparing the last entry
+// with the head file.
+//
+// The hash chain is not keyed. It detects accidental damage and careless
+// edits, but anyone with write access to the log and the head can rewrite
+// the whole chain, or replace it with a new one, and it will verify. Copies
+// of the head kept elsewhere, e.g. in exported reports, are needed to detect
+// that.
+type auditEntry struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp int64           `json:"timestamp"`
+	Net       string          `json:"net"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	Prev      string          `json:"prev"`
+	Hash      string          `json:"hash"`
+}
+
+// auditHead is the sequence number and hash of the last audit log entry.
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// auditOutput is a transaction output as recorded in the audit log.
+type auditOutput struct {
+	Address string `json:"address,omitempty"`
+	Memo    string `json:"memo,omitempty"`
+	Amount  int64  `json:"amount"` // Atoms
+}
+
+// auditSigner records which keys added signatures to an input.
+type auditSigner struct {
+	Input   int      `json:"input"`
+	PubKeys []string `json:"pubkeys"`
+}
+
+// auditMtx serializes audit log writers within the process. Writers in
+// other processes are excluded by the audit lock file.
+var auditMtx sync.Mutex
+
+// hash returns the hash of the entry, excluding the hash field itself.
+func (e *auditEntry) hash() (string, error) {
+	x := *e
+	x.Hash = ""
+	b, err := json.Marshal(x)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// readAuditHead returns the head of the audit log in the provided directory.
+// A missing head means an empty log.
+func readAuditHead(dir string) (*auditHead, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, auditHeadFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &auditHead{}, nil
+		}
+		return nil, err
+	}
+	var h auditHead
+	err = json.Unmarshal(b, &h)
+	if err != nil {
+		return nil, fmt.Errorf("audit head: %v", err)
+	}
+	return &h, nil
+}
+
+// appendAudit appends an event to the audit log in the provided directory.
+func appendAudit(dir, net, event string, data interface{}) error {
+	auditMtx.Lock()
+	defer auditMtx.Unlock()
+
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// The head is read and the entry appended under the lock, concurrent
+	// writers would otherwise chain to the same head and fork the log.
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, auditLockFile)
+	unlock, err := lockFile(filename, auditLockTimeout)
+	if err == errLocked {
+		return fmt.Errorf("audit log is locked by another process, "+
+			"remove %v if the lock is stale", filename)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	head, err := readAuditHead(dir)
+	if err != nil {
+		return err
+	}
+	e := auditEntry{
+		Seq:       head.Seq + 1,
+		Timestamp: time.Now().Unix(),
+		Net:       net,
+		Event:     event,
+		Data:      d,
+		Prev:      head.Hash,
+	}
+	e.Hash, err = e.hash()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, auditLogFile),
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\n", b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	// The head is replaced atomically, a torn head would make the
+	// intact log fail verification.
+	h, err := json.Marshal(auditHead{Seq: e.Seq, Hash: e.Hash})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, auditHeadFile), h)
+}
+
+// auditHDContractRecorded returns true if the audit log in the provided
+// directory records the creation of the HD contract with the provided policy
+// name. The log is not verified.
+func auditHDContractRecorded(dir, name string) (bool, error) {
+	f, err := os.Open(filepath.Join(dir, auditLogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e auditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil ||
+			e.Event != auditContract {
+			continue
+		}
+		var d struct {
+			HD string `json:"hd"`
+		}
+		if json.Unmarshal(e.Data, &d) == nil && d.HD == name {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// verifyAudit reads and verifies the audit log. It returns the entries when
+// the hash chain is intact and ends at the recorded head.
+func verifyAudit(r io.Reader, head *auditHead) ([]auditEntry, error) {
+	var (
+		entries []auditEntry
+		prev    string
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e auditEntry
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		if e.Seq != uint64(line) {
+			return nil, fmt.Errorf("line %v: sequence %v, entries "+
+				"removed or reordered", line, e.Seq)
+		}
+		if e.Prev != prev {
+			return nil, fmt.Errorf("line %v: broken hash chain",
+				line)
+		}
+		h, err := e.hash()
+		if err != nil {
+			return nil, err
+		}
+		if h != e.Hash {
+			return nil, fmt.Errorf("line %v: hash mismatch, entry "+
+				"was modified", line)
+		}
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var last auditHead
+	if len(entries) > 0 {
+		last.Seq = entries[len(entries)-1].Seq
+		last.Hash = entries[len(entries)-1].Hash
+	}
+	if last != *head {
+		return nil, fmt.Errorf("log ends at %v %v, head is %v %v: log "+
+			"was truncated", last.Seq, last.Hash, head.Seq,
+			head.Hash)
+	}
+	return entries, nil
+}
+
+// auditEvidence returns a description of local state that can only exist if
+// events were audited, i.e. spends recorded in a policy ledger or policy
+// overrides. It returns an empty string if there is none.
+func (c *client) auditEvidence() (string, error) {
+	_, err := os.Stat(filepath.Join(c.cfg.policyDir, policyOverrideLog))
+	if err == nil {
+		return "policy override log exists", nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	ledgers, err := filepath.Glob(filepath.Join(c.cfg.policyDir,
+		"*.ledger.json"))
+	if err != nil {
+		return "", err
+	}
+	for _, filename := range ledgers {
+		address := strings.TrimSuffix(filepath.Base(filename),
+			".ledger.json")
+		l, err := c.loadLedger(address)
+		if err != nil {
+			return "", err
+		}
+		if len(l) > 0 {
+			return fmt.Sprintf("policy ledger of %v records "+
+				"spends", address), nil
+		}
+	}
+	return "", nil
+}
+
+// audit records an event in the audit log.
+func (c *client) audit(event string, data interface{}) error {
+	err := appendAudit(c.cfg.auditDir, c.cfg.Net, event, data)
+	if err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
+	return nil
+}
+
+// auditOutputs returns the outputs of the provided transaction as recorded in
+// the audit log.
+func auditOutputs(tx *wire.MsgTx, params *chaincfg.Params) []auditOutput {
+	outputs := make([]auditOutput, 0, len(tx.TxOut))
+	for _, txOut := range tx.TxOut {
+		o := auditOutput{Amount: txOut.Value}
+		if memo, ok := multisig.TxMemo(txOut.PkScript); ok {
+			o.Memo = multisig.MemoString(memo)
+		} else {
+			_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+				txOut.Version, txOut.PkScript, params, false)
+			if err == nil && len(addrs) == 1 {
+				o.Address = addrs[0].Address()
+			} else {
+				o.Address = hex.EncodeToString(txOut.PkScript)
+			}
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
+
+// newSigners returns, per input, the keys that signed signedTx but had not
+// signed unsignedTx.
+func newSigners(unsignedTx, signedTx *wire.MsgTx) ([]auditSigner, error) {
+	var signers []auditSigner
+	for k := range signedTx.TxIn {
+		after, err := multisig.InputSigners(signedTx, k)
+		if err != nil {
+			return nil, err
+		}
+		var before []string
+		if k < len(unsignedTx.TxIn) {
+			before, err = multisig.InputSigners(unsignedTx, k)
+			if err != nil {
+				return nil, err
+			}
+		}
+		had := make(map[string]struct{}, len(before))
+		for _, pk := range before {
+			had[pk] = struct{}{}
+		}
+		s := auditSigner{Input: k}
+		for _, pk := range after {
+			if _, ok := had[pk]; !ok {
+				s.PubKeys = append(s.PubKeys, pk)
+			}
+		}
+		if len(s.PubKeys) > 0 {
+			signers = append(signers, s)
+		}
+	}
+	return signers, nil
+}
+
+// pubKeyAddresses converts hex encoded public keys to public key addresses
+// for display.
+func pubKeyAddresses(pubKeys []string, params *chaincfg.Params) []string {
+	addrs := make([]string, 0, len(pubKeys))
+	for _, pk := range pubKeys {
+		b, err := hex.DecodeString(pk)
+		if err != nil {
+			addrs = append(addrs, pk)
+			continue
+		}
+		a, err := dcrutil.NewAddressSecpPubKey(b, params)
+		if err != nil {
+			addrs = append(addrs, pk)
+			continue
+		}
+		addrs = append(addrs, a.String())
+	}
+	return addrs
+}
+
+// auditLog handles the auditlog verify and auditlog export actions.
+func (c *client) auditLog(action string, a map[string]string) error {
+	head, err := readAuditHead(c.cfg.auditDir)
+	if err != nil {
+		return err
+	}
+	var r io.Reader = bytes.NewReader(nil)
+	f, err := os.Open(filepath.Join(c.cfg.auditDir, auditLogFile))
+	switch {
+	case err == nil:
+		defer f.Close()
+		r = f
+	case !os.IsNotExist(err):
+		return err
+	case head.Seq != 0:
+		return fmt.Errorf("audit log verification failed: log is "+
+			"missing, head records %v entries", head.Seq)
+	default:
+		// Without log and head there must not be any trace of
+		// audited events.
+		evidence, err := c.auditEvidence()
+		if err != nil {
+			return err
+		}
+		if evidence != "" {
+			return fmt.Errorf("audit log verification failed: log "+
+				"and head are missing but %v", evidence)
+		}
+	}
+	entries, err := verifyAudit(r, head)
+	if err != nil {
+		return fmt.Errorf("audit log verification failed: %v", err)
+	}
+
+	switch action {
+	case "verify":
+		fmt.Printf("Audit log OK: %v entries\n", len(entries))
+		fmt.Printf("Head         : %v %v\n", head.Seq, head.Hash)
+		return nil
+
+	case "export":
+		format, err := ArgAsString("format", a)
+		if err != nil {
+			return err
+		}
+		switch format {
+		case "json":
+			e := json.NewEncoder(os.Stdout)
+			e.SetIndent("", "  ")
+			if entries == nil {
+				entries = []auditEntry{}
+			}
+			return e.Encode(entries)
+		case "csv":
+			w := csv.NewWriter(os.Stdout)
+			err := w.Write([]string{"seq", "time", "net", "event",
+				"data", "hash"})
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				err := w.Write([]string{
+					strconv.FormatUint(e.Seq, 10),
+					time.Unix(e.Timestamp, 0).UTC().
+						Format(time.RFC3339),
+					e.Net,
+					e.Event,
+					string(e.Data),
+					e.Hash,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			w.Flush()
+			return w.Error()
+		}
+		return fmt.Errorf("invalid format: %v", format)
+	}
+
+	return fmt.Errorf("invalid auditlog action: %v", action)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditLogLines writes n entries to a fresh audit log and returns the
+// directory and the log lines.
+func auditLogLines(t *testing.T, n int) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		err := appendAudit(dir, "testnet3", auditProposal,
+			map[string]int{"n": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, auditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestAuditVerify(t *testing.T) {
+	dir, lines := auditLogLines(t, 3)
+	head, err := readAuditHead(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != 3 {
+		t.Fatalf("got head %v", head.Seq)
+	}
+
+	join := func(l ...string) *bytes.Buffer {
+		return bytes.NewBufferString(strings.Join(l, "\n") + "\n")
+	}
+	tests := []struct {
+		name string
+		log  *bytes.Buffer
+		want string
+	}{
+		{"intact", join(lines...), ""},
+		{"modified", join(lines[0], strings.Replace(lines[1],
+			`"n":1`, `"n":7`, 1), lines[2]), "hash mismatch"},
+		{"reordered", join(lines[0], lines[2], lines[1]),
+			"removed or reordered"},
+		{"removed", join(lines[0], lines[2]), "removed or reordered"},
+		{"truncated", join(lines[0], lines[1]), "truncated"},
+		{"emptied", join(), "line 1"},
+	}
+	for _, tt := range tests {
+		entries, err := verifyAudit(tt.log, head)
+		if tt.want == "" {
+			if err != nil {
+				t.Fatalf("%v: %v", tt.name, err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("%v: got %v entries", tt.name,
+					len(entries))
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%v: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAuditMissing(t *testing.T) {
+	dir := t.TempDir()
+	c := newClient(&config{
+		NoCache:   true,
+		auditDir:  dir,
+		policyDir: filepath.Join(dir, "policy"),
+	})
+	verify := func() error {
+		_, err := capture(t, func() error {
+			return c.auditLog("verify", nil)
+		})
+		return err
+	}
+
+	// Nothing was ever audited.
+	if err := verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A signed spend is recorded in the ledger and the audit log.
+	err := os.MkdirAll(c.cfg.policyDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(c.ledgerFilename(escrowAddr),
+		[]byte(`[{"txid":"a","amount":1,"timestamp":1}]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		err := appendAudit(dir, "testnet3", auditSignature, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := verify(); err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := filepath.Glob(filepath.Join(dir, ".tmp*"))
+	if err != nil || len(tmp) != 0 {
+		t.Fatalf("temporary files left behind: %v %v", tmp, err)
+	}
+
+	err = os.Remove(filepath.Join(dir, auditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify()
+	if err == nil || !strings.Contains(err.Error(), "log is missing, "+
+		"head records 2 entries") {
+		t.Fatalf("got %v", err)
+	}
+	err = os.Remove(filepath.Join(dir, auditHeadFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verify()
+	if err == nil || !strings.Contains(err.Error(), "policy ledger of "+
+		escrowAddr+" records spends") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// TestAuditLock verifies that appends wait for the audit lock that another
+// process holds.
+func TestAuditLock(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockFile(filepath.Join(dir, auditLockFile), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		close(released)
+		unlock()
+	}()
+	err = appendAudit(dir, "testnet3", auditProposal, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatal("appended while the audit log was locked")
+	}
+	_, err = os.Stat(filepath.Join(dir, auditLockFile))
+	if !os.IsNotExist(err) {
+		t.Fatalf("lock not released: %v", err)
+	}
+	head, err := readAuditHead(dir)
+	if err != nil || head.Seq != 1 {
+		t.Fatalf("got %v %v", head, err)
+	}
+}
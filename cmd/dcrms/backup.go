@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	backupTitle     = "DCRMS CONTRACT BACKUP KIT"
+	backupLineSize  = 48 // Characters of redeem script and descriptor lines
+	backupTextWidth = 76
+)
+
+// backupLine matches the numbered data lines of a kit.
+var backupLine = regexp.MustCompile(`^(Label|Key|Script|Descriptor) ([0-9]+)$`)
+
+// backupKit is the content of a paper backup kit. Every key, redeem script
+// and descriptor line carries a checksum so that a mistyped line can be
+// found when the kit is typed back in.
+type backupKit struct {
+	Network      string
+	Address      string
+	M            int
+	N            int
+	Height       int64 // Creation height, rescans start here
+	Labels       []string
+	Keys         []string // Hex encoded public keys in redeem script order
+	RedeemScript string   // Hex encoded
+	Descriptor   string
+}
+
+// backupChecksum returns the checksum of a data line of a kit.
+func backupChecksum(s string) string {
+	return hex.EncodeToString(chainhash.HashB([]byte(s))[:2])
+}
+
+// backupChunks splits s in lines of at most backupLineSize characters.
+func backupChunks(s string) []string {
+	var chunks []string
+	for len(s) > backupLineSize {
+		chunks = append(chunks, s[:backupLineSize])
+		s = s[backupLineSize:]
+	}
+	return append(chunks, s)
+}
+
+// newBackupKit returns the kit of the provided contract.
+func newBackupKit(contract *multisig.Contract, labels []string, height int64, params *chaincfg.Params) (*backupKit, error) {
+	pushes, err := txscript.PushedData(contract.RedeemScript)
+	if err != nil {
+		return nil, err
+	}
+	descriptor, err := multisig.Descriptor(contract.RedeemScript, params)
+	if err != nil {
+		return nil, err
+	}
+	if labels == nil {
+		for k := range pushes {
+			labels = append(labels, fmt.Sprintf("Cosigner %v", k+1))
+		}
+	}
+	if len(labels) != len(pushes) {
+		return nil, fmt.Errorf("need %v labels, got %v", len(pushes),
+			len(labels))
+	}
+	kit := &backupKit{
+		Network:      params.Name,
+		Address:      contract.Address,
+		M:            contract.M,
+		N:            contract.N,
+		Height:       height,
+		Labels:       labels,
+		RedeemScript: hex.EncodeToString(contract.RedeemScript),
+		Descriptor:   descriptor,
+	}
+	for _, pk := range pushes {
+		kit.Keys = append(kit.Keys, hex.EncodeToString(pk))
+	}
+	return kit, nil
+}
+
+// lines returns the printable kit.
+func (k *backupKit) lines() []string {
+	var b bytes.Buffer
+	field := func(label string, value interface{}) {
+		fmt.Fprintf(&b, "%-13v: %v\n", label, value)
+	}
+	data := func(label string, value string) {
+		field(label, value+"  "+backupChecksum(value))
+	}
+	text := func(indent, s string) {
+		wrap(&b, indent, s, backupTextWidth-len(indent))
+	}
+
+	fmt.Fprintf(&b, "%v\n\n", backupTitle)
+	text("", fmt.Sprintf("This kit defines a %v of %v multisig contract. "+
+		"It holds no private keys, spending requires %v of the "+
+		"cosigner wallets. Every key, script and descriptor line ends "+
+		"with a checksum of its value.", k.M, k.N, k.M))
+	fmt.Fprintf(&b, "\n")
+	field("Network", k.Network)
+	field("Address", k.Address)
+	field("Signatures", fmt.Sprintf("%v of %v", k.M, k.N))
+	field("Height", k.Height)
+	for i := range k.Keys {
+		fmt.Fprintf(&b, "\n")
+		field(fmt.Sprintf("Label %v", i+1), k.Labels[i])
+		data(fmt.Sprintf("Key %v", i+1), k.Keys[i])
+	}
+	fmt.Fprintf(&b, "\n")
+	for i, chunk := range backupChunks(k.RedeemScript) {
+		data(fmt.Sprintf("Script %v", i+1), chunk)
+	}
+	fmt.Fprintf(&b, "\n")
+	for i, chunk := range backupChunks(k.Descriptor) {
+		data(fmt.Sprintf("Descriptor %v", i+1), chunk)
+	}
+
+	fmt.Fprintf(&b, "\nRECOVERY\n\n")
+	steps := []struct {
+		text     string
+		commands []string
+	}{{
+		"Type the kit into a file and verify it on any computer, no " +
+			"wallet or network access is needed. Mistyped lines " +
+			"are reported by line number:",
+		[]string{fmt.Sprintf("dcrms --net=%v verifybackup kit=<file>",
+			k.Network)},
+	}, {
+		fmt.Sprintf("On each of %v cosigner wallets, restored from "+
+			"their own seed, import the redeem script, the Script "+
+			"lines joined, and rescan from height %v:", k.M,
+			k.Height),
+		[]string{"dcrctl --wallet importscript <redeem script>",
+			fmt.Sprintf("dcrctl --wallet rescanwallet %v", k.Height)},
+	}, {
+		"Check the balance, the descriptor is the Descriptor lines " +
+			"joined:",
+		[]string{"dcrms getmultisigbalance address=<descriptor>"},
+	}, {
+		fmt.Sprintf("Create the spend, have %v cosigners sign it in "+
+			"turn and broadcast it:", k.M),
+		[]string{"dcrms createmultisigtx address=<descriptor> " +
+			"to=<address> amount=<amount>",
+			"dcrms signmultisigtx tx=<hextx>",
+			"dcrms broadcastmultisigtx tx=<hextx>"},
+	}}
+	for i, step := range steps {
+		if i > 0 {
+			fmt.Fprintf(&b, "\n")
+		}
+		s := fmt.Sprintf("%v. ", i+1)
+		var t bytes.Buffer
+		wrap(&t, "", step.text, backupTextWidth-len(s))
+		for j, l := range strings.Split(strings.TrimSuffix(t.String(),
+			"\n"), "\n") {
+			if j == 0 {
+				fmt.Fprintf(&b, "%v%v\n", s, l)
+			} else {
+				fmt.Fprintf(&b, "%v%v\n", strings.Repeat(" ",
+					len(s)), l)
+			}
+		}
+		for _, command := range step.commands {
+			fmt.Fprintf(&b, "     %v\n", command)
+		}
+	}
+
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+// parseBackupKit parses a typed in kit. Lines that are not kit fields, such
+// as the instructions, are ignored. It returns the problems found in the
+// lines, e.g. checksum mismatches, along with the kit.
+func parseBackupKit(r io.Reader) (*backupKit, []string, error) {
+	var (
+		kit      backupKit
+		problems []string
+		numbered = make(map[string]map[int]string)
+	)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		s := strings.SplitN(scanner.Text(), ":", 2)
+		if len(s) != 2 {
+			continue
+		}
+		label, value := strings.TrimSpace(s[0]), strings.TrimSpace(s[1])
+		switch label {
+		case "Network":
+			kit.Network = value
+			continue
+		case "Address":
+			kit.Address = value
+			continue
+		case "Signatures":
+			_, err := fmt.Sscanf(value, "%d of %d", &kit.M, &kit.N)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line "+
+					"%v: invalid signatures: %v", n, value))
+			}
+			continue
+		case "Height":
+			h, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line "+
+					"%v: invalid height: %v", n, value))
+			}
+			kit.Height = h
+			continue
+		}
+
+		m := backupLine.FindStringSubmatch(label)
+		if m == nil {
+			continue
+		}
+		i, err := strconv.Atoi(m[2])
+		if err != nil || i <= 0 {
+			problems = append(problems, fmt.Sprintf("line %v: "+
+				"invalid number: %v", n, label))
+			continue
+		}
+		if numbered[m[1]] == nil {
+			numbered[m[1]] = make(map[int]string)
+		}
+		if m[1] != "Label" {
+			// Checksummed data, hex and descriptors are lower case
+			f := strings.Fields(strings.ToLower(value))
+			if len(f) != 2 || backupChecksum(f[0]) != f[1] {
+				problems = append(problems, fmt.Sprintf("line "+
+					"%v: %v checksum mismatch", n, label))
+				numbered[m[1]][i] = ""
+				continue
+			}
+			value = f[0]
+		}
+		numbered[m[1]][i] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if kit.Network == "" {
+		problems = append(problems, "Network missing")
+	}
+	if kit.Address == "" {
+		problems = append(problems, "Address missing")
+	}
+	if kit.N == 0 {
+		problems = append(problems, "Signatures missing")
+	}
+
+	// Put numbered lines in order, lines with problems are already
+	// reported
+	join := func(name string, want int) []string {
+		values := numbered[name]
+		for i := range values {
+			if i > want {
+				want = i
+			}
+		}
+		lines := make([]string, 0, want)
+		for i := 1; i <= want; i++ {
+			v, ok := values[i]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%v %v "+
+					"missing", name, i))
+			case v != "":
+				lines = append(lines, v)
+			}
+		}
+		return lines
+	}
+	kit.Labels = join("Label", kit.N)
+	kit.Keys = join("Key", kit.N)
+	kit.RedeemScript = strings.Join(join("Script", 0), "")
+	kit.Descriptor = strings.Join(join("Descriptor", 0), "")
+	return &kit, problems, nil
+}
+
+// verify returns the reasons the kit does not define the contract of its
+// address on the provided network.
+func (k *backupKit) verify(params *chaincfg.Params) []string {
+	if k.Network != params.Name {
+		return []string{fmt.Sprintf("kit is for %v, not %v", k.Network,
+			params.Name)}
+	}
+	redeemScript, err := hex.DecodeString(k.RedeemScript)
+	if err != nil {
+		return []string{"redeem script is not hex"}
+	}
+	contract, err := multisig.NewContract(redeemScript, params)
+	if err != nil {
+		return []string{fmt.Sprintf("redeem script: %v", err)}
+	}
+	want, err := newBackupKit(contract, nil, k.Height, params)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if contract.Address != k.Address {
+		problems = append(problems, fmt.Sprintf("redeem script "+
+			"computes address %v, not %v", contract.Address,
+			k.Address))
+	}
+	if contract.M != k.M || contract.N != k.N {
+		problems = append(problems, fmt.Sprintf("redeem script is %v "+
+			"of %v, not %v of %v", contract.M, contract.N, k.M, k.N))
+	}
+	for i := range want.Keys {
+		if i >= len(k.Keys) || want.Keys[i] != k.Keys[i] {
+			problems = append(problems, fmt.Sprintf("Key %v does "+
+				"not match the redeem script", i+1))
+		}
+	}
+	descriptor, err := multisig.ParseDescriptor(k.Descriptor, params)
+	switch {
+	case err != nil:
+		problems = append(problems, fmt.Sprintf("descriptor: %v", err))
+	case descriptor.Address != k.Address:
+		problems = append(problems, fmt.Sprintf("descriptor computes "+
+			"address %v, not %v", descriptor.Address, k.Address))
+	}
+	return problems
+}
+
+// creationHeight returns the height of the oldest confirmed utxo of the
+// address, or the chain tip when it has none. Rescanning from it finds all
+// funds of the contract.
+func (c *client) creationHeight(ctx context.Context, address string) (int64, error) {
+	utxos, err := c.ms.Utxos(ctx, &multisig.UtxoRequest{
+		Addresses: []string{address},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var height int64
+	for _, u := range utxos {
+		if u.Height > 0 && (height == 0 || u.Height < height) {
+			height = u.Height
+		}
+	}
+	if height > 0 {
+		return height, nil
+	}
+	tip, err := c.bestBlock(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("best block: %v", err)
+	}
+	return int64(tip.Height), nil
+}
+
+func (c *client) backupContract(ctx context.Context, a map[string]string) error {
+	address, err := ArgAsString("address", a)
+	if err != nil {
+		return err
+	}
+	format, err := ArgAsString("format", a)
+	if err != nil {
+		return err
+	}
+	var labels []string
+	if _, ok := a["labels"]; ok {
+		labels, err = ArgAsStringSlice("labels", a)
+		if err != nil {
+			return err
+		}
+	}
+
+	contract, err := c.contractInfo(ctx, address)
+	if err != nil {
+		return err
+	}
+	var height int64
+	if _, ok := a["height"]; ok {
+		h, err := ArgAsUint("height", a)
+		if err != nil {
+			return err
+		}
+		height = int64(h)
+	} else {
+		height, err = c.creationHeight(ctx, contract.Address)
+		if err != nil {
+			return err
+		}
+	}
+	kit, err := newBackupKit(contract, labels, height, c.cfg.params)
+	if err != nil {
+		return err
+	}
+
+	if format == "pdf" {
+		return writePDF(os.Stdout, kit.lines())
+	}
+	for _, l := range kit.lines() {
+		fmt.Printf("%v\n", l)
+	}
+	return nil
+}
+
+func (c *client) verifyBackup(ctx context.Context, a map[string]string) error {
+	// Without a file the kit is typed in
+	var r io.Reader = os.Stdin
+	if filename, ok := a["kit"]; ok {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	kit, problems, err := parseBackupKit(r)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		problems = kit.verify(c.cfg.params)
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Printf("%v\n", p)
+		}
+		return fmt.Errorf("BACKUP DOES NOT VERIFY")
+	}
+
+	fmt.Printf("Address      : %v\n", kit.Address)
+	fmt.Printf("Signatures   : %v of %v\n", kit.M, kit.N)
+	fmt.Printf("Height       : %v\n", kit.Height)
+	fmt.Printf("Descriptor   : %v\n", kit.Descriptor)
+	fmt.Printf("BACKUP VERIFIED\n")
+	return nil
+}
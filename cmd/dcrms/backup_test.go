@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// TestBackup prints the kit of a contract, types it back in and verifies that
+// mistakes are found.
+func TestBackup(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "carol")
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		clients[name] = newClient(cfg)
+	}
+	alice, carol := clients["alice"], clients["carol"]
+
+	var keys []string
+	for _, name := range []string{"alice", "bob", "carol"} {
+		keys = append(keys, lastLine(run(t, clients[name], "getnewkey",
+			"contract=escrow")))
+	}
+	lines := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")
+	address, descriptor := lines[0], lines[2]
+
+	// Without funds the kit starts at the tip, after funding at the
+	// oldest utxo.
+	out := run(t, carol, "backupcontract", "address="+descriptor)
+	if field(t, out, "Height") != strconv.Itoa(mockHeight) {
+		t.Fatalf("got %v", out)
+	}
+	expect(t, out, "Cosigner 3")
+	run(t, alice, "sendtomultisig", "address="+address, "amount=1")
+	kit := run(t, alice, "backupcontract", "address="+address,
+		"labels=alice,bob,carol")
+	if field(t, kit, "Height") !=
+		strconv.Itoa(mockHeight-mockConfirmations+1) ||
+		field(t, kit, "Address") != address ||
+		field(t, kit, "Signatures") != "2 of 3" ||
+		field(t, kit, "Label 2") != "bob" {
+		t.Fatalf("got %v", kit)
+	}
+	for k := range keys {
+		key := strings.Fields(field(t, kit, "Key "+strconv.Itoa(k+1)))[0]
+		expect(t, descriptor, key)
+	}
+
+	// The kit is typed in on an offline computer.
+	dir := t.TempDir()
+	verify := func(kit string) (string, error) {
+		t.Helper()
+		filename := filepath.Join(dir, "kit.txt")
+		err := ioutil.WriteFile(filename, []byte(kit), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return capture(t, func() error {
+			return carol.run(context.Background(), []string{
+				"verifybackup", "kit=" + filename})
+		})
+	}
+	typed := regexp.MustCompile(`(?m): [0-9a-f]+  [0-9a-f]{4}$`).
+		ReplaceAllStringFunc(kit, strings.ToUpper)
+	out, err := verify(typed)
+	if err != nil {
+		t.Fatalf("%v: %v", err, out)
+	}
+	expect(t, out, "BACKUP VERIFIED")
+	if field(t, out, "Address") != address ||
+		field(t, out, "Descriptor") != descriptor {
+		t.Fatalf("got %v", out)
+	}
+
+	kitLines := strings.Split(kit, "\n")
+	line := func(label string) int {
+		for k, l := range kitLines {
+			if strings.HasPrefix(l, label+" ") {
+				return k
+			}
+		}
+		t.Fatalf("%v not found", label)
+		return 0
+	}
+	mistype := func(label string, f func(string) string) string {
+		l := append([]string(nil), kitLines...)
+		k := line(label)
+		l[k] = f(l[k])
+		return strings.Join(l, "\n")
+	}
+	flip := func(s string) string {
+		i := strings.Index(s, ": ") + 4
+		c := byte('0')
+		if s[i] == '0' {
+			c = '1'
+		}
+		return s[:i] + string(c) + s[i+1:]
+	}
+	tests := []struct {
+		name string
+		kit  string
+		want string
+	}{{
+		"mistyped script",
+		mistype("Script 2", flip),
+		"line " + strconv.Itoa(line("Script 2")+1) +
+			": Script 2 checksum mismatch",
+	}, {
+		"mistyped key",
+		mistype("Key 3", flip),
+		"Key 3 checksum mismatch",
+	}, {
+		"missing descriptor line",
+		mistype("Descriptor 2", func(string) string { return "" }),
+		"Descriptor 2 missing",
+	}, {
+		"other address",
+		mistype("Address", func(string) string {
+			return "Address      : " + payee
+		}),
+		"redeem script computes address " + address,
+	}}
+	for _, tt := range tests {
+		out, err := verify(tt.kit)
+		if err == nil || !strings.Contains(err.Error(), "NOT VERIFY") {
+			t.Fatalf("%v: got %v", tt.name, err)
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Fatalf("%v: %q not found in %q", tt.name, tt.want, out)
+		}
+	}
+
+	// The kit is bound to its network.
+	k, problems, err := parseBackupKit(strings.NewReader(kit))
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("%v %v", err, problems)
+	}
+	problems = k.verify(chaincfg.MainNetParams())
+	if len(problems) != 1 || problems[0] != "kit is for testnet3, not "+
+		"mainnet" {
+		t.Fatalf("got %v", problems)
+	}
+
+	// The PDF holds the same lines.
+	pdf := run(t, alice, "backupcontract", "address="+address,
+		"format=pdf")
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") ||
+		!strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatalf("not a PDF: %.40q", pdf)
+	}
+	expect(t, pdf, "(Address      : "+address+") '")
+	expect(t, pdf, "/Count 1")
+}
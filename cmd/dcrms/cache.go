@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v3"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+const (
+	cacheTipFile = "tip.json"
+	cacheTxDir   = "tx"
+	cacheUtxoDir = "utxo"
+)
+
+// cacheTip is the chain tip that was seen when the cache was last used.
+type cacheTip struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// cacheUtxos is an on-disk UTXO list. It is valid for the cache TTL as long
+// as the chain tip did not move.
+type cacheUtxos struct {
+	Tip       string                `json:"tip"`
+	Timestamp int64                 `json:"timestamp"`
+	Utxos     []it.AddressTxnOutput `json:"utxos"`
+}
+
+// cache is an on-disk, per network, cache of explorer replies. Confirmed raw
+// transactions are immutable and are cached until a reorg is detected. UTXO
+// lists are cached for a short time only.
+type cache struct {
+	dir string
+	ttl time.Duration
+
+	sync.Mutex
+	tip string // Empty until the tip has been seen
+}
+
+func newCache(dir string, ttl time.Duration) *cache {
+	return &cache{
+		dir: dir,
+		ttl: ttl,
+	}
+}
+
+// writeFileAtomic writes a file by writing a temporary file in the same
+// directory and renaming it. Readers see either the old or the new content,
+// never a partially written file.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// errLocked is returned by lockFile when the lock is not released in time.
+var errLocked = errors.New("locked")
+
+// lockFile takes the lock with the provided filename. The lock is a file that
+// is created exclusively. It serializes updates by processes on the same
+// computer only, sync tools do not propagate exclusive creation. It waits up
+// to timeout for the lock to be released and returns errLocked thereafter.
+// The returned function releases the lock.
+func lockFile(filename string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+			0600)
+		if err == nil {
+			host, _ := os.Hostname()
+			fmt.Fprintf(f, "%v %v %v\n", host, os.Getpid(),
+				time.Now().Unix())
+			f.Close()
+			return func() {
+				err := os.Remove(filename)
+				if err != nil {
+					log.Warningf("unlock %v: %v", filename, err)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// writeFile atomically writes a file into the cache.
+func (ca *cache) writeFile(name string, data []byte) error {
+	filename := filepath.Join(ca.dir, name)
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// invalidate removes all cached explorer replies.
+func (ca *cache) invalidate() error {
+	for _, d := range []string{cacheTxDir, cacheUtxoDir} {
+		err := os.RemoveAll(filepath.Join(ca.dir, d))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateUtxos removes the cached UTXO lists.
+func (ca *cache) invalidateUtxos() error {
+	return os.RemoveAll(filepath.Join(ca.dir, cacheUtxoDir))
+}
+
+// bestBlock returns the current chain tip as seen by dcrdata.
+func (c *client) bestBlock(ctx context.Context) (*cacheTip, error) {
+	var bdb it.BlockDataBasic
+	url := c.cfg.dcrdata + "/block/best"
+	err := c.httpRequestJSON(ctx, url, &bdb)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTip{Height: bdb.Height, Hash: bdb.Hash}, nil
+}
+
+// blockHash returns the hash of the main chain block at the provided height.
+func (c *client) blockHash(ctx context.Context, height uint32) (string, error) {
+	url := c.cfg.dcrdata + "/block/" + strconv.FormatUint(uint64(height),
+		10) + "/hash"
+	hash, err := c.httpRequest(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(hash)), nil
+}
+
+// reorged returns true if the previously seen tip is no longer part of the
+// main chain.
+func (c *client) reorged(ctx context.Context, old, tip *cacheTip) (bool, error) {
+	switch {
+	case tip.Height < old.Height:
+		return true, nil
+	case tip.Height == old.Height:
+		return tip.Hash != old.Hash, nil
+	}
+	hash, err := c.blockHash(ctx, old.Height)
+	if err != nil {
+		return false, err
+	}
+	return hash != old.Hash, nil
+}
+
+// cacheTip returns the current chain tip hash. The first successful call
+// compares the tip with the one recorded in the cache and invalidates the
+// cache if a reorg occurred. Failures are not remembered, the next call tries
+// again.
+func (c *client) cacheTip(ctx context.Context) (string, error) {
+	ca := c.cache
+	ca.Lock()
+	defer ca.Unlock()
+	if ca.tip != "" {
+		return ca.tip, nil
+	}
+
+	tip, err := c.bestBlock(ctx)
+	if err != nil {
+		return "", err
+	}
+	var old cacheTip
+	filename := filepath.Join(ca.dir, cacheTipFile)
+	b, err := ioutil.ReadFile(filename)
+	if err == nil && json.Unmarshal(b, &old) == nil {
+		reorg, err := c.reorged(ctx, &old, tip)
+		if err != nil {
+			return "", err
+		}
+		if reorg {
+			log.Infof("Reorg detected, invalidating cache: %v %v",
+				old.Height, old.Hash)
+			err = ca.invalidate()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	b, err = json.Marshal(tip)
+	if err != nil {
+		return "", err
+	}
+	err = ca.writeFile(cacheTipFile, b)
+	if err != nil {
+		return "", err
+	}
+	ca.tip = tip.Hash
+	return ca.tip, nil
+}
+
+// utxosSpent drops the cached UTXO lists after a transaction that may have
+// spent some of them was broadcast.
+func (c *client) utxosSpent() {
+	if c.cache == nil {
+		return
+	}
+	err := c.cache.invalidateUtxos()
+	if err != nil {
+		log.Warningf("utxo cache: %v", err)
+	}
+}
+
+// getRawTx returns the hex encoded raw transaction for the provided hash.
+// Confirmed transactions are cached. The cache is bypassed when the chain
+// tip, and therefore a reorg, can not be determined.
+func (c *client) getRawTx(ctx context.Context, txID *chainhash.Hash, confirmed bool) ([]byte, error) {
+	name := filepath.Join(cacheTxDir, txID.String())
+	useCache := c.cache != nil && confirmed
+	if useCache {
+		if _, err := c.cacheTip(ctx); err != nil {
+			log.Warningf("getRawTx: not cached, unknown tip: %v",
+				err)
+			useCache = false
+		}
+	}
+	if useCache {
+		rawTx, err := ioutil.ReadFile(filepath.Join(c.cache.dir, name))
+		if err == nil {
+			log.Debugf("getRawTx: cached %v", txID)
+			return rawTx, nil
+		}
+	}
+
+	url := c.cfg.dcrdata + "/tx/hex/" + txID.String()
+	rawTx, err := c.httpRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	log.Tracef("%v", spew.Sdump(rawTx))
+
+	if useCache {
+		err = c.cache.writeFile(name, rawTx)
+		if err != nil {
+			log.Warningf("getRawTx: cache %v: %v", txID, err)
+		}
+	}
+
+	return rawTx, nil
+}
+
+// fetchUtxos returns all utxos, regardless of confirmations, of the provided
+// address. Replies are cached for the cache TTL as long as the chain tip
+// does not move. The cache is bypassed when the chain tip is unknown.
+func (c *client) fetchUtxos(ctx context.Context, address string) ([]it.AddressTxnOutput, error) {
+	// Only cache valid addresses since the address is used as a
+	// filename.
+	useCache := c.cache != nil
+	if _, err := dcrutil.DecodeAddress(address, c.cfg.params); err != nil {
+		useCache = false
+	}
+
+	var tip string
+	name := filepath.Join(cacheUtxoDir, address+".json")
+	if useCache {
+		var err error
+		tip, err = c.cacheTip(ctx)
+		if err != nil {
+			log.Warningf("fetchUtxos: not cached, unknown tip: %v",
+				err)
+			useCache = false
+		}
+	}
+	if useCache {
+		var cu cacheUtxos
+		b, err := ioutil.ReadFile(filepath.Join(c.cache.dir, name))
+		if err == nil && json.Unmarshal(b, &cu) == nil &&
+			cu.Tip == tip &&
+			time.Since(time.Unix(cu.Timestamp, 0)) < c.cache.ttl {
+			log.Debugf("fetchUtxos: cached %v", address)
+			return cu.Utxos, nil
+		}
+	}
+
+	var utxos []it.AddressTxnOutput
+	url := c.cfg.insight + "/addr/" + address + "/utxo"
+	err := c.httpRequestJSON(ctx, url, &utxos)
+	if err != nil {
+		return nil, err
+	}
+	log.Tracef("%v", spew.Sdump(utxos))
+
+	if useCache {
+		b, err := json.Marshal(cacheUtxos{
+			Tip:       tip,
+			Timestamp: time.Now().Unix(),
+			Utxos:     utxos,
+		})
+		if err == nil {
+			err = c.cache.writeFile(name, b)
+		}
+		if err != nil {
+			log.Warningf("fetchUtxos: cache %v: %v", address, err)
+		}
+	}
+
+	return utxos, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+// cacheExplorer is an explorer whose chain tip can be moved and reorged. It
+// counts the requests that would have been served from the cache.
+type cacheExplorer struct {
+	sync.Mutex
+	height    uint32
+	hashes    map[uint32]string // Main chain block hashes by height
+	bestFails bool
+	txs       int
+	utxos     int
+}
+
+func (ce *cacheExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ce.Lock()
+	defer ce.Unlock()
+	path := r.URL.Path
+	switch {
+	case path == "/api/block/best":
+		if ce.bestFails {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"height": ce.height, "hash": ce.hashes[ce.height]})
+	case strings.HasPrefix(path, "/api/block/"):
+		height, _ := strconv.Atoi(strings.TrimSuffix(
+			strings.TrimPrefix(path, "/api/block/"), "/hash"))
+		fmt.Fprint(w, ce.hashes[uint32(height)])
+	case strings.HasPrefix(path, "/api/tx/hex/"):
+		ce.txs++
+		fmt.Fprint(w, "00")
+	case strings.HasPrefix(path, "/insight/api/addr/"):
+		ce.utxos++
+		json.NewEncoder(w).Encode([]it.AddressTxnOutput{{
+			Address: escrowAddr, TxnID: fundingTxID, Satoshis: 1e8,
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// extend mines a block on top of the tip.
+func (ce *cacheExplorer) extend(hash string) {
+	ce.Lock()
+	defer ce.Unlock()
+	ce.height++
+	ce.hashes[ce.height] = hash
+}
+
+// reorg replaces the tip.
+func (ce *cacheExplorer) reorg(hash string) {
+	ce.Lock()
+	defer ce.Unlock()
+	ce.hashes[ce.height] = hash
+}
+
+// counts returns the number of transaction and utxo requests.
+func (ce *cacheExplorer) counts() (int, int) {
+	ce.Lock()
+	defer ce.Unlock()
+	return ce.txs, ce.utxos
+}
+
+// newCacheExplorer returns an explorer and a function that returns a client
+// that caches in dir, as a new invocation of the tool would.
+func newCacheExplorer(t *testing.T) (*cacheExplorer, func(time.Duration) *client) {
+	t.Helper()
+	ce := &cacheExplorer{
+		height: 100,
+		hashes: map[uint32]string{100: strings.Repeat("01", 32)},
+	}
+	srv := httptest.NewServer(ce)
+	t.Cleanup(srv.Close)
+	dir := t.TempDir()
+	return ce, func(ttl time.Duration) *client {
+		return newClient(&config{
+			HTTPTimeout:     5 * time.Second,
+			HTTPMaxResponse: defaultHTTPMaxResponse,
+			CacheTTL:        ttl,
+			dcrdata:         srv.URL + "/api",
+			insight:         srv.URL + "/insight/api",
+			params:          chaincfg.TestNet3Params(),
+			cacheDir:        dir,
+		})
+	}
+}
+
+func TestCacheUtxoTTL(t *testing.T) {
+	ce, newCachingClient := newCacheExplorer(t)
+	ctx := context.Background()
+	fetch := func(c *client, want int) {
+		t.Helper()
+		_, err := c.fetchUtxos(ctx, escrowAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, n := ce.counts(); n != want {
+			t.Fatalf("got %v utxo requests, want %v", n, want)
+		}
+	}
+
+	c := newCachingClient(time.Hour)
+	fetch(c, 1)
+	fetch(c, 1)
+	fetch(newCachingClient(time.Hour), 1)
+
+	// Expired.
+	fetch(newCachingClient(time.Nanosecond), 2)
+
+	// The tip moved.
+	ce.extend(strings.Repeat("02", 32))
+	c = newCachingClient(time.Hour)
+	fetch(c, 3)
+	fetch(c, 3)
+
+	// Spent by a broadcast.
+	c.utxosSpent()
+	fetch(c, 4)
+}
+
+func TestCacheReorg(t *testing.T) {
+	ce, newCachingClient := newCacheExplorer(t)
+	txID := chainhash.HashH([]byte("tx"))
+	fetch := func(want int) {
+		t.Helper()
+		_, err := newCachingClient(time.Hour).getRawTx(
+			context.Background(), &txID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := ce.counts(); n != want {
+			t.Fatalf("got %v tx requests, want %v", n, want)
+		}
+	}
+
+	fetch(1)
+	fetch(1)
+
+	// The recorded tip is still part of the main chain.
+	ce.extend(strings.Repeat("02", 32))
+	fetch(1)
+
+	// The recorded tip was reorged out.
+	ce.reorg(strings.Repeat("03", 32))
+	fetch(2)
+	fetch(2)
+}
+
+func TestCacheUnknownTip(t *testing.T) {
+	ce, newCachingClient := newCacheExplorer(t)
+	ce.bestFails = true
+	c := newCachingClient(time.Hour)
+	ctx := context.Background()
+	txID := chainhash.HashH([]byte("tx"))
+
+	// Without the tip nothing is served from or written to the cache.
+	for i := 0; i < 2; i++ {
+		_, err := c.getRawTx(ctx, &txID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.fetchUtxos(ctx, escrowAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if txs, utxos := ce.counts(); txs != 2 || utxos != 2 {
+		t.Fatalf("got %v %v", txs, utxos)
+	}
+	_, err := os.Stat(filepath.Join(c.cfg.cacheDir, cacheTxDir))
+	if !os.IsNotExist(err) {
+		t.Fatalf("cache written: %v", err)
+	}
+
+	// Once the tip is known again the cache is used.
+	ce.Lock()
+	ce.bestFails = false
+	ce.Unlock()
+	for i := 0; i < 2; i++ {
+		_, err := c.getRawTx(ctx, &txID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if txs, _ := ce.counts(); txs != 3 {
+		t.Fatalf("got %v", txs)
+	}
+}
+
+// TestCacheBroadcast verifies that spent utxos are not listed from the cache
+// after a broadcast.
+func TestCacheBroadcast(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		cfg.NoCache = false
+		cfg.CacheTTL = time.Hour
+		cfg.cacheDir = t.TempDir()
+		clients[name] = newClient(cfg)
+	}
+	alice, bob := clients["alice"], clients["bob"]
+
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	address := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")[0]
+	run(t, alice, "sendtomultisig", "address="+address, "amount=1")
+	expect(t, run(t, bob, "listmultisigutxos", "address="+address),
+		" 1 DCR")
+
+	tx := lastLine(run(t, alice, "createmultisigtx", "address="+address,
+		"to="+payee, "amount=0.5"))
+	tx = lastLine(run(t, alice, "signmultisigtx", "tx="+tx))
+	tx = lastLine(run(t, bob, "signmultisigtx", "tx="+tx))
+	run(t, bob, "broadcastmultisigtx", "tx="+tx)
+	out := run(t, bob, "listmultisigutxos", "address="+address)
+	if strings.Contains(out, " 1 DCR") {
+		t.Fatalf("spent utxo listed: %v", out)
+	}
+}
@@ -8,14 +8,21 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/decred/dcrd/chaincfg/v3"
 	"github.com/decred/dcrd/dcrutil"
+	"github.com/inhies/go-bytesize"
 	"github.com/jrick/flagfile"
 )
 
 const (
-	defaultLogging = "dcrms=INFO"
+	defaultLogging         = "dcrms=INFO"
+	defaultHTTPTimeout     = 30 * time.Second
+	defaultHTTPRetries     = 3
+	defaultHTTPMaxResponse = 8 * bytesize.MB
+	defaultCacheTTL        = time.Minute
+	defaultFetchWorkers    = 8
 )
 
 var (
@@ -34,77 +41,87 @@ func versionString() string {
 }
 
 type config struct {
-	Config      flag.Value
-	ShowVersion bool
-	Cert        string
-	Wallet      string
-	User        string
-	Pass        string
-	Net         string
-	Log         string
+	Config       flag.Value
+	ShowVersion  bool
+	Cert         string
+	Wallet       string
+	User         string
+	Pass         string
+	Net          string
+	Log          string
+	Proxy        string
+	ProxyUser    string
+	ProxyPass    string
+	TorIsolation bool
+
+	HTTPTimeout     time.Duration
+	HTTPRetries     int
+	HTTPMaxResponse bytesize.ByteSize
+	NoCache         bool
+	CacheTTL        time.Duration
+	FetchWorkers    int
+	ProposalDir     string
+	QR              string
+	QRIn            qrInputs
 
 	ca      []byte // wallet cert
 	wallet  string // wallet websocke
 	dcrdata string
 	insight string
 	params  *chaincfg.Params
-}
 
-func usage() {
-	fmt.Fprintf(os.Stderr, `Usage of dcrms:
-  dcrms [flags] action <args...>
-Flags:
-  -C <filename>
-        Configuration file
-  -v	Show version and exit
-  -cert <certificate>
-	Wallet certificate (uses ~/dcrwallet/rpc.cert by default)
-  -wallet <websocket>
-	Wallet websocket URL. Default wss://localhost:9110/ws
-  -user <username>
-	RPC user (reads dcrwallet.conf for defaults)
-  -pass <password>
-	RPC password (reads dcrwallet.conf for defaults)
-  -net <network>
-	Network, mainnet or testnet3, default mainnet
-  -log	default logging level, default: dcrms=INFO
-Actions:
-  getmultisigbalance address=<address>
-	Print the balance of the multisig address.
-  getwalletbalance
-	Print total spendable wallet amount
-  getnewkey
-	Obtain a new public key for a multisig contract
-  createmultisigaddress n=<number of signatures required> keys=<public key>,<...>
-	Create a multisig address that requires n signatures out of number of keys
-  sendtomultisig address=<address> amount=<amount>
-	Send funds to an address; wallet must be unlocked
-  createmultisigtx address=<address> to=<address> amount=<amount> confirmations=<number>
-	Create an unsigned multisig transaction
-  signmultisigtx tx=<partially signed transaction>
-	Partially, or fully, sign, a multisig transation
-  broadcastmultisigtx tx=<signed multisig tx>
-	Broadcast multi signature transaction to the network
-  multisiginfo address=<public key>
-	Print information about the multisg address
-  sweepmultisig address=<publickey>
-	Not implemented yet.
-`)
-	os.Exit(2)
+	cacheDir  string // explorer cache for this network
+	policyDir string // signing policies
+	auditDir  string // audit log
+
+	coordinatorDir string // coordinator proposal store
 }
 
 func (c *config) FlagSet() *flag.FlagSet {
 	fs := flag.NewFlagSet("dcrms", flag.ExitOnError)
 	configParser := flagfile.Parser{AllowUnknown: false}
 	c.Config = configParser.ConfigFlag(fs)
-	fs.Var(c.Config, "C", "config file")
-	fs.BoolVar(&c.ShowVersion, "v", false, "")
-	fs.StringVar(&c.Cert, "cert", dcrwalletCert, "")
-	fs.StringVar(&c.Wallet, "wallet", "", "")
-	fs.StringVar(&c.User, "user", "", "")
-	fs.StringVar(&c.Pass, "key", "", "")
-	fs.StringVar(&c.Net, "net", "mainnet", "")
-	fs.StringVar(&c.Log, "log", defaultLogging, "")
+	fs.Var(c.Config, "C", "Configuration `filename`")
+	fs.BoolVar(&c.ShowVersion, "v", false, "Show version and exit")
+	fs.StringVar(&c.Cert, "cert", dcrwalletCert, "Wallet `certificate`")
+	fs.StringVar(&c.Wallet, "wallet", "", "Wallet `websocket` URL, "+
+		"default wss://localhost:9110/ws or wss://localhost:19110/ws "+
+		"on testnet3")
+	fs.StringVar(&c.User, "user", "", "RPC `username`, read from "+
+		"dcrwallet.conf when not set")
+	fs.StringVar(&c.Pass, "key", "", "RPC `password`, read from "+
+		"dcrwallet.conf when not set")
+	fs.StringVar(&c.Net, "net", "mainnet", "Network `name`, mainnet "+
+		"or testnet3")
+	fs.StringVar(&c.Log, "log", defaultLogging, "Logging `levels`")
+	fs.StringVar(&c.Proxy, "proxy", "", "SOCKS5 proxy `host:port` used "+
+		"for all outbound connections, e.g. 127.0.0.1:9050")
+	fs.StringVar(&c.ProxyUser, "proxyuser", "", "SOCKS5 proxy `username`")
+	fs.StringVar(&c.ProxyPass, "proxypass", "", "SOCKS5 proxy `password`")
+	fs.BoolVar(&c.TorIsolation, "torisolation", false, "Use a new Tor "+
+		"circuit for every connection (requires -proxy)")
+	fs.DurationVar(&c.HTTPTimeout, "httptimeout", defaultHTTPTimeout,
+		"Timeout of a single explorer request")
+	fs.IntVar(&c.HTTPRetries, "httpretries", defaultHTTPRetries,
+		"Retry an unavailable explorer `number` times")
+	c.HTTPMaxResponse = defaultHTTPMaxResponse
+	fs.Var(&c.HTTPMaxResponse, "httpmaxresponse", "Maximum `size` of an "+
+		"explorer response")
+	fs.BoolVar(&c.NoCache, "nocache", false, "Do not cache explorer "+
+		"replies on disk")
+	fs.DurationVar(&c.CacheTTL, "cachettl", defaultCacheTTL,
+		"How long utxo lists are cached")
+	fs.IntVar(&c.FetchWorkers, "fetchworkers", defaultFetchWorkers,
+		"Look up at most `number` previous transactions "+
+			"concurrently")
+	fs.StringVar(&c.ProposalDir, "proposaldir", "", "Shared `directory`, "+
+		"e.g. a synced folder, that holds proposals")
+	fs.StringVar(&c.QR, "qr", "", "Also render transactions, keys and "+
+		"contracts as QR codes: terminal, a .png `file`, numbered "+
+		"when the payload needs several frames, or an animated .gif "+
+		"file; numbered per transaction when there are several")
+	fs.Var(&c.QRIn, "qrin", "Read an action argument from QR codes in "+
+		".png or .gif files, `name=file[,file...]`, may be repeated")
 	fs.Usage = usage
 	return fs
 }
@@ -189,6 +206,15 @@ func loadConfig() (*config, []string, error) {
 		os.Exit(0)
 	}
 
+	// Help and completion work without a wallet.
+	if ok, err := runBuiltin(os.Stdout, fs.Args()); ok {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	switch cfg.Net {
 	case "mainnet":
 		cfg.dcrdata = "https://explorer.dcrdata.org/api"
@@ -207,6 +233,59 @@ func loadConfig() (*config, []string, error) {
 	default:
 		return nil, nil, fmt.Errorf("invalid net: %v", cfg.Net)
 	}
+	if cfg.Wallet != "" {
+		cfg.wallet = cfg.Wallet
+	}
+	cfg.cacheDir = filepath.Join(defaultHomeDir, "cache", cfg.Net)
+	cfg.policyDir = filepath.Join(defaultHomeDir, "policy")
+	cfg.auditDir = defaultHomeDir
+	cfg.coordinatorDir = filepath.Join(defaultHomeDir, "coordinator")
+
+	if cfg.HTTPTimeout <= 0 {
+		return nil, nil, fmt.Errorf("invalid httptimeout: %v",
+			cfg.HTTPTimeout)
+	}
+	if cfg.HTTPRetries < 0 {
+		return nil, nil, fmt.Errorf("invalid httpretries: %v",
+			cfg.HTTPRetries)
+	}
+	if cfg.HTTPMaxResponse <= 0 {
+		return nil, nil, fmt.Errorf("invalid httpmaxresponse: %v",
+			cfg.HTTPMaxResponse)
+	}
+
+	if cfg.FetchWorkers <= 0 {
+		return nil, nil, fmt.Errorf("invalid fetchworkers: %v",
+			cfg.FetchWorkers)
+	}
+	if cfg.ProposalDir != "" {
+		cfg.ProposalDir = cleanAndExpandPath(cfg.ProposalDir)
+	}
+
+	switch strings.ToLower(filepath.Ext(cfg.QR)) {
+	case ".png", ".gif":
+	default:
+		if cfg.QR != "" && cfg.QR != "terminal" {
+			return nil, nil, fmt.Errorf("invalid qr: %v, use "+
+				"terminal or a .png or .gif file", cfg.QR)
+		}
+	}
+
+	if cfg.TorIsolation && cfg.Proxy == "" {
+		return nil, nil, fmt.Errorf("torisolation requires proxy")
+	}
+	if cfg.TorIsolation && (cfg.ProxyUser != "" || cfg.ProxyPass != "") {
+		return nil, nil, fmt.Errorf("torisolation and proxy " +
+			"credentials are mutually exclusive")
+	}
+
+	// Offline actions do not talk to the wallet.
+	if len(fs.Args()) > 0 {
+		ac, _, err := lookupAction(fs.Args())
+		if err == nil && ac.offline {
+			return cfg, fs.Args(), nil
+		}
+	}
 
 	if cfg.User == "" {
 		dcrwalletFlags.StringVar(&cfg.User, "username", "", "rpc user")
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcopeereboom/dcrms/multisig"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultCoordinatorListen = "127.0.0.1:8089"
+
+	coordinatorRoute = "/v1/proposals"
+
+	// coordinatorMaxReply is the maximum size of a coordinator reply.
+	coordinatorMaxReply = 32 << 20
+)
+
+var proposalsBucket = []byte("proposals")
+
+// proposalRecord is a transaction proposal as stored by the coordinator. Tx
+// holds all signatures collected so far.
+type proposalRecord struct {
+	ID        string `json:"id"` // Txid, signatures do not change it
+	Tx        string `json:"tx"`
+	Created   int64  `json:"created"`
+	Updated   int64  `json:"updated"`
+	Broadcast string `json:"broadcast,omitempty"` // Txid once broadcast
+}
+
+// proposalInput is the signing status of a single proposal input.
+type proposalInput struct {
+	OutPoint   string   `json:"outpoint"`
+	Signatures int      `json:"signatures"`
+	Required   int      `json:"required"`
+	Signers    []string `json:"signers"` // Public key addresses
+}
+
+// proposalReply is returned by all coordinator calls that deal with a single
+// proposal.
+type proposalReply struct {
+	proposalRecord
+	Inputs   []proposalInput `json:"inputs"`
+	Complete bool            `json:"complete"`
+}
+
+// proposalRequest uploads a transaction, either a new proposal or one that
+// carries additional signatures.
+type proposalRequest struct {
+	Tx string `json:"tx"`
+}
+
+// coordinatorError is the reply of a failed coordinator call.
+type coordinatorError struct {
+	Code    int    `json:"-"`
+	Message string `json:"error"`
+}
+
+// Error satisfies the error interface.
+func (e *coordinatorError) Error() string {
+	return e.Message
+}
+
+// errorf returns a coordinator error with the provided HTTP status code.
+func errorf(code int, format string, args ...interface{}) *coordinatorError {
+	return &coordinatorError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// proposalReply returns the signing status of the provided proposal.
+func (c *client) proposalReply(p *proposalRecord) (*proposalReply, error) {
+	tx, err := multisig.DecodeTx(p.Tx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := multisig.SigningStatus(tx)
+	if err != nil {
+		return nil, err
+	}
+	reply := proposalReply{
+		proposalRecord: *p,
+		Inputs:         make([]proposalInput, 0, len(status)),
+		Complete:       true,
+	}
+	for k, s := range status {
+		signers, err := multisig.InputSigners(tx, k)
+		if err != nil {
+			return nil, err
+		}
+		reply.Inputs = append(reply.Inputs, proposalInput{
+			OutPoint:   s.OutPoint.String(),
+			Signatures: s.Signatures,
+			Required:   s.Required,
+			Signers:    pubKeyAddresses(signers, c.cfg.params),
+		})
+		if s.Signatures < s.Required {
+			reply.Complete = false
+		}
+	}
+	return &reply, nil
+}
+
+// coordinator is the HTTP/JSON service that collects signatures for
+// proposals.
+type coordinator struct {
+	c  *client
+	db *bolt.DB
+}
+
+// get returns the proposal with the provided id.
+func (co *coordinator) get(tx *bolt.Tx, id string) (*proposalRecord, error) {
+	b := tx.Bucket(proposalsBucket).Get([]byte(id))
+	if b == nil {
+		return nil, errorf(http.StatusNotFound,
+			"proposal not found: %v", id)
+	}
+	var p proposalRecord
+	err := json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("proposal %v: %v", id, err)
+	}
+	return &p, nil
+}
+
+// put stores the provided proposal.
+func (co *coordinator) put(tx *bolt.Tx, p *proposalRecord) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(proposalsBucket).Put([]byte(p.ID), b)
+}
+
+// list returns all proposals.
+func (co *coordinator) list() ([]proposalReply, error) {
+	replies := make([]proposalReply, 0)
+	err := co.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(proposalsBucket).ForEach(func(k, v []byte) error {
+			var p proposalRecord
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return fmt.Errorf("proposal %s: %v", k, err)
+			}
+			r, err := co.c.proposalReply(&p)
+			if err != nil {
+				return fmt.Errorf("proposal %s: %v", k, err)
+			}
+			replies = append(replies, *r)
+			return nil
+		})
+	})
+	return replies, err
+}
+
+// proposal returns the proposal with the provided id.
+func (co *coordinator) proposal(id string) (*proposalReply, error) {
+	var p *proposalRecord
+	err := co.db.View(func(tx *bolt.Tx) error {
+		var err error
+		p, err = co.get(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return co.c.proposalReply(p)
+}
+
+// submit stores a new proposal or merges the signatures of an existing one.
+// When create is false the proposal must already exist.
+func (co *coordinator) submit(txS string, create bool) (*proposalReply, error) {
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "%v", err)
+	}
+	if len(tx.TxIn) == 0 {
+		return nil, errorf(http.StatusBadRequest, "no inputs")
+	}
+	_, err = multisig.SigningStatus(tx)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest,
+			"not a multisig spend: %v", err)
+	}
+
+	var (
+		p       *proposalRecord
+		signers []auditSigner
+		event   = auditSignature
+	)
+	err = co.db.Update(func(dbTx *bolt.Tx) error {
+		id := tx.TxHash().String()
+		now := time.Now().Unix()
+		var err error
+		if create && dbTx.Bucket(proposalsBucket).Get([]byte(id)) == nil {
+			p = &proposalRecord{ID: id, Created: now}
+			event = auditProposal
+		} else {
+			p, err = co.get(dbTx, id)
+			if err != nil {
+				return err
+			}
+		}
+		if p.Broadcast != "" {
+			return errorf(http.StatusConflict,
+				"proposal already broadcast: %v", id)
+		}
+
+		merged := tx
+		if p.Tx != "" {
+			merged, err = multisig.DecodeTx(p.Tx)
+			if err != nil {
+				return err
+			}
+			before := merged.Copy()
+			err = multisig.MergeSignatures(merged, tx)
+			if err != nil {
+				return errorf(http.StatusBadRequest, "%v", err)
+			}
+			signers, err = newSigners(before, merged)
+			if err != nil {
+				return err
+			}
+		}
+		b, err := merged.Bytes()
+		if err != nil {
+			return err
+		}
+		p.Tx = hex.EncodeToString(b)
+		p.Updated = now
+		return co.put(dbTx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := co.c.proposalReply(p)
+	if err != nil {
+		return nil, err
+	}
+	if event == auditProposal {
+		tx, err := multisig.DecodeTx(p.Tx)
+		if err != nil {
+			return nil, err
+		}
+		err = co.c.audit(auditProposal, struct {
+			TxID    string        `json:"txid"`
+			Expiry  uint32        `json:"expiry"`
+			Outputs []auditOutput `json:"outputs"`
+		}{
+			TxID:    p.ID,
+			Expiry:  tx.Expiry,
+			Outputs: auditOutputs(tx, co.c.cfg.params),
+		})
+	} else if len(signers) > 0 {
+		err = co.c.audit(auditSignature, struct {
+			TxID     string        `json:"txid"`
+			Signers  []auditSigner `json:"signers"`
+			Complete bool          `json:"complete"`
+		}{
+			TxID:     p.ID,
+			Signers:  signers,
+			Complete: reply.Complete,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// broadcast sends a fully signed proposal to the network.
+func (co *coordinator) broadcast(ctx context.Context, id string) (*proposalReply, error) {
+	reply, err := co.proposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Broadcast != "" {
+		return reply, nil
+	}
+	if !reply.Complete {
+		return nil, errorf(http.StatusConflict,
+			"proposal not fully signed: %v", id)
+	}
+
+	tx, err := multisig.DecodeTx(reply.Tx)
+	if err != nil {
+		return nil, err
+	}
+	txHash, err := co.c.ms.Broadcast(ctx, tx)
+	if err != nil {
+		return nil, errorf(http.StatusBadGateway, "%v", err)
+	}
+	err = co.db.Update(func(tx *bolt.Tx) error {
+		p, err := co.get(tx, id)
+		if err != nil {
+			return err
+		}
+		p.Broadcast = txHash
+		p.Updated = time.Now().Unix()
+		return co.put(tx, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	reply.Broadcast = txHash
+
+	err = co.c.audit(auditBroadcast, struct {
+		TxID    string        `json:"txid"`
+		Outputs []auditOutput `json:"outputs"`
+	}{
+		TxID:    txHash,
+		Outputs: auditOutputs(tx, co.c.cfg.params),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// reply writes the JSON encoded result of a call.
+func (co *coordinator) reply(w http.ResponseWriter, r *http.Request, v interface{}, err error) {
+	code := http.StatusOK
+	if err != nil {
+		ce, ok := err.(*coordinatorError)
+		if !ok {
+			log.Errorf("%v %v: %v", r.Method, r.URL.Path, err)
+			ce = errorf(http.StatusInternalServerError,
+				"internal error")
+		}
+		code = ce.Code
+		v = ce
+	}
+	log.Debugf("%v %v %v: %v", r.RemoteAddr, r.Method, r.URL.Path, code)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err = json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Debugf("reply %v: %v", r.RemoteAddr, err)
+	}
+}
+
+// request decodes the JSON request body.
+func (co *coordinator) request(w http.ResponseWriter, r *http.Request) (*proposalRequest, error) {
+	var pr proposalRequest
+	body := http.MaxBytesReader(w, r.Body,
+		int64(co.c.cfg.HTTPMaxResponse))
+	err := json.NewDecoder(body).Decode(&pr)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest,
+			"invalid request: %v", err)
+	}
+	return &pr, nil
+}
+
+// handleProposals handles GET and POST of /v1/proposals.
+func (co *coordinator) handleProposals(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		replies, err := co.list()
+		co.reply(w, r, replies, err)
+	case http.MethodPost:
+		pr, err := co.request(w, r)
+		if err != nil {
+			co.reply(w, r, nil, err)
+			return
+		}
+		reply, err := co.submit(pr.Tx, true)
+		co.reply(w, r, reply, err)
+	default:
+		co.reply(w, r, nil, errorf(http.StatusMethodNotAllowed,
+			"method not allowed: %v", r.Method))
+	}
+}
+
+// handleProposal handles /v1/proposals/<id>, /v1/proposals/<id>/signatures
+// and /v1/proposals/<id>/broadcast.
+func (co *coordinator) handleProposal(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path,
+		coordinatorRoute+"/"), "/")
+	id := path[0]
+	var call string
+	if len(path) > 1 {
+		call = strings.Join(path[1:], "/")
+	}
+
+	switch {
+	case call == "" && r.Method == http.MethodGet:
+		reply, err := co.proposal(id)
+		co.reply(w, r, reply, err)
+	case call == "signatures" && r.Method == http.MethodPost:
+		pr, err := co.request(w, r)
+		if err != nil {
+			co.reply(w, r, nil, err)
+			return
+		}
+		tx, err := multisig.DecodeTx(pr.Tx)
+		if err != nil {
+			err = errorf(http.StatusBadRequest, "%v", err)
+		} else if tx.TxHash().String() != id {
+			err = errorf(http.StatusBadRequest,
+				"transaction is not proposal %v", id)
+		}
+		if err != nil {
+			co.reply(w, r, nil, err)
+			return
+		}
+		reply, err := co.submit(pr.Tx, false)
+		co.reply(w, r, reply, err)
+	case call == "broadcast" && r.Method == http.MethodPost:
+		reply, err := co.broadcast(r.Context(), id)
+		co.reply(w, r, reply, err)
+	default:
+		co.reply(w, r, nil, errorf(http.StatusNotFound,
+			"not found: %v %v", r.Method, r.URL.Path))
+	}
+}
+
+// handler returns the HTTP handler of the coordinator.
+func (co *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(coordinatorRoute, co.handleProposals)
+	mux.HandleFunc(coordinatorRoute+"/", co.handleProposal)
+	return mux
+}
+
+// openCoordinator opens the proposal store in the provided directory.
+func (c *client) openCoordinator(dir string) (*coordinator, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, c.cfg.Net+".db"), 0600,
+		&bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("proposal store: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(proposalsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("proposal store: %v", err)
+	}
+	return &coordinator{c: c, db: db}, nil
+}
+
+func (c *client) serve(ctx context.Context, a map[string]string) error {
+	listen, err := ArgAsString("listen", a)
+	if err != nil {
+		return err
+	}
+
+	co, err := c.openCoordinator(c.cfg.coordinatorDir)
+	if err != nil {
+		return err
+	}
+	defer co.db.Close()
+
+	srv := &http.Server{
+		Addr:         listen,
+		Handler:      co.handler(),
+		ReadTimeout:  c.cfg.HTTPTimeout,
+		WriteTimeout: 2 * c.cfg.HTTPTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Infof("Coordinator listening on %v", listen)
+	err = srv.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// coordinatorCall sends a request to a coordinator and decodes the JSON reply
+// into reply.
+func (c *client) coordinatorCall(ctx context.Context, method, url string, req, reply interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		err := json.NewEncoder(&body).Encode(req)
+		if err != nil {
+			return err
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return fmt.Errorf("unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	log.Debugf("coordinatorCall: %v %v", method, url)
+	response, err := c.coordinator.Do(r)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body,
+		coordinatorMaxReply+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > coordinatorMaxReply {
+		return fmt.Errorf("coordinator reply too large: %v exceeds %v",
+			url, coordinatorMaxReply)
+	}
+	if response.StatusCode != http.StatusOK {
+		var ce coordinatorError
+		if json.Unmarshal(b, &ce) != nil || ce.Message == "" {
+			ce.Message = string(b)
+		}
+		return fmt.Errorf("coordinator error: %v %v",
+			response.StatusCode, ce.Message)
+	}
+	err = json.Unmarshal(b, reply)
+	if err != nil {
+		return fmt.Errorf("invalid JSON from %v: %v", url, err)
+	}
+	return nil
+}
+
+// coordinatorURL returns the proposal URL for the provided path elements.
+func coordinatorURL(coordinator string, path ...string) string {
+	return strings.TrimSuffix(coordinator, "/") + coordinatorRoute +
+		strings.Join(append([]string{""}, path...), "/")
+}
+
+// printProposal prints the signing status of a proposal.
+func printProposal(p *proposalReply) {
+	fmt.Printf("Proposal     : %v\n", p.ID)
+	for k, in := range p.Inputs {
+		fmt.Printf("Input %-7v: %v %v/%v signatures %v\n", k,
+			in.OutPoint, in.Signatures, in.Required, in.Signers)
+	}
+	fmt.Printf("Complete     : %v\n", p.Complete)
+	if p.Broadcast != "" {
+		fmt.Printf("Broadcast    : %v\n", p.Broadcast)
+	}
+}
+
+func (c *client) proposeMultisigTx(ctx context.Context, a map[string]string) error {
+	coordinator, err := ArgAsString("coordinator", a)
+	if err != nil {
+		return err
+	}
+	txS, err := ArgAsString("tx", a)
+	if err != nil {
+		return err
+	}
+	_, err = multisig.DecodeTx(txS)
+	if err != nil {
+		return err
+	}
+
+	var reply proposalReply
+	err = c.coordinatorCall(ctx, http.MethodPost,
+		coordinatorURL(coordinator), proposalRequest{Tx: txS}, &reply)
+	if err != nil {
+		return err
+	}
+	printProposal(&reply)
+	return nil
+}
+
+func (c *client) proposalStatus(ctx context.Context, a map[string]string) error {
+	coordinator, err := ArgAsString("coordinator", a)
+	if err != nil {
+		return err
+	}
+	id, err := ArgAsString("id", a)
+	if err != nil {
+		var replies []proposalReply
+		err = c.coordinatorCall(ctx, http.MethodGet,
+			coordinatorURL(coordinator), nil, &replies)
+		if err != nil {
+			return err
+		}
+		for k := range replies {
+			printProposal(&replies[k])
+		}
+		return nil
+	}
+
+	var reply proposalReply
+	err = c.coordinatorCall(ctx, http.MethodGet,
+		coordinatorURL(coordinator, id), nil, &reply)
+	if err != nil {
+		return err
+	}
+	printProposal(&reply)
+	return nil
+}
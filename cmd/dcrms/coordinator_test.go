@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// testKeys returns n deterministic private keys.
+func testKeys(n int) [][]byte {
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		k := sha256.Sum256([]byte{byte(i)})
+		keys = append(keys, k[:])
+	}
+	return keys
+}
+
+// testMultisigTx returns an unsigned spend of a 2 of 3 contract made of the
+// provided keys.
+func testMultisigTx(t *testing.T, keys [][]byte) (*wire.MsgTx, []byte) {
+	t.Helper()
+	params := chaincfg.TestNet3Params()
+	pubKeys := make([]*dcrutil.AddressSecpPubKey, 0, len(keys))
+	for _, k := range keys {
+		pk := secp256k1.PrivKeyFromBytes(k).PubKey()
+		addr, err := dcrutil.NewAddressSecpPubKeyCompressed(pk, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys = append(pubKeys, addr)
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx()
+	for i := uint32(0); i < 2; i++ {
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: i}, 1e8,
+			redeemScript))
+	}
+	tx.AddTxOut(wire.NewTxOut(19e7, []byte{txscript.OP_TRUE}))
+	return tx, redeemScript
+}
+
+// partialSign returns a copy of tx with every input signed by key only, as
+// dcrwallet does for a single cosigner.
+func partialSign(t *testing.T, tx *wire.MsgTx, redeemScript, key []byte) *wire.MsgTx {
+	t.Helper()
+	signed := tx.Copy()
+	for k := range signed.TxIn {
+		sig, err := txscript.RawTxInSignature(tx, k, redeemScript,
+			txscript.SigHashAll, key, dcrec.STEcdsaSecp256k1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := txscript.NewScriptBuilder().AddData(sig).
+			AddOp(txscript.OP_0).AddData(redeemScript).Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed.TxIn[k].SignatureScript = script
+	}
+	return signed
+}
+
+func txHex(t *testing.T, tx *wire.MsgTx) string {
+	t.Helper()
+	b, err := tx.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestCoordinator(t *testing.T) {
+	dir := t.TempDir()
+	// A coordinator on this computer is not reached through the proxy.
+	c := newClient(&config{
+		Net:             "testnet3",
+		HTTPTimeout:     time.Second,
+		HTTPMaxResponse: defaultHTTPMaxResponse,
+		Proxy:           hungProxy(t),
+		params:          chaincfg.TestNet3Params(),
+		auditDir:        dir,
+	})
+	co, err := c.openCoordinator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer co.db.Close()
+	srv := httptest.NewServer(co.handler())
+	defer srv.Close()
+
+	keys := testKeys(3)
+	tx, redeemScript := testMultisigTx(t, keys)
+	id := tx.TxHash().String()
+	ctx := context.Background()
+
+	var p proposalReply
+	err = c.coordinatorCall(ctx, http.MethodPost, coordinatorURL(srv.URL),
+		proposalRequest{Tx: txHex(t, tx)}, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != id || p.Complete {
+		t.Fatalf("got %v %v", p.ID, p.Complete)
+	}
+
+	for k, key := range keys[:2] {
+		signed := partialSign(t, tx, redeemScript, key)
+		err = c.coordinatorCall(ctx, http.MethodPost,
+			coordinatorURL(srv.URL, id, "signatures"),
+			proposalRequest{Tx: txHex(t, signed)}, &p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Inputs[0].Signatures != k+1 ||
+			len(p.Inputs[0].Signers) != k+1 {
+			t.Fatalf("got %+v", p.Inputs[0])
+		}
+	}
+	if !p.Complete {
+		t.Fatal("proposal not complete")
+	}
+
+	var list []proposalReply
+	err = c.coordinatorCall(ctx, http.MethodGet, coordinatorURL(srv.URL),
+		nil, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !list[0].Complete {
+		t.Fatalf("got %+v", list)
+	}
+
+	// Errors.
+	other := tx.Copy()
+	other.TxOut[0].Value--
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		req    interface{}
+		want   string
+	}{
+		{"unknown", http.MethodGet, coordinatorURL(srv.URL, "x"), nil,
+			"404"},
+		{"other tx", http.MethodPost,
+			coordinatorURL(srv.URL, id, "signatures"),
+			proposalRequest{Tx: txHex(t, other)}, "not proposal"},
+		{"invalid tx", http.MethodPost, coordinatorURL(srv.URL),
+			proposalRequest{Tx: "00"}, "400"},
+		{"not multisig", http.MethodPost, coordinatorURL(srv.URL),
+			proposalRequest{Tx: txHex(t, wire.NewMsgTx())}, "400"},
+	}
+	for _, tt := range tests {
+		err := c.coordinatorCall(ctx, tt.method, tt.url, tt.req, &p)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%v: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Every step was audited.
+	head, err := readAuditHead(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Seq != 3 {
+		t.Fatalf("got %v audit entries", head.Seq)
+	}
+}
+
+// TestCoordinatorSwappedProposal verifies that a proposal the coordinator
+// serves under another id is not signed.
+func TestCoordinatorSwappedProposal(t *testing.T) {
+	tx, _ := testMultisigTx(t, testKeys(3))
+	other := tx.Copy()
+	other.TxOut[0].Value--
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		json.NewEncoder(w).Encode(proposalReply{
+			proposalRecord: proposalRecord{
+				ID: tx.TxHash().String(),
+				Tx: txHex(t, other),
+			},
+		})
+	}))
+	defer srv.Close()
+
+	c := newClient(&config{
+		Net:             "testnet3",
+		NoCache:         true,
+		HTTPTimeout:     time.Second,
+		HTTPMaxResponse: defaultHTTPMaxResponse,
+		params:          chaincfg.TestNet3Params(),
+	})
+	_, err := capture(t, func() error {
+		return c.run(context.Background(), []string{"signmultisigtx",
+			"coordinator=" + srv.URL, "id=" + tx.TxHash().String()})
+	})
+	if err == nil || !strings.Contains(err.Error(), "coordinator "+
+		"returned transaction "+other.TxHash().String()) {
+		t.Fatalf("got %v", err)
+	}
+}
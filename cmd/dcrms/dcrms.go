@@ -5,19 +5,19 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
 	"net/http"
 	"os"
-	"time"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"decred.org/dcrwallet/rpc/jsonrpc/types"
 	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
-	"decred.org/dcrwallet/wallet/txrules"
-	"decred.org/dcrwallet/wallet/txsizes"
 	"github.com/davecgh/go-spew/spew"
-	"github.com/decred/dcrd/blockchain/stake/v3"
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/dcrutil/v3"
 	"github.com/decred/dcrd/txscript/v3"
@@ -25,10 +25,16 @@ import (
 	it "github.com/decred/dcrdata/api/types"
 	"github.com/jrick/wsrpc/v2"
 	"github.com/juju/loggo"
+	"github.com/marcopeereboom/dcrms/multisig"
 )
 
 const (
 	defaultConfirmations = 6
+	defaultMaxInputs     = 100
+
+	// expiryWarningBlocks is the number of blocks before expiry at which
+	// proposals are considered about to expire.
+	expiryWarningBlocks = 24
 )
 
 var (
@@ -36,49 +42,91 @@ var (
 )
 
 type client struct {
-	cfg *config
+	cfg   *config
+	http  *http.Client // Explorer
+	cache *cache       // nil when caching is disabled
+	ms    *multisig.Client
+
+	// coordinator is the HTTP client of coordinator requests.
+	coordinator *http.Client
+
+	// redeemScripts are the redeem scripts of the descriptor arguments by
+	// address.
+	redeemScripts map[string][]byte
+
+	// policyNames are the names of the policies of addresses that are
+	// not named after the address, e.g. derived HD contract addresses, by
+	// address.
+	policyNames map[string]string
 }
 
-// httpRequest send an HTTP request to the provided URL.
-// XXX add tor
-func (c *client) httpRequest(ctx context.Context, url string, timeout time.Duration) ([]byte, error) {
-	log.Debugf("httpRequest: %v", url)
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create request: %v", err)
+// newClient returns a client for the provided configuration.
+func newClient(cfg *config) *client {
+	c := &client{
+		cfg: cfg,
 	}
+	c.http = c.newHTTPClient()
+	c.coordinator = c.newCoordinatorClient()
+	if !cfg.NoCache {
+		c.cache = newCache(cfg.cacheDir, cfg.CacheTTL)
+	}
+	c.ms = multisig.New(multisig.Config{
+		Params:       cfg.params,
+		Wallet:       wallet{c},
+		Explorer:     explorer{c},
+		FetchWorkers: cfg.FetchWorkers,
+		Progress:     progress,
+	})
+	return c
+}
 
-	client := &http.Client{
-		Timeout: timeout * time.Second,
-	}
-	response, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
+// wallet provides the wallet connection of the client to the multisig
+// package.
+type wallet struct {
+	c *client
+}
 
-	if response.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return nil, fmt.Errorf("dcrdata error: %v %v %v",
-				response.StatusCode, url, err)
-		}
-		return nil, fmt.Errorf("dcrdata error: %v %v %s",
-			response.StatusCode, url, body)
-	}
+// Call performs a wallet JSON-RPC call.
+func (w wallet) Call(ctx context.Context, method string, res interface{}, args ...interface{}) error {
+	return w.c.walletCall(ctx, method, res, args...)
+}
+
+// explorer provides the cached explorer lookups of the client to the
+// multisig package.
+type explorer struct {
+	c *client
+}
+
+// Utxos returns all utxos of the provided address.
+func (e explorer) Utxos(ctx context.Context, address string) ([]it.AddressTxnOutput, error) {
+	return e.c.fetchUtxos(ctx, address)
+}
 
-	return ioutil.ReadAll(response.Body)
+// RawTx returns the hex encoded transaction with the provided id.
+func (e explorer) RawTx(ctx context.Context, txID *chainhash.Hash, confirmed bool) ([]byte, error) {
+	return e.c.getRawTx(ctx, txID, confirmed)
+}
+
+// progress reports on stderr how many previous transactions have been
+// fetched.
+func progress(done, total int) {
+	fmt.Fprintf(os.Stderr, "\rFetching previous transactions: %v/%v",
+		done, total)
+	if done == total {
+		fmt.Fprintf(os.Stderr, "\n")
+	}
 }
 
 func (c *client) walletCall(ctx context.Context, method string, res interface{}, params ...interface{}) error {
 	tc := &tls.Config{RootCAs: x509.NewCertPool()}
 	tc.RootCAs.AppendCertsFromPEM(c.cfg.ca)
 	wc, err := wsrpc.Dial(ctx, c.cfg.wallet,
-		wsrpc.WithBasicAuth(c.cfg.User, c.cfg.Pass), wsrpc.WithTLSConfig(tc))
+		wsrpc.WithBasicAuth(c.cfg.User, c.cfg.Pass), wsrpc.WithTLSConfig(tc),
+		wsrpc.WithDial(c.dialLocal))
 	if err != nil {
 		return err
 	}
+	defer wc.Close()
 	err = wc.Call(ctx, method, res, params...)
 	if err != nil {
 		return err
@@ -95,16 +143,12 @@ func (c *client) getMultiSigBalance(ctx context.Context, a map[string]string) er
 	var addr it.InsightAddressInfo
 	url := c.cfg.insight + "/addr/" + address
 
-	resp, err := c.httpRequest(ctx, url, 5*time.Second)
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(resp, &addr)
+	err = c.httpRequestJSON(ctx, url, &addr)
 	if err != nil {
 		return err
 	}
 	log.Tracef("%v", spew.Sdump(addr))
-	fmt.Printf("%v\n", addr.Balance)
+	fmt.Printf("%v\n", dcrutil.Amount(addr.BalanceSat).ToCoin())
 
 	return nil
 }
@@ -122,29 +166,80 @@ func (c *client) getWalletBalance(ctx context.Context, a map[string]string) erro
 }
 
 func (c *client) getNewKey(ctx context.Context, a map[string]string) error {
+	address, key, err := c.newKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Prove possession of the key for the contract
+	contract, ok := a["contract"]
+	if !ok {
+		err = c.qrOutput(key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v\n", key)
+		return nil
+	}
+	ks, err := c.signKey(ctx, address, key, contract)
+	if err != nil {
+		return err
+	}
+	err = c.qrOutput(ks.String())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v\n", key)
+	fmt.Printf("%v\n", ks)
+
+	return nil
+}
+
+// newKey returns a new wallet address and its public key address.
+func (c *client) newKey(ctx context.Context) (string, string, error) {
 	var address string
 	err := c.walletCall(ctx, "getnewaddress", &address, "default", "wrap")
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	log.Tracef("%v", address)
 
 	var va jt.ValidateAddressResult
 	err = c.walletCall(ctx, "validateaddress", &va, address)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	log.Tracef("%v", spew.Sdump(va))
 	if !va.IsValid {
-		return fmt.Errorf("address is not valid: %v", address)
+		return "", "", fmt.Errorf("address is not valid: %v", address)
 	}
 	if !va.IsMine {
-		return fmt.Errorf("we don't control this address: %v", address)
+		return "", "", fmt.Errorf("we don't control this address: %v",
+			address)
 	}
 
-	fmt.Printf("%v\n", va.PubKeyAddr)
+	return address, va.PubKeyAddr, nil
+}
 
-	return nil
+// signKey has the wallet sign the statement that it controls the key of the
+// provided address for the named contract.
+func (c *client) signKey(ctx context.Context, address, key, contract string) (*multisig.KeyStatement, error) {
+	var sig string
+	err := c.walletCall(ctx, "signmessage", &sig, address,
+		multisig.KeyMessage(key, contract))
+	if err != nil {
+		return nil, fmt.Errorf("signmessage: %v", err)
+	}
+	ks, err := multisig.ParseKeyStatement(key + ":" + sig)
+	if err != nil {
+		return nil, err
+	}
+	// Make sure cosigners will be able to verify it.
+	err = ks.Verify(contract, c.cfg.params)
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
 }
 
 func (c *client) createMultisigAddress(ctx context.Context, a map[string]string) error {
@@ -157,31 +252,106 @@ func (c *client) createMultisigAddress(ctx context.Context, a map[string]string)
 		return err
 	}
 
-	var msa jt.CreateMultiSigResult
-	err = c.walletCall(ctx, "createmultisig", &msa, n, keys)
+	// Only accept keys whose owners proved possession for the contract
+	// unless the user explicitly accepts bare keys.
+	name, ok := a["contract"]
+	unverified, unverifiedOk := a["unverifiedkeys"]
+	switch {
+	case ok && unverifiedOk:
+		return fmt.Errorf("contract and unverifiedkeys are mutually " +
+			"exclusive")
+	case ok:
+		keys, err = multisig.VerifyKeyStatements(keys, name,
+			c.cfg.params)
+		if err != nil {
+			return err
+		}
+	case unverifiedOk:
+		if unverified == "" {
+			return fmt.Errorf("unverifiedkeys requires a reason")
+		}
+		for _, k := range keys {
+			if strings.Contains(k, ":") {
+				return fmt.Errorf("key statements require " +
+					"contract")
+			}
+		}
+	default:
+		return fmt.Errorf("key statements are required: name the " +
+			"contract the keys were signed for with contract, or " +
+			"accept bare keys with unverifiedkeys=<reason>")
+	}
+
+	contract, err := c.createContract(ctx, int(n), keys, unverified)
+	if err != nil {
+		return err
+	}
+	descriptor, err := multisig.Descriptor(contract.RedeemScript,
+		c.cfg.params)
+	if err != nil {
+		return err
+	}
+	err = c.qrOutput(descriptor)
 	if err != nil {
 		return err
 	}
-	log.Tracef("%v", msa)
-	fmt.Printf("%v\n", msa.Address)
+	fmt.Printf("%v\n", contract.Address)
 	// Don't think we need to print redeem script.
-	fmt.Printf("%v\n", msa.RedeemScript)
+	fmt.Printf("%x\n", contract.RedeemScript)
+	fmt.Printf("%v\n", descriptor)
 
 	return nil
 }
 
+// createContract creates the m of len(keys) contract in the wallet and records
+// it in the audit log. Unverified is the reason keys were accepted without
+// proof of possession, if they were.
+func (c *client) createContract(ctx context.Context, m int, keys []string, unverified string) (*multisig.Contract, error) {
+	if unverified != "" {
+		log.Warningf("Unverified keys %v: %v", keys, unverified)
+		fmt.Fprintf(os.Stderr, "WARNING: keys are used without proof "+
+			"of possession: %v\n", unverified)
+	}
+	contract, err := c.ms.CreateContract(ctx, &multisig.ContractRequest{
+		M:    m,
+		Keys: keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.audit(auditContract, struct {
+		Address      string   `json:"address"`
+		RedeemScript string   `json:"redeemscript"`
+		M            uint     `json:"m"`
+		Keys         []string `json:"keys"`
+		Unverified   string   `json:"unverified,omitempty"`
+	}{
+		Address:      contract.Address,
+		RedeemScript: hex.EncodeToString(contract.RedeemScript),
+		M:            uint(m),
+		Keys:         keys,
+		Unverified:   unverified,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contract, nil
+}
+
 func (c *client) sendToMultisig(ctx context.Context, a map[string]string) error {
 	address, err := ArgAsString("address", a)
 	if err != nil {
 		return err
 	}
-	amount, err := ArgAsFloat("amount", a)
+	amount, err := ArgAsAmount("amount", a)
 	if err != nil {
 		return err
 	}
 
 	var txHash string
-	err = c.walletCall(ctx, "sendtoaddress", &txHash, address, amount)
+	err = c.walletCall(ctx, "sendtoaddress", &txHash, address,
+		amount.ToCoin())
 	if err != nil {
 		return err
 	}
@@ -190,91 +360,135 @@ func (c *client) sendToMultisig(ctx context.Context, a map[string]string) error
 	return nil
 }
 
-func (c *client) getMultisigOutInfo(ctx context.Context, tx string, vout uint32) (*jt.GetMultisigOutInfoResult, error) {
-	var moir jt.GetMultisigOutInfoResult
-	err := c.walletCall(ctx, "getmultisigoutinfo", &moir, tx, vout)
-	if err != nil {
-		return nil, err
-	}
-	return &moir, nil
-}
-
-// getUtxos returns a map of utxos that have had enough confirmations.
-func (c *client) getUtxos(ctx context.Context, address string, confirmations int64) (map[string]it.AddressTxnOutput, error) {
-	var utxos []it.AddressTxnOutput
-	url := c.cfg.insight + "/addr/" + address + "/utxo"
-
-	resp, err := c.httpRequest(ctx, url, 5*time.Second)
+// printUnsignedTx prints the provided unsigned transaction, renders it as QR
+// codes to qr and records the proposal in the audit log.
+func (c *client) printUnsignedTx(unsignedTx *wire.MsgTx, qr string) error {
+	log.Tracef("%v", spew.Sdump(unsignedTx))
+	serializedTX, err := multisig.EncodeTx(unsignedTx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	err = json.Unmarshal(resp, &utxos)
+	err = qrOutputTo(qr, serializedTX)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	log.Tracef("%v", spew.Sdump(utxos))
+	fmt.Printf("%v\n", serializedTX)
 
-	u := make(map[string]it.AddressTxnOutput, len(utxos))
-	for k := range utxos {
-		if utxos[k].Confirmations < confirmations {
-			continue
-		}
-		txID := utxos[k].TxnID
-		if _, ok := u[txID]; ok {
-			return nil, fmt.Errorf("duplicate tx ud: %v", txID)
-		}
-		u[txID] = utxos[k]
+	return c.auditProposal(unsignedTx)
+}
 
+// auditProposal records the provided unsigned transaction in the audit log.
+func (c *client) auditProposal(unsignedTx *wire.MsgTx) error {
+	return c.audit(auditProposal, struct {
+		TxID    string        `json:"txid"`
+		Expiry  uint32        `json:"expiry"`
+		Outputs []auditOutput `json:"outputs"`
+	}{
+		TxID:    unsignedTx.TxHash().String(),
+		Expiry:  unsignedTx.Expiry,
+		Outputs: auditOutputs(unsignedTx, c.cfg.params),
+	})
+}
+
+// memoArg returns the data of the memo argument, or nil when no memo is
+// provided.
+func memoArg(a map[string]string) ([]byte, error) {
+	memo, ok := a["memo"]
+	if !ok {
+		return nil, nil
 	}
+	return multisig.ParseMemo(memo)
+}
 
-	return u, nil
+// utxoRequest returns the utxo selection requested by the inputs and exclude
+// arguments.
+func (c *client) utxoRequest(addresses []string, confirmations int, a map[string]string) multisig.UtxoRequest {
+	inputs, _ := ArgAsStringSlice("inputs", a)
+	exclude, _ := ArgAsStringSlice("exclude", a)
+	return multisig.UtxoRequest{
+		Addresses:     addresses,
+		Confirmations: int64(confirmations),
+		Inputs:        inputs,
+		Exclude:       exclude,
+		RedeemScripts: c.redeemScripts,
+	}
 }
 
-func (c *client) assembleTxIns(ctx context.Context, redeemScript []byte, utxos []it.AddressTxnOutput) ([]*wire.TxIn, error) {
-	txIns := make([]*wire.TxIn, 0, len(utxos))
-	for k := range utxos {
-		prevHash, err := chainhash.NewHashFromStr(utxos[k].TxnID)
+// txTiming returns the expiry and lock time requested by the expiry and
+// locktime arguments. Expiry is either an absolute block height or, when
+// prefixed with +, a number of blocks relative to the chain tip. Zero means
+// not set.
+func (c *client) txTiming(ctx context.Context, a map[string]string) (uint32, uint32, error) {
+	var expiry, lockTime uint32
+	if e, ok := a["expiry"]; ok {
+		tip, err := c.bestBlock(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("decode tx: %v", err)
+			return 0, 0, fmt.Errorf("best block: %v", err)
 		}
-
-		// Find the tree, decred specific
-		url := c.cfg.dcrdata + "/tx/hex/" + prevHash.String()
-		rawTxS, err := c.httpRequest(ctx, url, 5*time.Second)
+		relative := strings.HasPrefix(e, "+")
+		x, err := strconv.ParseUint(strings.TrimPrefix(e, "+"), 10, 32)
 		if err != nil {
-			return nil, fmt.Errorf("get hex tx: %v", err)
+			return 0, 0, fmt.Errorf("invalid expiry: %v", err)
 		}
-		log.Tracef("%v", spew.Sdump(rawTxS))
-		rawTx, err := hex.DecodeString(string(rawTxS))
-		if err != nil {
-			return nil, fmt.Errorf("decode raw hex: %v", err)
+		if relative {
+			x += uint64(tip.Height)
+		}
+		if x <= uint64(tip.Height) || x > math.MaxUint32 {
+			return 0, 0, fmt.Errorf("invalid expiry %v: tip is %v",
+				x, tip.Height)
 		}
-		prevTx := wire.NewMsgTx()
-		err = prevTx.FromBytes(rawTx)
+		expiry = uint32(x)
+	}
+	if _, ok := a["locktime"]; ok {
+		x, err := ArgAsUint("locktime", a)
 		if err != nil {
-			return nil, fmt.Errorf("decode raw tx: %v", err)
+			return 0, 0, fmt.Errorf("invalid locktime: %v", err)
 		}
-		tree := wire.TxTreeRegular
-		st := stake.DetermineTxType(prevTx, true)
-		if st != stake.TxTypeRegular {
-			tree = wire.TxTreeStake
+		if x > math.MaxUint32 {
+			return 0, 0, fmt.Errorf("invalid locktime: %v", x)
 		}
+		lockTime = uint32(x)
+	}
+	return expiry, lockTime, nil
+}
+
+// expiryWarning returns a warning if the transaction has no expiry or if it
+// expires within expiryWarningBlocks of the provided tip height.
+func expiryWarning(tx *wire.MsgTx, height uint32) string {
+	switch {
+	case tx.Expiry == wire.NoExpiryValue:
+		return "transaction has no expiry and remains valid forever"
+	case height >= tx.Expiry:
+		return fmt.Sprintf("transaction EXPIRED at height %v, tip is %v",
+			tx.Expiry, height)
+	case tx.Expiry-height <= expiryWarningBlocks:
+		return fmt.Sprintf("transaction expires in %v blocks at "+
+			"height %v", tx.Expiry-height, tx.Expiry)
+	}
+	return ""
+}
 
-		// Add input
-		outPoint := wire.NewOutPoint(prevHash, utxos[k].Vout, tree)
-		txIn := wire.NewTxIn(outPoint, utxos[k].Satoshis, redeemScript)
-		txIns = append(txIns, txIn)
+// warnExpiry prints a warning on stderr if the provided proposal has no
+// expiry or is about to expire.
+func (c *client) warnExpiry(ctx context.Context, tx *wire.MsgTx) {
+	var height uint32
+	if tx.Expiry != wire.NoExpiryValue {
+		tip, err := c.bestBlock(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: could not determine "+
+				"chain tip to verify expiry: %v\n", err)
+			return
+		}
+		height = tip.Height
+	}
+	if w := expiryWarning(tx, height); w != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", w)
 	}
-	return txIns, nil
 }
 
 func (c *client) createMultisigTx(ctx context.Context, a map[string]string) error {
-	// Multisig address
-	address, err := ArgAsString("address", a)
-	if err != nil {
-		return err
-	}
-	change, err := dcrutil.DecodeAddress(address, c.cfg.params)
+	// Multisig addresses, change goes to the first one
+	addresses, err := ArgAsStringSlice("address", a)
 	if err != nil {
 		return err
 	}
@@ -284,262 +498,518 @@ func (c *client) createMultisigTx(ctx context.Context, a map[string]string) erro
 	if err != nil {
 		return err
 	}
-	toAddress, err := dcrutil.DecodeAddress(to, c.cfg.params)
+
+	// Amount
+	outValue, err := ArgAsAmount("amount", a)
 	if err != nil {
 		return err
 	}
 
-	// Amount
-	amount, err := ArgAsFloat("amount", a)
+	confirmations, err := ArgAsInt("confirmations", a)
 	if err != nil {
 		return err
 	}
-	outValue, err := dcrutil.NewAmount(amount)
+	expiry, lockTime, err := c.txTiming(ctx, a)
 	if err != nil {
-		return fmt.Errorf("NewAmount: %v", err)
+		return err
 	}
-
-	confirmations, err := ArgAsInt("confirmations", a)
+	memo, err := memoArg(a)
 	if err != nil {
-		confirmations = defaultConfirmations
+		return err
 	}
-	// See if we have enough balance
-	var balance jt.GetBalanceResult
-	err = c.walletCall(ctx, "getbalance", &balance)
+
+	res, err := c.ms.BuildTx(ctx, &multisig.TxRequest{
+		UtxoRequest: c.utxoRequest(addresses, confirmations, a),
+		To:          to,
+		Amount:      outValue,
+		Memo:        memo,
+		Expiry:      expiry,
+		LockTime:    lockTime,
+	})
 	if err != nil {
-		return fmt.Errorf("getbalance: %v", err)
+		return err
 	}
-	if balance.TotalSpendable < amount {
-		return fmt.Errorf("balance too low: available %v",
-			balance.TotalSpendable)
+	log.Tracef("%v", spew.Sdump(res.Inputs))
+	log.Debugf("fee %v change %v", res.Fee, res.Change)
+
+	return c.printUnsignedTx(res.Tx, c.cfg.QR)
+}
+
+// signTx signs the provided transaction with the wallet after enforcing the
+// policies of the contracts it spends from. It prints the signing status of
+// every input to out and returns the wallet reply and the keys that signed.
+func (c *client) signTx(ctx context.Context, out io.Writer, unsignedTXS string, a map[string]string) (*types.SignRawTransactionResult, []auditSigner, error) {
+	unsignedTX, err := multisig.DecodeTx(unsignedTXS)
+	if err != nil {
+		return nil, nil, err
 	}
+	c.warnExpiry(ctx, unsignedTX)
 
-	// Find all utxos
-	utxos, err := c.getUtxos(ctx, address, int64(confirmations))
+	// Refuse to sign transactions that violate a contract policy
+	override, ok := a["override"]
+	if ok && override == "" {
+		return nil, nil, fmt.Errorf("override requires a reason")
+	}
+	spend, err := c.enforcePolicy(ctx, unsignedTX, override)
 	if err != nil {
-		return fmt.Errorf("getUtxos: %v", err)
+		return nil, nil, err
 	}
 
-	// Select utxos
-	utxoList := make([]it.AddressTxnOutput, 0, len(utxos))
-	foundAmount := 0.0
-	for k := range utxos {
-		utxoList = append(utxoList, utxos[k])
-		foundAmount += utxos[k].Amount
-		fmt.Printf("foundAmount %v\n", foundAmount)
-		if foundAmount > amount {
-			break
-		}
+	signedTX, complete, err := c.ms.Sign(ctx, unsignedTX)
+	if err != nil {
+		return nil, nil, err
 	}
-	if len(utxoList) == 0 {
-		return fmt.Errorf("0 utxos found to assemble transaction")
+	signedTXS, err := multisig.EncodeTx(signedTX)
+	if err != nil {
+		return nil, nil, err
 	}
-	if foundAmount <= amount {
-		return fmt.Errorf("not enough total value: %v", foundAmount)
+	srtr := types.SignRawTransactionResult{
+		Hex:      signedTXS,
+		Complete: complete,
 	}
-	foundAtoms, err := dcrutil.NewAmount(foundAmount)
+	log.Tracef("%v", spew.Sdump(srtr))
+	err = c.recordSpend(spend)
 	if err != nil {
-		return fmt.Errorf("foundAtoms: %v", err)
+		return nil, nil, fmt.Errorf("record spend: %v", err)
 	}
 
-	spew.Dump(utxoList)
-
-	// Get redeem script and signers
-	moir, err := c.getMultisigOutInfo(ctx, utxoList[0].TxnID,
-		utxoList[0].Vout)
+	status, err := multisig.SigningStatus(signedTX)
 	if err != nil {
-		return fmt.Errorf("getMultisigOutInfo: %v", err)
+		return nil, nil, err
 	}
-	redeemScript, err := hex.DecodeString(moir.RedeemScript)
-	if err != nil {
-		return fmt.Errorf("decode string: %v", err)
+	for k := range status {
+		fmt.Fprintf(out, "Input %v %v: %v/%v signatures\n", k,
+			status[k].OutPoint, status[k].Signatures,
+			status[k].Required)
 	}
-	signers := moir.M
 
-	// Get previous outpoints
-	txIns, err := c.assembleTxIns(ctx, redeemScript, utxoList)
+	// Record which keys signed
+	signers, err := newSigners(unsignedTX, signedTX)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range signers {
+		fmt.Fprintf(out, "Input %v signed by: %v\n", s.Input,
+			pubKeyAddresses(s.PubKeys, c.cfg.params))
+	}
+	err = c.audit(auditSignature, struct {
+		TxID     string        `json:"txid"`
+		Signers  []auditSigner `json:"signers"`
+		Complete bool          `json:"complete"`
+	}{
+		TxID:     signedTX.TxHash().String(),
+		Signers:  signers,
+		Complete: srtr.Complete,
+	})
 	if err != nil {
-		return fmt.Errorf("getPrevOutpoints: %v", err)
+		return nil, nil, err
 	}
 
-	// Assemble tx
-	unsignedTx := wire.NewMsgTx()
-	for k := range txIns {
-		unsignedTx.AddTxIn(txIns[k])
-	}
+	return &srtr, signers, nil
+}
 
-	// Output
-	script, err := txscript.PayToAddrScript(toAddress)
-	if err != nil {
-		return fmt.Errorf("DecodeAddress: %v", err)
+func (c *client) signMultiSigTx(ctx context.Context, a map[string]string) error {
+	// Fetch the proposal from a coordinator if one is provided
+	var (
+		unsignedTXS string
+		id          string
+	)
+	coordinator, err := ArgAsString("coordinator", a)
+	if err == nil {
+		id, err = ArgAsString("id", a)
+		if err != nil {
+			return err
+		}
+		var p proposalReply
+		err = c.coordinatorCall(ctx, http.MethodGet,
+			coordinatorURL(coordinator, id), nil, &p)
+		if err != nil {
+			return err
+		}
+
+		// The coordinator is not trusted to serve the proposal that
+		// was asked for, show what is about to be signed.
+		tx, err := multisig.DecodeTx(p.Tx)
+		if err != nil {
+			return err
+		}
+		if tx.TxHash().String() != id {
+			return fmt.Errorf("coordinator returned transaction %v "+
+				"for proposal %v", tx.TxHash(), id)
+		}
+		err = c.printTxSummary(ctx, os.Stdout, tx)
+		if err != nil {
+			return err
+		}
+		unsignedTXS = p.Tx
+	} else {
+		unsignedTXS, err = ArgAsString("tx", a)
+		if err != nil {
+			return err
+		}
 	}
-	txOut := wire.NewTxOut(int64(outValue), script)
-	unsignedTx.AddTxOut(txOut)
 
-	// Change
-	changeScript, err := txscript.PayToAddrScript(change)
+	srtr, _, err := c.signTx(ctx, os.Stdout, unsignedTXS, a)
 	if err != nil {
-		return fmt.Errorf("PayToAddrScript: %v", err)
+		return err
 	}
 
-	// Note that size * signers slightly overpays
-	signersSize := int(txsizes.RedeemP2PKHSigScriptSize * signers)
-	inputSizes := []int{signersSize, len(redeemScript)}
-	outputSizes := []int{txsizes.P2PKHPkScriptSize}
-	changeSize := txsizes.P2SHPkScriptSize
-	sz := txsizes.EstimateSerializeSizeFromScriptSizes(inputSizes,
-		outputSizes, changeSize)
-	fee := txrules.FeeForSerializeSize(txrules.DefaultRelayFeePerKb,
-		sz)
-	txOutChange := wire.NewTxOut(int64(foundAtoms)-int64(outValue+fee),
-		changeScript)
-	unsignedTx.AddTxOut(txOutChange)
+	// Post signatures back to the coordinator
+	if coordinator != "" {
+		var p proposalReply
+		err = c.coordinatorCall(ctx, http.MethodPost,
+			coordinatorURL(coordinator, id, "signatures"),
+			proposalRequest{Tx: srtr.Hex}, &p)
+		if err != nil {
+			return err
+		}
+		printProposal(&p)
+		return nil
+	}
 
-	// Dump
-	fmt.Printf("tx: %v", spew.Sdump(unsignedTx))
-	log.Tracef("%v", spew.Sdump(unsignedTx))
-	serializedTX, err := unsignedTx.Bytes()
+	err = c.qrOutput(srtr.Hex)
 	if err != nil {
-		return fmt.Errorf("serialize: %v", err)
+		return err
+	}
+	if srtr.Complete {
+		fmt.Printf("TRANSACTION SIGNING COMPLETE\n")
+	} else {
+		fmt.Printf("TRANSACTION SIGNING *NOT* COMPLETE\n")
 	}
-	fmt.Printf("%x\n", serializedTX)
+	fmt.Printf("%v\n", srtr.Hex)
 
 	return nil
 }
 
-func (c *client) signMultiSigTx(ctx context.Context, a map[string]string) error {
-	unsignedTXS, err := ArgAsString("tx", a)
+func (c *client) decodeMultisigTx(ctx context.Context, a map[string]string) error {
+	txS, err := ArgAsString("tx", a)
 	if err != nil {
 		return err
 	}
-	utxb, err := hex.DecodeString(unsignedTXS)
+	tx, err := multisig.DecodeTx(txS)
 	if err != nil {
-		return fmt.Errorf("DecodeString %v", err)
+		return err
 	}
+	log.Tracef("%v", spew.Sdump(tx))
 
-	unsignedTX := wire.NewMsgTx()
-	if err != nil {
-		return fmt.Errorf("NewMsgTx: %v", err)
-	}
-	err = unsignedTX.FromBytes(utxb)
+	err = c.printTxSummary(ctx, os.Stdout, tx)
 	if err != nil {
-		return fmt.Errorf("FromBytes: %v", err)
+		return err
 	}
+	c.warnExpiry(ctx, tx)
+
+	return nil
+}
 
-	var srtr types.SignRawTransactionResult
-	err = c.walletCall(ctx, "signrawtransaction", &srtr, unsignedTXS)
+// printTxSummary prints the inputs with their signing status, the outputs and
+// the fee of the provided transaction to out.
+func (c *client) printTxSummary(ctx context.Context, out io.Writer, tx *wire.MsgTx) error {
+	status, err := multisig.SigningStatus(tx)
 	if err != nil {
 		return err
 	}
-	log.Tracef("%v", spew.Sdump(srtr))
 
-	if srtr.Complete {
-		fmt.Printf("TRANSACTION SIGNING COMPLETE\n")
+	// Input values are read from the previous transactions, the values
+	// in the transaction itself can not be trusted. When they can not be
+	// fetched the values in the transaction are labeled as such.
+	unverified := ""
+	prevOuts, err := c.ms.PrevOuts(ctx, tx)
+	if err == nil {
+		err = multisig.CheckPrevOuts(tx, prevOuts, c.cfg.params)
+		if err != nil {
+			return err
+		}
 	} else {
-		fmt.Printf("TRANSACTION SIGNING *NOT* COMPLETE\n")
+		fmt.Fprintf(os.Stderr, "WARNING: could not verify input "+
+			"values: %v\n", err)
+		unverified = " (unverified)"
+	}
+
+	fmt.Fprintf(out, "Txid         : %v\n", tx.TxHash())
+	fmt.Fprintf(out, "Version      : %v\n", tx.Version)
+	fmt.Fprintf(out, "LockTime     : %v\n", tx.LockTime)
+	fmt.Fprintf(out, "Expiry       : %v\n", tx.Expiry)
+	var in, outValue int64
+	for k, txIn := range tx.TxIn {
+		in += txIn.ValueIn
+		fmt.Fprintf(out, "Input %-7v: %v %v%v %v/%v signatures\n", k,
+			txIn.PreviousOutPoint, dcrutil.Amount(txIn.ValueIn),
+			unverified, status[k].Signatures, status[k].Required)
+	}
+	for k, txOut := range tx.TxOut {
+		outValue += txOut.Value
+		if memo, ok := multisig.TxMemo(txOut.PkScript); ok {
+			fmt.Fprintf(out, "Output %-6v: %v memo %v\n", k,
+				dcrutil.Amount(txOut.Value), multisig.MemoString(memo))
+			continue
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+			txOut.Version, txOut.PkScript, c.cfg.params, false)
+		if err != nil || len(addrs) == 0 {
+			fmt.Fprintf(out, "Output %-6v: %v %x\n", k,
+				dcrutil.Amount(txOut.Value), txOut.PkScript)
+			continue
+		}
+		fmt.Fprintf(out, "Output %-6v: %v %v\n", k,
+			dcrutil.Amount(txOut.Value), addrs[0])
 	}
-	fmt.Printf("%v\n", srtr.Hex)
+	fmt.Fprintf(out, "Fee          : %v%v\n", dcrutil.Amount(in-outValue), unverified)
 
 	return nil
 }
 
 func (c *client) broadcastMultisigTx(ctx context.Context, a map[string]string) error {
+	// Even a failed broadcast may have reached the network.
+	defer c.utxosSpent()
+
+	// Let the coordinator broadcast a fully signed proposal
+	coordinator, err := ArgAsString("coordinator", a)
+	if err == nil {
+		id, err := ArgAsString("id", a)
+		if err != nil {
+			return err
+		}
+		var p proposalReply
+		err = c.coordinatorCall(ctx, http.MethodPost,
+			coordinatorURL(coordinator, id, "broadcast"), nil, &p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v\n", p.Broadcast)
+		return nil
+	}
+
+	// Broadcast a fully signed proposal from the proposal directory
+	id, err := ArgAsString("id", a)
+	if err == nil {
+		return c.broadcastProposal(ctx, id)
+	}
+
 	signedTXS, err := ArgAsString("tx", a)
 	if err != nil {
 		return err
 	}
-	utxb, err := hex.DecodeString(signedTXS)
+	signedTX, err := multisig.DecodeTx(signedTXS)
 	if err != nil {
-		return fmt.Errorf("DecodeString %v", err)
+		return err
 	}
 
-	signedTX := wire.NewMsgTx()
-	if err != nil {
-		return fmt.Errorf("NewMsgTx: %v", err)
+	txHash, err := c.broadcastTx(ctx, signedTX)
+	if txHash != "" {
+		fmt.Printf("%v\n", txHash)
 	}
-	err = signedTX.FromBytes(utxb)
+	return err
+}
+
+// broadcastTx broadcasts the provided fully signed transaction and records it
+// in the audit log. It returns the transaction hash once broadcast, even if it
+// could not be audited.
+func (c *client) broadcastTx(ctx context.Context, signedTX *wire.MsgTx) (string, error) {
+	// Even a failed broadcast may have reached the network.
+	defer c.utxosSpent()
+
+	txHash, err := c.ms.Broadcast(ctx, signedTX)
 	if err != nil {
-		return fmt.Errorf("FromBytes: %v", err)
+		return "", err
+	}
+	return txHash, c.audit(auditBroadcast, struct {
+		TxID    string        `json:"txid"`
+		Outputs []auditOutput `json:"outputs"`
+	}{
+		TxID:    txHash,
+		Outputs: auditOutputs(signedTX, c.cfg.params),
+	})
+}
+
+// contractInfo returns the contract of the provided address. A descriptor
+// argument already defines the contract, otherwise it is looked up.
+func (c *client) contractInfo(ctx context.Context, address string) (*multisig.Contract, error) {
+	if redeemScript, ok := c.redeemScripts[address]; ok {
+		return multisig.NewContract(redeemScript, c.cfg.params)
 	}
+	return c.ms.ContractInfo(ctx, address)
+}
 
-	var txHash string
-	err = c.walletCall(ctx, "sendrawtransaction", &txHash, signedTXS)
+func (c *client) multisigInfo(ctx context.Context, a map[string]string) error {
+	address, err := ArgAsString("address", a)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%v\n", txHash)
 
+	contract, err := c.contractInfo(ctx, address)
+	if err != nil {
+		return err
+	}
+	descriptor, err := multisig.Descriptor(contract.RedeemScript,
+		c.cfg.params)
+	if err != nil {
+		return err
+	}
+	log.Tracef("%v", spew.Sdump(contract))
+	err = c.qrOutput(descriptor)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Address      : %v\n", contract.Address)
+	fmt.Printf("M            : %v\n", contract.M)
+	fmt.Printf("N            : %v\n", contract.N)
+	for _, pk := range contract.PubKeys {
+		fmt.Printf("Public key   : %v\n", pk)
+	}
+	fmt.Printf("Redeem script: %x\n", contract.RedeemScript)
+	fmt.Printf("Descriptor   : %v\n", descriptor)
 	return nil
 }
 
-func (c *client) multisigInfo(ctx context.Context, a map[string]string) error {
-	address, err := ArgAsString("address", a)
+func (c *client) sweepMultisig(ctx context.Context, a map[string]string) error {
+	// Multisig addresses
+	addresses, err := ArgAsStringSlice("address", a)
+	if err != nil {
+		return err
+	}
+
+	// Destination
+	to, err := ArgAsString("to", a)
 	if err != nil {
 		return err
 	}
 
-	// Find all utxos
-	var utxos []it.AddressTxnOutput
-	url := c.cfg.insight + "/addr/" + address + "/utxo"
-	resp, err := c.httpRequest(ctx, url, 5*time.Second)
+	confirmations, err := ArgAsInt("confirmations", a)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(resp, &utxos)
+	expiry, lockTime, err := c.txTiming(ctx, a)
+	if err != nil {
+		return err
+	}
+	memo, err := memoArg(a)
 	if err != nil {
 		return err
 	}
 
-	if len(utxos) == 0 {
-		return fmt.Errorf("no information available for: %v", address)
+	res, err := c.ms.Sweep(ctx, &multisig.SweepRequest{
+		UtxoRequest: c.utxoRequest(addresses, confirmations, a),
+		To:          to,
+		Memo:        memo,
+		Expiry:      expiry,
+		LockTime:    lockTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.printUnsignedTx(res.Tx, c.cfg.QR)
+}
+
+func (c *client) consolidateMultisig(ctx context.Context, a map[string]string) error {
+	// Multisig address, consolidated funds return to it
+	address, err := ArgAsString("address", a)
+	if err != nil {
+		return err
 	}
 
-	// Fish out the first utxo to get to the multisig address info
-	k := 0
-	moir, err := c.getMultisigOutInfo(ctx, utxos[k].TxnID,
-		utxos[k].Vout)
+	minAtoms, err := ArgAsAmount("minvalue", a)
 	if err != nil {
-		return fmt.Errorf("getMultisigOutInfo: %v", err)
+		return err
 	}
-	log.Tracef("%v", spew.Sdump(moir))
-	fmt.Printf("Address      : %v\n", moir.Address)
-	fmt.Printf("M            : %v\n", moir.M)
-	fmt.Printf("N            : %v\n", moir.N)
-	for k := range moir.Pubkeys {
-		pubk, err := hex.DecodeString(moir.Pubkeys[k])
-		if err != nil {
-			fmt.Printf("Could not decode %v: %v\n",
-				moir.Pubkeys[k], err)
+	maxInputs, err := ArgAsInt("maxinputs", a)
+	if err != nil {
+		return err
+	}
+	confirmations, err := ArgAsInt("confirmations", a)
+	if err != nil {
+		return err
+	}
+	dryRun, err := ArgAsBool("dryrun", a)
+	if err != nil {
+		return err
+	}
+	expiry, lockTime, err := c.txTiming(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	req := c.utxoRequest([]string{address}, confirmations, a)
+	consolidations, err := c.ms.Consolidate(ctx,
+		&multisig.ConsolidateRequest{
+			Address:       address,
+			MinValue:      minAtoms,
+			MaxInputs:     maxInputs,
+			Confirmations: req.Confirmations,
+			Inputs:        req.Inputs,
+			Exclude:       req.Exclude,
+			RedeemScript:  c.redeemScripts[address],
+			Expiry:        expiry,
+			LockTime:      lockTime,
+			DryRun:        dryRun,
+		})
+	if err != nil {
+		return err
+	}
+
+	// QR files are numbered per transaction when there are several
+	var txs int
+	for _, cs := range consolidations {
+		if cs.Tx != nil {
+			txs++
+		}
+	}
+
+	var totalFee, totalSaved dcrutil.Amount
+	for k, cs := range consolidations {
+		totalFee += cs.Fee
+		totalSaved += cs.Saved
+
+		fmt.Fprintf(os.Stderr, "Transaction %v: %v inputs, %v, fee %v, "+
+			"future spends save %v\n", k, len(cs.Inputs), cs.Value,
+			cs.Fee, cs.Saved)
+		if cs.Tx == nil {
 			continue
 		}
-		a, err := dcrutil.NewAddressSecpPubKey(pubk, c.cfg.params)
+		qr := c.cfg.QR
+		if txs > 1 {
+			qr = qrTxOutput(qr, k+1)
+		}
+		err = c.printUnsignedTx(cs.Tx, qr)
 		if err != nil {
-			fmt.Printf("Could not decode %v: %v\n",
-				moir.Pubkeys[k], err)
-			continue
+			return err
 		}
-		fmt.Printf("Public key   : %v\n", a)
 	}
-	fmt.Printf("Redeem script: %v\n", moir.RedeemScript)
+	fmt.Fprintf(os.Stderr, "Consolidation fee %v, future spends save %v, "+
+		"net %v\n", totalFee, totalSaved, totalSaved-totalFee)
+
 	return nil
 }
 
-func (c *client) sweepMultisig(ctx context.Context, a map[string]string) error {
-	return fmt.Errorf("not implemented yet")
-}
+func (c *client) listMultisigUtxos(ctx context.Context, a map[string]string) error {
+	addresses, err := ArgAsStringSlice("address", a)
+	if err != nil {
+		return err
+	}
+	confirmations, err := ArgAsInt("confirmations", a)
+	if err != nil {
+		return err
+	}
 
-func _main() error {
-	cfg, args, err := loadConfig()
+	req := c.utxoRequest(addresses, confirmations, a)
+	utxos, err := c.ms.ListUtxos(ctx, &req)
 	if err != nil {
 		return err
 	}
 
-	if len(args) == 0 {
-		return fmt.Errorf("no action provided")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "Outpoint\tAmount\tConfirmations\tTree\tAddress\n")
+	for _, u := range utxos {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n",
+			multisig.OutpointKey(u.TxnID, u.Vout),
+			dcrutil.Amount(u.Satoshis), u.Confirmations,
+			multisig.TreeString(u.Tree), u.Address)
 	}
+	return w.Flush()
+}
 
-	// Deal with command line
-	a, err := ParseArgs(args)
+func _main() error {
+	cfg, args, err := loadConfig()
 	if err != nil {
 		return err
 	}
@@ -547,41 +1017,47 @@ func _main() error {
 	// Initialize loggers
 	loggo.ConfigureLoggers(cfg.Log)
 
-	ctx := context.Background()
+	// Abort outstanding work on interrupt.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt)
+	go func() {
+		<-sigC
+		log.Infof("Interrupt received, aborting")
+		cancel()
+	}()
+
+	return newClient(cfg).run(ctx, args)
+}
 
-	c := &client{
-		cfg: cfg,
+// run executes the action and arguments provided on the command line.
+func (c *client) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no action provided")
 	}
-
-	// Handle actions
-	if len(args) > 0 {
-		switch args[0] {
-		case "getmultisigbalance":
-			return c.getMultiSigBalance(ctx, a)
-		case "getwalletbalance":
-			return c.getWalletBalance(ctx, a)
-		case "getnewkey":
-			return c.getNewKey(ctx, a)
-		case "createmultisigaddress":
-			return c.createMultisigAddress(ctx, a)
-		case "sendtomultisig":
-			return c.sendToMultisig(ctx, a)
-		case "createmultisigtx":
-			return c.createMultisigTx(ctx, a)
-		case "signmultisigtx":
-			return c.signMultiSigTx(ctx, a)
-		case "broadcastmultisigtx":
-			return c.broadcastMultisigTx(ctx, a)
-		case "multisiginfo":
-			return c.multisigInfo(ctx, a)
-		case "sweepmultisig":
-			return c.sweepMultisig(ctx, a)
-		default:
-			return fmt.Errorf("invalid action: %v", args[0])
-		}
+	if ok, err := runBuiltin(os.Stdout, args); ok {
+		return err
 	}
 
-	return nil
+	// Deal with command line
+	ac, args, err := lookupAction(args)
+	if err != nil {
+		return err
+	}
+	qrArgs, err := c.qrArgs()
+	if err != nil {
+		return err
+	}
+	a, err := ac.parse(append(args, qrArgs...))
+	if err != nil {
+		return err
+	}
+	err = c.resolveDescriptors(a)
+	if err != nil {
+		return err
+	}
+	return ac.run(c, ctx, a)
 }
 
 func main() {
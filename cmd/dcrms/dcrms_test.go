@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	fundingTxID = "ce365de0a58a8ad89d5fd173c0d6191bf4c111448ea112661c8200eb5ca0fb67"
+	escrowAddr  = "TcerhCZvVVzjYKQoKUybohE75ZxPgPqManG"
+
+	// escrowScript is the 2 of 3 redeem script of escrowAddr.
+	escrowScript = "52210254cf9dc4798eabd6dd1e34a6ea2a4d387bc6b766b1c7360" +
+		"9a27d12da3ab9d9772102b687ff58749bd90dd50b37776312d73e91549dccd" +
+		"f81327bda9cb42df855f2652103a2d4d194f1369e147dc88bbc5d7c280ca32" +
+		"3da1b660cc7e7782db59db491fd2e53ae"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExpiryWarning(t *testing.T) {
+	tests := []struct {
+		expiry uint32
+		height uint32
+		warn   bool
+	}{
+		{0, 100, true},
+		{100, 100, true},
+		{101, 100, true},
+		{100 + expiryWarningBlocks, 100, true},
+		{101 + expiryWarningBlocks, 100, false},
+	}
+	for _, tt := range tests {
+		tx := wire.NewMsgTx()
+		tx.Expiry = tt.expiry
+		got := expiryWarning(tx, tt.height)
+		if (got != "") != tt.warn {
+			t.Fatalf("expiry %v height %v: got %q", tt.expiry,
+				tt.height, got)
+		}
+	}
+}
+
+func TestMemoArg(t *testing.T) {
+	memo, err := memoArg(map[string]string{"memo": "0xdeadbeef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(memo, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Fatalf("got %x", memo)
+	}
+
+	// No memo argument.
+	memo, err = memoArg(map[string]string{})
+	if err != nil || memo != nil {
+		t.Fatalf("got %x %v", memo, err)
+	}
+}
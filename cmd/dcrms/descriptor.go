@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// splitAddresses splits a comma separated list of addresses and descriptors.
+// Commas inside descriptors do not separate elements.
+func splitAddresses(s string) []string {
+	var (
+		elements []string
+		depth    int
+		start    int
+	)
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				elements = append(elements, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elements, s[start:])
+}
+
+// resolveDescriptors replaces the descriptors of the address argument with
+// their addresses and remembers the redeem scripts so that the wallet does
+// not have to know the contracts.
+func (c *client) resolveDescriptors(a map[string]string) error {
+	value, ok := a["address"]
+	if !ok || !multisig.IsDescriptor(value) {
+		return nil
+	}
+	addresses := splitAddresses(value)
+	for k, address := range addresses {
+		if !multisig.IsDescriptor(address) {
+			continue
+		}
+		contract, err := multisig.ParseDescriptor(address, c.cfg.params)
+		if err != nil {
+			return err
+		}
+		if c.redeemScripts == nil {
+			c.redeemScripts = make(map[string][]byte)
+		}
+		c.redeemScripts[contract.Address] = contract.RedeemScript
+		addresses[k] = contract.Address
+	}
+	a["address"] = strings.Join(addresses, ",")
+	return nil
+}
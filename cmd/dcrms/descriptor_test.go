@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitAddresses(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"a,b", []string{"a", "b"}},
+		{"n:sh(multi(1,k1,k2))#c,b", []string{"n:sh(multi(1,k1,k2))#c",
+			"b"}},
+		{"a,n:sh(multi(1,k))#c", []string{"a", "n:sh(multi(1,k))#c"}},
+	}
+	for _, tt := range tests {
+		got := splitAddresses(tt.s)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%v: got %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestDescriptor verifies that descriptors round trip with the contracts of
+// createmultisigaddress and the wallet and are accepted in place of
+// addresses.
+func TestDescriptor(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "dave")
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		clients[name] = newClient(cfg)
+	}
+	alice, bob, dave := clients["alice"], clients["bob"], clients["dave"]
+
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	lines := strings.Split(strings.TrimSpace(run(t, alice,
+		"createmultisigaddress", "n=2", "contract=escrow",
+		"keys="+strings.Join(keys, ","))), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %q", lines)
+	}
+	address, redeemScript, descriptor := lines[0], lines[1], lines[2]
+	expect(t, descriptor, "sh(")
+
+	// The descriptor defines the contract without the wallet.
+	out := run(t, dave, "multisiginfo", "address="+descriptor)
+	if field(t, out, "Address") != address ||
+		field(t, out, "Redeem script") != redeemScript ||
+		field(t, out, "Descriptor") != descriptor {
+		t.Fatalf("got %v", out)
+	}
+
+	// The redeem script of the wallet has the same descriptor.
+	run(t, alice, "sendtomultisig", "address="+descriptor, "amount=3")
+	out = run(t, alice, "multisiginfo", "address="+address)
+	if field(t, out, "Descriptor") != descriptor {
+		t.Fatalf("got %v", out)
+	}
+
+	// Spend with the descriptor.
+	expect(t, run(t, dave, "listmultisigutxos", "address="+descriptor),
+		"3 DCR")
+	tx := lastLine(run(t, dave, "createmultisigtx", "address="+descriptor,
+		"to="+payee, "amount=1"))
+	out = run(t, alice, "signmultisigtx", "tx="+tx)
+	out = run(t, bob, "signmultisigtx", "tx="+lastLine(out))
+	expect(t, out, "SIGNING COMPLETE")
+
+	// Corrupted descriptors are refused.
+	corrupt := strings.Replace(descriptor, "(2,", "(1,", 1)
+	_, err := capture(t, func() error {
+		return dave.run(context.Background(), []string{
+			"multisiginfo", "address=" + corrupt})
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// capture runs f and returns what it printed on stdout.
+func capture(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		done <- string(b)
+	}()
+	err = f()
+	w.Close()
+	os.Stdout = stdout
+	return <-done, err
+}
+
+// run executes an action, as provided on the command line, and returns its
+// output.
+func run(t *testing.T, c *client, args ...string) string {
+	t.Helper()
+	out, err := capture(t, func() error {
+		return c.run(context.Background(), args)
+	})
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+// lastLine returns the last line of the output of an action, which is where
+// actions print transactions.
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return lines[len(lines)-1]
+}
+
+// field returns the value of the first "Label : value" line with the provided
+// label.
+func field(t *testing.T, out, label string) string {
+	t.Helper()
+	for _, l := range strings.Split(out, "\n") {
+		s := strings.SplitN(l, ":", 2)
+		if len(s) == 2 && strings.TrimSpace(s[0]) == label {
+			return strings.TrimSpace(s[1])
+		}
+	}
+	t.Fatalf("%v not found in %q", label, out)
+	return ""
+}
+
+func expect(t *testing.T, out, want string) {
+	t.Helper()
+	if !strings.Contains(out, want) {
+		t.Fatalf("%q not found in %q", want, out)
+	}
+}
+
+// TestEndToEnd drives every action against three mock wallets that share a
+// mock chain.
+func TestEndToEnd(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "carol")
+	proposalDir := t.TempDir()
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		cfg.ProposalDir = proposalDir
+		clients[name] = newClient(cfg)
+	}
+	alice, bob, carol := clients["alice"], clients["bob"], clients["carol"]
+
+	// Create the 2 of 3 contract from keys whose possession is proven.
+	expect(t, run(t, alice, "getwalletbalance"), "100")
+	var keys []string
+	for _, c := range []*client{alice, bob, carol} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	_, err := capture(t, func() error {
+		return alice.run(context.Background(), []string{
+			"createmultisigaddress", "n=2", "contract=payroll",
+			"keys=" + strings.Join(keys, ",")})
+	})
+	if err == nil || !strings.Contains(err.Error(), "did not sign") {
+		t.Fatalf("got %v", err)
+	}
+
+	// Bare keys are refused without an explicit reason.
+	bareKeys := make([]string, 0, len(keys))
+	for _, k := range keys {
+		bareKeys = append(bareKeys, strings.Split(k, ":")[0])
+	}
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"keys=" + strings.Join(bareKeys, ",")},
+			"key statements are required"},
+		{[]string{"keys=" + strings.Join(bareKeys, ","),
+			"unverifiedkeys="}, "unverifiedkeys: empty value"},
+		{[]string{"keys=" + strings.Join(keys, ","),
+			"unverifiedkeys=lost"}, "key statements require contract"},
+		{[]string{"keys=" + strings.Join(bareKeys, ","),
+			"contract=escrow", "unverifiedkeys=lost"},
+			"mutually exclusive"},
+	} {
+		_, err := capture(t, func() error {
+			return alice.run(context.Background(), append([]string{
+				"createmultisigaddress", "n=2"}, tt.args...))
+		})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: got %v", tt.args, err)
+		}
+	}
+	var escrow string
+	for _, c := range []*client{alice, bob, carol} {
+		out := run(t, c, "createmultisigaddress", "n=2",
+			"contract=escrow", "keys="+strings.Join(keys, ","))
+		address := strings.Split(out, "\n")[0]
+		if escrow != "" && address != escrow {
+			t.Fatalf("contract mismatch: %v %v", address, escrow)
+		}
+		escrow = address
+	}
+
+	// Fund it.
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=5")
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=0.5")
+	expect(t, run(t, bob, "getmultisigbalance", "address="+escrow), "5.5")
+	out := run(t, bob, "multisiginfo", "address="+escrow)
+	if field(t, out, "M") != "2" || field(t, out, "N") != "3" {
+		t.Fatalf("got %v", out)
+	}
+	expect(t, run(t, bob, "listmultisigutxos", "address="+escrow),
+		"0.5 DCR")
+	out = run(t, bob, "consolidatemultisig", "address="+escrow,
+		"minvalue=10", "dryrun=true")
+	if strings.TrimSpace(out) != "" {
+		t.Fatalf("dry run printed transactions: %v", out)
+	}
+
+	// Spend with signmultisigtx and broadcastmultisigtx.
+	tx := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=1", "memo=INV-1"))
+	out = run(t, bob, "decodemultisigtx", "tx="+tx)
+	expect(t, out, `memo "INV-1"`)
+	expect(t, out, "0/2 signatures")
+	out = run(t, alice, "signmultisigtx", "tx="+tx)
+	expect(t, out, "*NOT* COMPLETE")
+	out = run(t, bob, "signmultisigtx", "tx="+lastLine(out))
+	expect(t, out, "SIGNING COMPLETE")
+	run(t, bob, "broadcastmultisigtx", "tx="+lastLine(out))
+	balance := lastLine(run(t, carol, "getmultisigbalance",
+		"address="+escrow))
+	if !strings.HasPrefix(balance, "4.49") {
+		t.Fatalf("got balance %v", balance)
+	}
+
+	// Spend through the coordinator.
+	listen := freeAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- carol.run(ctx, []string{"serve", "listen=" + listen})
+	}()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	}()
+	coordinator := "coordinator=http://" + listen
+	waitListen(t, listen)
+	tx = lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=0.5"))
+	out = run(t, alice, "proposemultisigtx", coordinator, "tx="+tx)
+	id := "id=" + field(t, out, "Proposal")
+	out = run(t, alice, "signmultisigtx", coordinator, id)
+	expect(t, out, "0.5 DCR "+payee)
+	expect(t, out, "Fee          :")
+	out = run(t, bob, "signmultisigtx", coordinator, id)
+	expect(t, out, "Complete     : true")
+	expect(t, run(t, alice, "proposalstatus", coordinator), "true")
+	run(t, alice, "broadcastmultisigtx", coordinator, id)
+	expect(t, run(t, bob, "proposalstatus", coordinator, id), "Broadcast")
+
+	// Sweep through the shared proposal directory and reject a spend.
+	sweep := lastLine(run(t, alice, "sweepmultisig", "address="+escrow,
+		"to="+payee))
+	spend := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=0.1"))
+	sweepID := "id=" + field(t, run(t, alice, "propose", "tx="+sweep,
+		"description=sweep"), "Proposal")
+	spendID := "id=" + field(t, run(t, alice, "propose", "tx="+spend),
+		"Proposal")
+	out = run(t, bob, "pending")
+	expect(t, out, strings.TrimPrefix(sweepID, "id="))
+	expect(t, out, strings.TrimPrefix(spendID, "id="))
+	expect(t, out, "0.1 DCR "+payee)
+	expect(t, out, "Fee          :")
+	expect(t, run(t, carol, "reject", spendID, "reason=duplicate"),
+		"rejected")
+	out = run(t, alice, "approve", sweepID)
+	expect(t, out, "Fee          :")
+	expect(t, out, "State        : open")
+	expect(t, run(t, carol, "approve", sweepID), "State        : signed")
+	expect(t, run(t, bob, "pending"), "No proposals")
+	run(t, bob, "broadcastmultisigtx", sweepID)
+	p, err := readProposal(proposalDir, strings.TrimPrefix(sweepID, "id="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State != proposalBroadcast {
+		t.Fatalf("got state %v", p.State)
+	}
+	expect(t, run(t, alice, "getmultisigbalance", "address="+escrow), "0")
+
+	// Every party has an intact audit log.
+	for _, c := range []*client{alice, bob, carol} {
+		expect(t, run(t, c, "auditlog", "verify"), "Audit log OK")
+		expect(t, run(t, c, "auditlog", "export", "format=csv"),
+			"signature")
+	}
+	if _, err := os.Stat(filepath.Join(proposalDir,
+		strings.TrimPrefix(sweepID, "id=")+".lock")); !os.IsNotExist(err) {
+		t.Fatalf("lock left behind: %v", err)
+	}
+}
+
+// forgeValueIn returns the provided transaction with the value of its first
+// input replaced.
+func forgeValueIn(t *testing.T, txS string, value int64) string {
+	t.Helper()
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.TxIn[0].ValueIn = value
+	txS, err = multisig.EncodeTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return txS
+}
+
+// TestDecodeInputValues verifies that decodemultisigtx reports input values
+// and the fee from the previous transactions.
+func TestDecodeInputValues(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice := newClient(configs["alice"])
+	bob := newClient(configs["bob"])
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	escrow := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")[0]
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=5")
+	tx := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=1"))
+
+	out := run(t, bob, "decodemultisigtx", "tx="+tx)
+	expect(t, out, " 5 DCR 0/2 signatures")
+	if strings.Contains(out, "unverified") {
+		t.Fatalf("got %v", out)
+	}
+
+	// An input value that hides most of the fee.
+	_, err := capture(t, func() error {
+		return bob.run(context.Background(), []string{
+			"decodemultisigtx", "tx=" + forgeValueIn(t, tx, 1.1e8)})
+	})
+	if err == nil || !strings.Contains(err.Error(), "value 1.1 DCR does "+
+		"not match previous output value 5 DCR") {
+		t.Fatalf("got %v", err)
+	}
+
+	// Without explorer the values are labeled.
+	cfg := *configs["bob"]
+	cfg.dcrdata = "http://" + freeAddress(t) + "/api"
+	out = run(t, newClient(&cfg), "decodemultisigtx", "tx="+tx)
+	expect(t, out, " 5 DCR (unverified) 0/2 signatures")
+	expect(t, out, "(unverified)\n")
+}
+
+// TestCreateTxWalletBalance verifies that a spend from a contract does not
+// depend on the balance of the proposer's own wallet.
+func TestCreateTxWalletBalance(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice := newClient(configs["alice"])
+	bob := newClient(configs["bob"])
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	escrow := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")[0]
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=95")
+
+	out := run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=50")
+	if strings.Contains(out, "TxIn") {
+		t.Fatalf("transaction dumped: %v", out)
+	}
+	expect(t, run(t, bob, "decodemultisigtx", "tx="+lastLine(out)),
+		"50 DCR "+payee)
+}
+
+// freeAddress returns a local address that is free to listen on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// waitListen waits until a server listens on the provided address.
+func waitListen(t *testing.T, address string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		c, err := net.Dial("tcp", address)
+		if err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("nothing listens on %v", address)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/decred/dcrd/dcrutil/v3"
+	it "github.com/decred/dcrdata/api/types"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// Used returns true if the address ever received funds.
+func (e explorer) Used(ctx context.Context, address string) (bool, error) {
+	var addr it.InsightAddressInfo
+	err := e.c.httpRequestJSON(ctx, e.c.cfg.insight+"/addr/"+address, &addr)
+	if err != nil {
+		return false, err
+	}
+	return addr.TxAppearances > 0 || addr.UnconfirmedTxAppearances > 0,
+		nil
+}
+
+// hdContract returns the HD contract of the m, xpubs and optional path
+// arguments. An HD contract is created by its first use, which is recorded in
+// the audit log.
+func (c *client) hdContract(a map[string]string) (*multisig.HDContract, error) {
+	m, err := ArgAsUint("m", a)
+	if err != nil {
+		return nil, err
+	}
+	xpubs, err := ArgAsStringSlice("xpubs", a)
+	if err != nil {
+		return nil, err
+	}
+	path, err := multisig.ParseHDPath(a["path"])
+	if err != nil {
+		return nil, err
+	}
+	h, err := multisig.NewHDContract(int(m), xpubs, path, c.cfg.params)
+	if err != nil {
+		return nil, err
+	}
+	err = c.auditHDContract(h)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// auditHDContract records the HD contract in the audit log unless it already
+// is. The record is named after the policy name of the contract.
+func (c *client) auditHDContract(h *multisig.HDContract) error {
+	name := hdPolicyName(h)
+	recorded, err := auditHDContractRecorded(c.cfg.auditDir, name)
+	if err != nil {
+		return fmt.Errorf("audit log: %v", err)
+	}
+	if recorded {
+		return nil
+	}
+	return c.audit(auditContract, struct {
+		HD    string   `json:"hd"`
+		M     uint     `json:"m"`
+		XPubs []string `json:"xpubs"`
+		Path  string   `json:"path,omitempty"`
+	}{
+		HD:    name,
+		M:     uint(h.M),
+		XPubs: h.XPubs,
+		Path:  multisig.HDPathString(h.Path),
+	})
+}
+
+// hdScan returns the HD contract of the arguments and its used addresses.
+func (c *client) hdScan(ctx context.Context, a map[string]string) (*multisig.HDContract, *multisig.HDScan, error) {
+	h, err := c.hdContract(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	gapLimit, err := ArgAsInt("gaplimit", a)
+	if err != nil {
+		return nil, nil, err
+	}
+	scan, err := c.ms.ScanHD(ctx, h, gapLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, scan, nil
+}
+
+// hdPolicyName returns the name the signing policy of an HD contract is
+// stored under. It commits to m, the extended keys, in order, and the path,
+// which define the contract, so that all derived addresses share one policy
+// and ledger.
+func hdPolicyName(h *multisig.HDContract) string {
+	hash := sha256.Sum256([]byte(strconv.Itoa(h.M) + ":" +
+		strings.Join(h.XPubs, ",") + ":" +
+		multisig.HDPathString(h.Path)))
+	return "hd-" + hex.EncodeToString(hash[:16])
+}
+
+// hdPolicyNames returns the policy name of the HD contract by every address
+// derived on both branches up to the gap limit after the last used one. These
+// are all addresses a scan finds, so an output to any of them stays in the
+// contract and is change.
+func hdPolicyNames(h *multisig.HDContract, scan *multisig.HDScan, gapLimit int) (map[string]string, error) {
+	if gapLimit <= 0 {
+		gapLimit = multisig.DefaultGapLimit
+	}
+	name := hdPolicyName(h)
+	names := make(map[string]string)
+	for _, branch := range []uint32{multisig.ExternalBranch,
+		multisig.InternalBranch} {
+		for index := uint32(0); index < scan.Next[branch]+
+			uint32(gapLimit); index++ {
+			contract, err := h.Derive(branch, index)
+			if err != nil {
+				return nil, err
+			}
+			names[contract.Address] = name
+		}
+	}
+	return names, nil
+}
+
+// branchName returns the name of an HD contract branch.
+func branchName(branch uint32) string {
+	if branch == multisig.InternalBranch {
+		return "change"
+	}
+	return "external"
+}
+
+func (c *client) getXPub(ctx context.Context, a map[string]string) error {
+	account, err := ArgAsString("account", a)
+	if err != nil {
+		return err
+	}
+	var xpub string
+	err = c.walletCall(ctx, "getmasterpubkey", &xpub, account)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v\n", xpub)
+
+	return nil
+}
+
+func (c *client) hdAddress(ctx context.Context, a map[string]string) error {
+	// Without an index the first unused deposit address is returned
+	var (
+		h     *multisig.HDContract
+		index uint
+		err   error
+	)
+	if _, ok := a["index"]; ok {
+		h, err = c.hdContract(a)
+		if err != nil {
+			return err
+		}
+		index, err = ArgAsUint("index", a)
+		if err != nil {
+			return err
+		}
+	} else {
+		var scan *multisig.HDScan
+		h, scan, err = c.hdScan(ctx, a)
+		if err != nil {
+			return err
+		}
+		index = uint(scan.Next[multisig.ExternalBranch])
+	}
+
+	contract, err := h.Derive(multisig.ExternalBranch, uint32(index))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v\n", contract.Address)
+	fmt.Printf("Index        : %v\n", index)
+	fmt.Printf("Redeem script: %x\n", contract.RedeemScript)
+
+	return nil
+}
+
+func (c *client) hdScanAction(ctx context.Context, a map[string]string) error {
+	h, scan, err := c.hdScan(ctx, a)
+	if err != nil {
+		return err
+	}
+	confirmations, err := ArgAsInt("confirmations", a)
+	if err != nil {
+		return err
+	}
+
+	// Aggregate the balance of all used addresses
+	balances := make(map[string]dcrutil.Amount, len(scan.Used))
+	var total dcrutil.Amount
+	if len(scan.Used) > 0 {
+		utxos, err := c.ms.Utxos(ctx, &multisig.UtxoRequest{
+			Addresses:     scan.Addresses(),
+			Confirmations: int64(confirmations),
+		})
+		if err != nil {
+			return err
+		}
+		for _, u := range utxos {
+			balances[u.Address] += dcrutil.Amount(u.Satoshis)
+			total += dcrutil.Amount(u.Satoshis)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "Branch\tIndex\tAddress\tBalance\n")
+	for _, u := range scan.Used {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", branchName(u.Branch),
+			u.Index, u.Contract.Address, balances[u.Contract.Address])
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Balance      : %v\n", total)
+	fmt.Printf("Next deposit : %v\n", scan.Next[multisig.ExternalBranch])
+	fmt.Printf("Next change  : %v\n", scan.Next[multisig.InternalBranch])
+	fmt.Printf("Policy       : %v\n", hdPolicyName(h))
+
+	return nil
+}
+
+func (c *client) createHDMultisigTx(ctx context.Context, a map[string]string) error {
+	h, scan, err := c.hdScan(ctx, a)
+	if err != nil {
+		return err
+	}
+	if len(scan.Used) == 0 {
+		return fmt.Errorf("no used addresses")
+	}
+	to, err := ArgAsString("to", a)
+	if err != nil {
+		return err
+	}
+	amount, err := ArgAsAmount("amount", a)
+	if err != nil {
+		return err
+	}
+	confirmations, err := ArgAsInt("confirmations", a)
+	if err != nil {
+		return err
+	}
+	expiry, lockTime, err := c.txTiming(ctx, a)
+	if err != nil {
+		return err
+	}
+	memo, err := memoArg(a)
+	if err != nil {
+		return err
+	}
+
+	// Change goes to a fresh address of the change branch
+	change, err := h.Derive(multisig.InternalBranch,
+		scan.Next[multisig.InternalBranch])
+	if err != nil {
+		return err
+	}
+
+	req := c.utxoRequest(scan.Addresses(), confirmations, a)
+	req.RedeemScripts = scan.RedeemScripts()
+	res, err := c.ms.BuildTx(ctx, &multisig.TxRequest{
+		UtxoRequest: req,
+		To:          to,
+		Change:      change.Address,
+		Amount:      amount,
+		Memo:        memo,
+		Expiry:      expiry,
+		LockTime:    lockTime,
+	})
+	if err != nil {
+		return err
+	}
+	log.Debugf("fee %v change %v to %v", res.Fee, res.Change,
+		change.Address)
+
+	tx, err := multisig.EncodeTx(res.Tx)
+	if err != nil {
+		return err
+	}
+	err = c.auditProposal(res.Tx)
+	if err != nil {
+		return err
+	}
+	err = c.qrOutput(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v\n", tx)
+
+	return nil
+}
+
+func (c *client) signHDMultisigTx(ctx context.Context, a map[string]string) error {
+	h, scan, err := c.hdScan(ctx, a)
+	if err != nil {
+		return err
+	}
+	txS, err := ArgAsString("tx", a)
+	if err != nil {
+		return err
+	}
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		return err
+	}
+	account, err := ArgAsString("account", a)
+	if err != nil {
+		return err
+	}
+
+	// Let the wallet derive the keys of every input
+	derivations, err := c.ms.PrepareHDSign(ctx, tx, h, scan, account)
+	if err != nil {
+		return err
+	}
+	for k, d := range derivations {
+		fmt.Printf("Input %v derivation: %v/%v\n", k,
+			branchName(d.Branch), d.Index)
+	}
+
+	// Policies apply to the contract, not to its derived addresses
+	gapLimit, err := ArgAsInt("gaplimit", a)
+	if err != nil {
+		return err
+	}
+	c.policyNames, err = hdPolicyNames(h, scan, gapLimit)
+	if err != nil {
+		return err
+	}
+
+	return c.signMultiSigTx(ctx, a)
+}
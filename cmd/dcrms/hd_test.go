@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// TestHD spends from two deposit addresses of a 2 of 3 HD contract and
+// verifies that change goes to a fresh change address.
+func TestHD(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "carol", "dave")
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		clients[name] = newClient(cfg)
+	}
+	alice, bob, dave := clients["alice"], clients["bob"], clients["dave"]
+
+	var xpubs []string
+	for _, name := range []string{"alice", "bob", "carol"} {
+		xpubs = append(xpubs, lastLine(run(t, clients[name], "getxpub")))
+	}
+	contract := []string{"m=2", "xpubs=" + strings.Join(xpubs, ",")}
+	hd := func(c *client, action string, args ...string) string {
+		t.Helper()
+		return run(t, c, append(append([]string{action}, contract...),
+			args...)...)
+	}
+
+	// Every deposit goes to a new address.
+	for _, amount := range []string{"5", "2"} {
+		address := strings.Split(hd(alice, "hdaddress"), "\n")[0]
+		run(t, alice, "sendtomultisig", "address="+address,
+			"amount="+amount)
+	}
+	out := hd(bob, "hdscan")
+	if field(t, out, "Balance") != "7 DCR" ||
+		field(t, out, "Next deposit") != "2" ||
+		field(t, out, "Next change") != "0" {
+		t.Fatalf("got %v", out)
+	}
+	expect(t, hd(bob, "hdaddress"), "Index        : 2")
+	expect(t, hd(bob, "hdaddress", "index=0"), "Index        : 0")
+
+	// The contract was recorded once, on first use.
+	b, err := ioutil.ReadFile(filepath.Join(bob.cfg.auditDir,
+		auditLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), `"event":"contract"`); n != 1 {
+		t.Fatalf("got %v contract records", n)
+	}
+	h, err := multisig.NewHDContract(2, xpubs, nil, bob.cfg.params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, string(b), `"hd":"`+hdPolicyName(h)+`"`)
+
+	// Spend both deposits, only cosigners can sign.
+	tx := lastLine(hd(alice, "createhdmultisigtx", "to="+payee,
+		"amount=6"))
+	_, err = capture(t, func() error {
+		return dave.run(context.Background(), append([]string{
+			"signhdmultisigtx", "tx=" + tx}, contract...))
+	})
+	if err == nil || !strings.Contains(err.Error(), "not a cosigner") {
+		t.Fatalf("got %v", err)
+	}
+	out = hd(alice, "signhdmultisigtx", "tx="+tx)
+	expect(t, out, "Input 0 derivation: external/")
+	expect(t, out, "*NOT* COMPLETE")
+	out = hd(bob, "signhdmultisigtx", "tx="+lastLine(out))
+	expect(t, out, "SIGNING COMPLETE")
+	run(t, bob, "broadcastmultisigtx", "tx="+lastLine(out))
+
+	// The change landed on the first change address.
+	out = hd(bob, "hdscan")
+	expect(t, out, "change")
+	expect(t, field(t, out, "Balance"), "0.99")
+	if field(t, out, "Next change") != "1" {
+		t.Fatalf("got %v", out)
+	}
+}
+
+// TestHDPolicy verifies that the policy of an HD contract applies to spends
+// from its derived addresses and that derived change is not a payment.
+func TestHDPolicy(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice, bob := newClient(configs["alice"]), newClient(configs["bob"])
+
+	var xpubs []string
+	for _, c := range []*client{alice, bob} {
+		xpubs = append(xpubs, lastLine(run(t, c, "getxpub")))
+	}
+	contract := []string{"m=2", "xpubs=" + strings.Join(xpubs, ",")}
+	hd := func(c *client, action string, args ...string) string {
+		t.Helper()
+		return run(t, c, append(append([]string{action}, contract...),
+			args...)...)
+	}
+	address := strings.Split(hd(alice, "hdaddress"), "\n")[0]
+	run(t, alice, "sendtomultisig", "address="+address, "amount=5")
+
+	// The policy is named after the contract, not an address.
+	name := field(t, hd(bob, "hdscan"), "Policy")
+	if name != hdPolicyName(&multisig.HDContract{M: 2, XPubs: xpubs}) {
+		t.Fatalf("got %v", name)
+	}
+	err := os.MkdirAll(bob.cfg.policyDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(bob.policyFilename(name),
+		[]byte(`{"maxamount": 3, "allowlist": ["`+payee+`"]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := lastLine(hd(alice, "createhdmultisigtx", "to="+payee,
+		"amount=4"))
+	_, err = capture(t, func() error {
+		return bob.run(context.Background(), append([]string{
+			"signhdmultisigtx", "tx=" + tx}, contract...))
+	})
+	if err == nil || !strings.Contains(err.Error(),
+		name+": amount 4 DCR exceeds maximum 3 DCR") {
+		t.Fatalf("got %v", err)
+	}
+
+	// The change to a derived change address is neither refused by the
+	// allowlist nor counted as spent.
+	tx = lastLine(hd(alice, "createhdmultisigtx", "to="+payee,
+		"amount=2"))
+	hd(bob, "signhdmultisigtx", "tx="+tx)
+	b, err := ioutil.ReadFile(filepath.Join(bob.cfg.policyDir,
+		name+".ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l []ledgerEntry
+	err = json.Unmarshal(b, &l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 || l[0].Amount != 2e8 {
+		t.Fatalf("got %+v", l)
+	}
+
+	// A path defines another contract with its own policy, which the
+	// wallet can not sign for.
+	out := hd(bob, "hdscan", "path=0/5")
+	if field(t, out, "Policy") == name {
+		t.Fatalf("path not part of the policy name: %v", out)
+	}
+	if strings.Split(hd(bob, "hdaddress", "path=0/5"), "\n")[0] ==
+		address {
+		t.Fatal("path not used in derivation")
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hdaddress", "path=0'/5"}, "hardened step 0'"},
+		{[]string{"signhdmultisigtx", "path=0/5", "tx=" + tx},
+			"can not sign for keys derived below path 0/5"},
+	} {
+		_, err = capture(t, func() error {
+			return bob.run(context.Background(),
+				append(tt.args, contract...))
+		})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("%v: got %v, want %v", tt.args, err, tt.want)
+		}
+	}
+}
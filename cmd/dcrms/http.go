@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/go-socks/socks"
+)
+
+const (
+	// retryBaseDelay is the initial delay between HTTP retries. It is
+	// doubled on every attempt and jittered.
+	retryBaseDelay = 500 * time.Millisecond
+
+	// retryMaxDelay caps the delay between HTTP retries.
+	retryMaxDelay = 10 * time.Second
+)
+
+// loopbackHost returns true if the host of the provided host:port address is
+// localhost or a loopback IP. Names are not resolved, which would leak them
+// outside the proxy.
+func loopbackHost(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// dialLocal connects to the wallet or a coordinator. One on this computer is
+// dialed directly, a remote one through the proxy like all other outbound
+// connections.
+func (c *client) dialLocal(ctx context.Context, network, address string) (net.Conn, error) {
+	if loopbackHost(address) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+	return c.dial(ctx, network, address)
+}
+
+// dial connects to the provided address. When a proxy is configured all
+// outbound connections are routed through it. With Tor isolation enabled
+// every connection uses fresh random credentials and therefore its own
+// circuit.
+func (c *client) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.cfg.Proxy == "" {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	proxy := &socks.Proxy{
+		Addr:         c.cfg.Proxy,
+		Username:     c.cfg.ProxyUser,
+		Password:     c.cfg.ProxyPass,
+		TorIsolation: c.cfg.TorIsolation,
+	}
+
+	// The proxy dialer does not take a context, dial in the background so
+	// that a hung connect can be abandoned when ctx is done.
+	type dialReply struct {
+		conn net.Conn
+		err  error
+	}
+	reply := make(chan dialReply, 1)
+	go func() {
+		conn, err := proxy.Dial(network, address)
+		reply <- dialReply{conn: conn, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-reply; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-reply:
+		return r.conn, r.err
+	}
+}
+
+// newHTTPClient returns the HTTP client that is shared by all explorer
+// lookups.
+func (c *client) newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: c.cfg.HTTPTimeout,
+		Transport: &http.Transport{
+			Proxy:       nil, // Never use environment proxies.
+			DialContext: c.dial,
+
+			// Do not reuse connections so that requests for
+			// different contracts can not be correlated.
+			DisableKeepAlives: c.cfg.TorIsolation,
+
+			TLSHandshakeTimeout:   c.cfg.HTTPTimeout,
+			ResponseHeaderTimeout: c.cfg.HTTPTimeout,
+		},
+	}
+}
+
+// newCoordinatorClient returns the HTTP client of coordinator requests. Unlike
+// the explorer client it connects directly to a coordinator on this computer.
+func (c *client) newCoordinatorClient() *http.Client {
+	return &http.Client{
+		Timeout: c.cfg.HTTPTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // Never use environment proxies.
+			DialContext:           c.dialLocal,
+			TLSHandshakeTimeout:   c.cfg.HTTPTimeout,
+			ResponseHeaderTimeout: c.cfg.HTTPTimeout,
+		},
+	}
+}
+
+// retryable returns true if the HTTP status code indicates a transient
+// server side condition.
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// retryDelay returns the jittered delay before retry attempt n. A
+// Retry-After header, if provided by the server, takes precedence.
+func retryDelay(n int, response *http.Response) time.Duration {
+	if response != nil {
+		ra := response.Header.Get("Retry-After")
+		if s, err := strconv.Atoi(ra); err == nil && s >= 0 {
+			d := time.Duration(s) * time.Second
+			if d > retryMaxDelay {
+				d = retryMaxDelay
+			}
+			return d
+		}
+	}
+
+	d := retryBaseDelay << uint(n)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	// Equal jitter, somewhere between d/2 and d.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// readBody reads the response body and errors if it exceeds the configured
+// maximum size.
+func (c *client) readBody(url string, body io.Reader) ([]byte, error) {
+	max := int64(c.cfg.HTTPMaxResponse)
+	b, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("response too large: %v exceeds %v",
+			url, c.cfg.HTTPMaxResponse)
+	}
+	return b, nil
+}
+
+// httpDo performs a single HTTP GET request. The returned bool indicates if
+// the request may be retried.
+func (c *client) httpDo(ctx context.Context, url string) ([]byte, *http.Response, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, false,
+			fmt.Errorf("unable to create request: %v", err)
+	}
+
+	response, err := c.http.Do(req)
+	if err != nil {
+		// Network errors are transient unless the context is done.
+		return nil, nil, ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := c.readBody(url, response.Body)
+	if err != nil {
+		return nil, response, false, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, response, retryable(response.StatusCode),
+			fmt.Errorf("dcrdata error: %v %v %s",
+				response.StatusCode, url, body)
+	}
+
+	return body, response, false, nil
+}
+
+// httpRequest send an HTTP GET request to the provided URL. Transient
+// failures are retried with exponential backoff and jitter.
+func (c *client) httpRequest(ctx context.Context, url string) ([]byte, error) {
+	for n := 0; ; n++ {
+		log.Debugf("httpRequest: %v", url)
+
+		body, response, retry, err := c.httpDo(ctx, url)
+		if err == nil {
+			return body, nil
+		}
+		if !retry || n >= c.cfg.HTTPRetries {
+			return nil, err
+		}
+
+		d := retryDelay(n, response)
+		log.Debugf("httpRequest: retry %v/%v in %v: %v", n+1,
+			c.cfg.HTTPRetries, d, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// httpRequestJSON sends an HTTP GET request to the provided URL and decodes
+// the JSON reply into v.
+func (c *client) httpRequestJSON(ctx context.Context, url string, v interface{}) error {
+	resp, err := c.httpRequest(ctx, url)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(resp, v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON from %v: %v", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hungProxy returns the address of a SOCKS5 proxy that accepts connections
+// and never replies.
+func hungProxy(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDialProxyContext(t *testing.T) {
+	c := newClient(&config{Proxy: hungProxy(t), NoCache: true})
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.dial(ctx, "tcp", "explorer.example:443")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dial did not abort")
+	}
+}
+
+// recordingProxy returns the address of a SOCKS5 proxy that records the
+// destination of every connect request and then hangs up.
+func recordingProxy(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	destinations := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// Greeting: version, methods. Request: version, command,
+			// reserved, domain name type, length, name and port.
+			b := make([]byte, 262)
+			_, err = io.ReadFull(conn, b[:2])
+			if err == nil {
+				_, err = io.ReadFull(conn, b[:b[1]])
+			}
+			if err == nil {
+				_, err = conn.Write([]byte{5, 0})
+			}
+			if err == nil {
+				_, err = io.ReadFull(conn, b[:5])
+			}
+			if err == nil && b[3] == 3 {
+				n := int(b[4])
+				_, err = io.ReadFull(conn, b[:n+2])
+				if err == nil {
+					destinations <- fmt.Sprintf("%s:%v",
+						b[:n], int(b[n])<<8|int(b[n+1]))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), destinations
+}
+
+// TestWalletProxy verifies that a remote wallet is dialed through the proxy.
+func TestWalletProxy(t *testing.T) {
+	proxy, destinations := recordingProxy(t)
+	c := newClient(&config{
+		Proxy:   proxy,
+		NoCache: true,
+		wallet:  "wss://wallet.example:19110/ws",
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var key string
+	err := c.walletCall(ctx, "getnewaddress", &key)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	select {
+	case d := <-destinations:
+		if d != "wallet.example:19110" {
+			t.Fatalf("got %v", d)
+		}
+	default:
+		t.Fatalf("wallet not dialed through the proxy: %v", err)
+	}
+}
+
+func TestLoopbackHost(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"localhost:19110", true},
+		{"LOCALHOST:19110", true},
+		{"127.0.0.1:19110", true},
+		{"127.1.2.3:19110", true},
+		{"[::1]:19110", true},
+		{"10.0.0.1:19110", false},
+		{"wallet.example:19110", false},
+		{"localhost.example:19110", false},
+	}
+	for _, tt := range tests {
+		if got := loopbackHost(tt.address); got != tt.want {
+			t.Fatalf("%v: got %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+// TestWalletNoProxy verifies that the local wallet is not dialed through the
+// proxy.
+func TestWalletNoProxy(t *testing.T) {
+	_, configs := mockServers(t, "alice")
+	cfg := configs["alice"]
+	cfg.Proxy = hungProxy(t)
+	c := newClient(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var key string
+	err := c.walletCall(ctx, "getnewaddress", &key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for n := 0; n < 8; n++ {
+		d := retryBaseDelay << uint(n)
+		if d > retryMaxDelay {
+			d = retryMaxDelay
+		}
+		got := retryDelay(n, nil)
+		if got < d/2 || got > d {
+			t.Fatalf("%v: got %v, want %v to %v", n, got, d/2, d)
+		}
+	}
+
+	tests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"0", 0},
+		{"3", 3 * time.Second},
+		{"3600", retryMaxDelay},
+	}
+	for _, tt := range tests {
+		response := &http.Response{Header: http.Header{}}
+		response.Header.Set("Retry-After", tt.retryAfter)
+		if got := retryDelay(5, response); got != tt.want {
+			t.Fatalf("%v: got %v, want %v", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+	var (
+		requests int
+		failures int
+		status   int
+		body     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		requests++
+		if requests <= failures {
+			// Retry immediately to keep the test fast.
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		status   int
+		body     string
+		requests int
+		err      string
+	}{
+		{"ok", 2, 0, 0, "ok", 1, ""},
+		{"retried", 2, 2, http.StatusServiceUnavailable, "ok", 3, ""},
+		{"rate limited", 1, 1, http.StatusTooManyRequests, "ok", 2, ""},
+		{"out of retries", 1, 2, http.StatusInternalServerError, "ok", 2,
+			"dcrdata error: 500"},
+		{"not retryable", 3, 1, http.StatusNotFound, "ok", 1,
+			"dcrdata error: 404"},
+		{"at limit", 0, 0, 0, strings.Repeat("x", 16), 1, ""},
+		{"too large", 3, 0, 0, strings.Repeat("x", 17), 1,
+			"response too large"},
+	}
+	for _, tt := range tests {
+		requests, failures, status, body = 0, tt.failures, tt.status,
+			tt.body
+		c := newClient(&config{
+			HTTPTimeout:     5 * time.Second,
+			HTTPRetries:     tt.retries,
+			HTTPMaxResponse: 16,
+			NoCache:         true,
+		})
+		b, err := c.httpRequest(context.Background(), srv.URL)
+		if requests != tt.requests {
+			t.Fatalf("%v: got %v requests, want %v", tt.name, requests,
+				tt.requests)
+		}
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%v: got %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(b) != tt.body {
+			t.Fatalf("%v: got %q %v", tt.name, b, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Pages are A4 with a monospaced font so that printed kits line up like the
+// text version.
+const (
+	pdfWidth        = 595
+	pdfHeight       = 842
+	pdfMargin       = 40
+	pdfFontSize     = 9
+	pdfLeading      = 11
+	pdfLinesPerPage = (pdfHeight - 2*pdfMargin) / pdfLeading
+)
+
+// pdfEscape escapes the provided text for a PDF string literal.
+func pdfEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	return r.Replace(s)
+}
+
+// writePDF writes the provided lines of ASCII text as a PDF document.
+func writePDF(w io.Writer, lines []string) error {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1 to 3 are the catalog, the page tree and the font, followed
+	// by a page and its content for every page.
+	var objects []string
+	kids := make([]string, 0, len(pages))
+	for k := range pages {
+		kids = append(kids, fmt.Sprintf("%v 0 R", 4+2*k))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%v] /Count %v >>",
+			strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+	for k, page := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F1 %v Tf %v TL %v %v Td\n",
+			pdfFontSize, pdfLeading, pdfMargin, pdfHeight-pdfMargin)
+		for _, l := range page {
+			fmt.Fprintf(&content, "(%v) '\n", pdfEscape(l))
+		}
+		fmt.Fprintf(&content, "ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox "+
+				"[0 0 %v %v] /Resources << /Font << /F1 3 0 R "+
+				">> >> /Contents %v 0 R >>", pdfWidth, pdfHeight,
+				5+2*k),
+			fmt.Sprintf("<< /Length %v >>\nstream\n%v\nendstream",
+				content.Len(), content.String()))
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, 0, len(objects))
+	for k, o := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%v 0 obj\n%v\nendobj\n", k+1, o)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %v\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %v /Root 1 0 R >>\nstartxref\n%v\n"+
+		"%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"decred.org/dcrwallet/wallet/txsizes"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	defaultPolicyWindow = 24 * time.Hour
+
+	policyOverrideLog = "override.log"
+)
+
+// policyAmount is an amount of a policy. It is written in DCR as a JSON
+// number, e.g. 0.001, or as a string with a unit, e.g. "1500 mDCR", and is
+// parsed into atoms without going through floating point.
+type policyAmount dcrutil.Amount
+
+// UnmarshalJSON parses a policy amount.
+func (a *policyAmount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+	}
+	amount, err := multisig.ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = policyAmount(amount)
+	return nil
+}
+
+// policy is a per contract signing policy. It is stored as JSON in the policy
+// directory in a file named after the multisig address, e.g.
+// ~/.dcrms/policy/TcerhCZvVVzjYKQoKUybohE75ZxPgPqManG.json, or after the
+// hdPolicyName of an HD contract. Zero values disable a rule.
+type policy struct {
+	MaxAmount     policyAmount `json:"maxamount,omitempty"`     // Per transaction
+	Limit         policyAmount `json:"limit,omitempty"`         // Per window
+	Window        string       `json:"window,omitempty"`        // Default 24h
+	Allowlist     []string     `json:"allowlist,omitempty"`     // Destinations
+	RequireMemo   bool         `json:"requirememo,omitempty"`   // OP_RETURN memo
+	MaxFeeRate    policyAmount `json:"maxfeerate,omitempty"`    // Per kB
+	RequireExpiry bool         `json:"requireexpiry,omitempty"` // Expiry set
+}
+
+// ledgerEntry records a transaction that was signed for a contract. It is
+// used to enforce the rolling spending limit.
+type ledgerEntry struct {
+	TxID      string `json:"txid"`
+	Amount    int64  `json:"amount"` // Atoms
+	Timestamp int64  `json:"timestamp"`
+}
+
+// spend is the policy relevant summary of a transaction.
+type spend struct {
+	txID         string
+	contracts    []string       // Policy names of the spent contracts
+	destinations []string       // Addresses paid, excluding change
+	amount       dcrutil.Amount // Paid to destinations
+	fee          dcrutil.Amount
+	size         int // Estimated fully signed size
+	memo         bool
+	expiry       uint32
+}
+
+// txSpend returns the policy relevant summary of a multisig transaction.
+// Contracts are named after their address unless names provides another
+// policy name for it. Outputs that pay back to one of the spent contracts are
+// considered change. The contracts are determined from the redeem scripts and
+// the fee from the ValueIn of the inputs, both of which must have been
+// verified against the previous outputs by the caller.
+func txSpend(tx *wire.MsgTx, params *chaincfg.Params, names map[string]string) (*spend, error) {
+	policyName := func(address string) string {
+		if name, ok := names[address]; ok {
+			return name
+		}
+		return address
+	}
+
+	status, err := multisig.SigningStatus(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spend{
+		txID:   tx.TxHash().String(),
+		expiry: tx.Expiry,
+	}
+	contracts := make(map[string]struct{})
+	sizes := make([]int, 0, len(tx.TxIn))
+	var in int64
+	for k, txIn := range tx.TxIn {
+		in += txIn.ValueIn
+		sh, err := dcrutil.NewAddressScriptHash(status[k].RedeemScript,
+			params)
+		if err != nil {
+			return nil, err
+		}
+		name := policyName(sh.Address())
+		if _, ok := contracts[name]; !ok {
+			contracts[name] = struct{}{}
+			s.contracts = append(s.contracts, name)
+		}
+		size, err := multisig.SigScriptSize(status[k].RedeemScript)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Strings(s.contracts)
+
+	var out int64
+	for k, txOut := range tx.TxOut {
+		out += txOut.Value
+		if _, ok := multisig.TxMemo(txOut.PkScript); ok {
+			s.memo = true
+			continue
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.Version,
+			txOut.PkScript, params, false)
+		if err != nil || len(addrs) != 1 {
+			return nil, fmt.Errorf("output %v: non standard script",
+				k)
+		}
+		if _, ok := contracts[policyName(addrs[0].Address())]; ok {
+			continue
+		}
+		s.destinations = append(s.destinations, addrs[0].Address())
+		s.amount += dcrutil.Amount(txOut.Value)
+	}
+	s.fee = dcrutil.Amount(in - out)
+	s.size = txsizes.EstimateSerializeSize(sizes, tx.TxOut, 0)
+
+	return s, nil
+}
+
+// evaluate returns the policy violations of the provided spend. The amount
+// already spent within the policy window is passed in.
+func (p *policy) evaluate(s *spend, spent dcrutil.Amount) ([]string, error) {
+	var violations []string
+
+	if p.MaxAmount != 0 {
+		max := dcrutil.Amount(p.MaxAmount)
+		if s.amount > max {
+			violations = append(violations, fmt.Sprintf("amount "+
+				"%v exceeds maximum %v", s.amount, max))
+		}
+	}
+
+	if p.Limit != 0 {
+		limit := dcrutil.Amount(p.Limit)
+		if spent+s.amount > limit {
+			violations = append(violations, fmt.Sprintf("amount "+
+				"%v plus %v already spent exceeds limit %v "+
+				"per %v", s.amount, spent, limit, p.window()))
+		}
+	}
+
+	if len(p.Allowlist) != 0 {
+		allowed := make(map[string]struct{}, len(p.Allowlist))
+		for _, a := range p.Allowlist {
+			allowed[a] = struct{}{}
+		}
+		for _, d := range s.destinations {
+			if _, ok := allowed[d]; !ok {
+				violations = append(violations, fmt.Sprintf(
+					"destination %v not in allowlist", d))
+			}
+		}
+	}
+
+	if p.RequireMemo && !s.memo {
+		violations = append(violations, "memo required")
+	}
+
+	if p.MaxFeeRate != 0 {
+		max := dcrutil.Amount(p.MaxFeeRate)
+		rate := s.fee * 1000 / dcrutil.Amount(s.size)
+		if rate > max {
+			violations = append(violations, fmt.Sprintf("fee rate "+
+				"%v/kB exceeds maximum %v/kB", rate, max))
+		}
+	}
+
+	if p.RequireExpiry && s.expiry == wire.NoExpiryValue {
+		violations = append(violations, "expiry required")
+	}
+
+	return violations, nil
+}
+
+// window returns the duration of the rolling spending limit.
+func (p *policy) window() time.Duration {
+	if p.Window == "" {
+		return defaultPolicyWindow
+	}
+	d, err := time.ParseDuration(p.Window)
+	if err != nil {
+		return defaultPolicyWindow
+	}
+	return d
+}
+
+// validate verifies that the policy can be evaluated.
+func (p *policy) validate() error {
+	if p.Window != "" {
+		d, err := time.ParseDuration(p.Window)
+		if err != nil {
+			return fmt.Errorf("window: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("window must be positive: %v", d)
+		}
+	}
+	return nil
+}
+
+// policyFilename returns the policy file of the provided contract.
+func (c *client) policyFilename(address string) string {
+	return filepath.Join(c.cfg.policyDir, address+".json")
+}
+
+// ledgerFilename returns the spending ledger of the provided contract.
+func (c *client) ledgerFilename(address string) string {
+	return filepath.Join(c.cfg.policyDir, address+".ledger.json")
+}
+
+// loadPolicy returns the policy of the provided contract or nil if there is
+// none.
+func (c *client) loadPolicy(address string) (*policy, error) {
+	b, err := ioutil.ReadFile(c.policyFilename(address))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var p policy
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("policy %v: %v", address, err)
+	}
+	err = p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("policy %v: %v", address, err)
+	}
+	return &p, nil
+}
+
+// loadLedger returns the spending ledger of the provided contract.
+func (c *client) loadLedger(address string) ([]ledgerEntry, error) {
+	b, err := ioutil.ReadFile(c.ledgerFilename(address))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var l []ledgerEntry
+	err = json.Unmarshal(b, &l)
+	if err != nil {
+		return nil, fmt.Errorf("ledger %v: %v", address, err)
+	}
+	return l, nil
+}
+
+// spentSince returns the amount spent since the provided time, excluding the
+// provided transaction which may be signed more than once.
+func spentSince(l []ledgerEntry, since time.Time, txID string) dcrutil.Amount {
+	var spent dcrutil.Amount
+	for _, e := range l {
+		if e.TxID == txID || time.Unix(e.Timestamp, 0).Before(since) {
+			continue
+		}
+		spent += dcrutil.Amount(e.Amount)
+	}
+	return spent
+}
+
+// recordSpend adds the provided spend to the ledgers of all contracts that
+// have a policy.
+func (c *client) recordSpend(s *spend) error {
+	for _, address := range s.contracts {
+		p, err := c.loadPolicy(address)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			continue
+		}
+		l, err := c.loadLedger(address)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, e := range l {
+			if e.TxID == s.txID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		l = append(l, ledgerEntry{
+			TxID:      s.txID,
+			Amount:    int64(s.amount),
+			Timestamp: time.Now().Unix(),
+		})
+		b, err := json.MarshalIndent(l, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(c.ledgerFilename(address), b, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logOverride records a policy override.
+func (c *client) logOverride(s *spend, reason string, violations []string) error {
+	log.Warningf("Policy override %v: %v: %v", s.txID, reason, violations)
+
+	err := os.MkdirAll(c.cfg.policyDir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(c.cfg.policyDir,
+		policyOverrideLog), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(struct {
+		Timestamp  int64    `json:"timestamp"`
+		TxID       string   `json:"txid"`
+		Contracts  []string `json:"contracts"`
+		Reason     string   `json:"reason"`
+		Violations []string `json:"violations"`
+	}{
+		Timestamp:  time.Now().Unix(),
+		TxID:       s.txID,
+		Contracts:  s.contracts,
+		Reason:     reason,
+		Violations: violations,
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\n", entry)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// enforcePolicy evaluates the transaction against the policies of all
+// contracts it spends from. It returns an error with all violations unless
+// an override reason is provided, in which case the override is logged.
+// The redeem scripts and values of the inputs are verified against the
+// previous outputs first, a transaction whose previous outputs can not be
+// verified is never signed.
+func (c *client) enforcePolicy(ctx context.Context, tx *wire.MsgTx, override string) (*spend, error) {
+	// The redeem scripts in the transaction, which determine the
+	// contracts and therefore the policies, and the input values are
+	// provided by the proposer. Nothing is evaluated before they have
+	// been verified against the previous outputs.
+	prevOuts, err := c.ms.PrevOuts(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to verify previous outputs, "+
+			"refusing to sign: %v", err)
+	}
+	err = multisig.CheckPrevOuts(tx, prevOuts, c.cfg.params)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to sign: %v", err)
+	}
+
+	s, err := txSpend(tx, c.cfg.params, c.policyNames)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]*policy, len(s.contracts))
+	for _, address := range s.contracts {
+		p, err := c.loadPolicy(address)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			policies[address] = p
+		}
+	}
+	if len(policies) == 0 {
+		return s, nil
+	}
+
+	var violations []string
+	for _, address := range s.contracts {
+		p, ok := policies[address]
+		if !ok {
+			continue
+		}
+		l, err := c.loadLedger(address)
+		if err != nil {
+			return nil, err
+		}
+		spent := spentSince(l, time.Now().Add(-p.window()), s.txID)
+		v, err := p.evaluate(s, spent)
+		if err != nil {
+			return nil, fmt.Errorf("policy %v: %v", address, err)
+		}
+		for k := range v {
+			violations = append(violations,
+				fmt.Sprintf("%v: %v", address, v[k]))
+		}
+	}
+	if len(violations) == 0 {
+		return s, nil
+	}
+
+	if override == "" {
+		return nil, fmt.Errorf("policy violation, refusing to sign:"+
+			"\n  %v", strings.Join(violations, "\n  "))
+	}
+	for _, v := range violations {
+		fmt.Fprintf(os.Stderr, "WARNING: policy override: %v\n", v)
+	}
+	err = c.logOverride(s, override, violations)
+	if err != nil {
+		return nil, fmt.Errorf("log override: %v", err)
+	}
+	err = c.audit(auditOverride, struct {
+		TxID       string   `json:"txid"`
+		Contracts  []string `json:"contracts"`
+		Reason     string   `json:"reason"`
+		Violations []string `json:"violations"`
+	}{
+		TxID:       s.txID,
+		Contracts:  s.contracts,
+		Reason:     override,
+		Violations: violations,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
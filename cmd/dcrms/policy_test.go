@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	payee      = "TsoD8TRGwJdQ3DrxFaV537ffDHnoW3bfD5B"
+	otherPayee = "TsfDLrRkk9ciUuwfp2b8PawwnukYD7yAjGd"
+)
+
+// policyTx returns an unsigned escrow spend that pays amount to payee, sends
+// change back to the escrow and optionally carries a memo.
+func policyTx(t *testing.T, amount int64, withMemo bool) *wire.MsgTx {
+	t.Helper()
+	params := chaincfg.TestNet3Params()
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, 10e8,
+		mustDecodeHex(t, escrowScript)))
+	for _, address := range []string{payee, escrowAddr} {
+		addr, err := dcrutil.DecodeAddress(address, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := amount
+		if address == escrowAddr {
+			value = 10e8 - amount - 1e5
+		}
+		tx.AddTxOut(wire.NewTxOut(value, script))
+	}
+	if withMemo {
+		memo, err := multisig.MemoScript([]byte("INV-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.AddTxOut(wire.NewTxOut(0, memo))
+	}
+	return tx
+}
+
+func TestTxSpend(t *testing.T) {
+	s, err := txSpend(policyTx(t, 2e8, true), chaincfg.TestNet3Params(),
+		nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.contracts) != 1 || s.contracts[0] != escrowAddr {
+		t.Fatalf("got contracts %v", s.contracts)
+	}
+	if len(s.destinations) != 1 || s.destinations[0] != payee {
+		t.Fatalf("got destinations %v", s.destinations)
+	}
+	if s.amount != 2e8 {
+		t.Fatalf("got amount %v", s.amount)
+	}
+	if s.fee != 1e5 {
+		t.Fatalf("got fee %v", s.fee)
+	}
+	if !s.memo {
+		t.Fatal("memo not detected")
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	withMemo, err := txSpend(policyTx(t, 2e8, true), params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noMemo, err := txSpend(policyTx(t, 2e8, false), params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring := *withMemo
+	expiring.expiry = 1000
+
+	tests := []struct {
+		name   string
+		policy policy
+		spend  *spend
+		spent  dcrutil.Amount
+		want   string
+	}{
+		{"empty", policy{}, noMemo, 0, ""},
+		{"maxamount ok", policy{MaxAmount: 2e8}, withMemo, 0, ""},
+		{"maxamount", policy{MaxAmount: 1.5e8}, withMemo, 0,
+			"exceeds maximum"},
+		{"limit ok", policy{Limit: 5e8}, withMemo, 3e8, ""},
+		{"limit", policy{Limit: 5e8}, withMemo, 4e8, "exceeds limit"},
+		{"allowlist ok", policy{Allowlist: []string{payee}}, withMemo,
+			0, ""},
+		{"allowlist", policy{Allowlist: []string{otherPayee}},
+			withMemo, 0, "not in allowlist"},
+		{"memo ok", policy{RequireMemo: true}, withMemo, 0, ""},
+		{"memo", policy{RequireMemo: true}, noMemo, 0,
+			"memo required"},
+		{"feerate ok", policy{MaxFeeRate: 1e6}, withMemo, 0, ""},
+		{"feerate", policy{MaxFeeRate: 1e4}, withMemo, 0,
+			"fee rate"},
+		{"expiry ok", policy{RequireExpiry: true}, &expiring, 0, ""},
+		{"expiry", policy{RequireExpiry: true}, withMemo, 0,
+			"expiry required"},
+	}
+	for _, tt := range tests {
+		v, err := tt.policy.evaluate(tt.spend, tt.spent)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if tt.want == "" {
+			if len(v) != 0 {
+				t.Fatalf("%v: unexpected violations %v",
+					tt.name, v)
+			}
+			continue
+		}
+		if len(v) != 1 || !strings.Contains(v[0], tt.want) {
+			t.Fatalf("%v: got %v, want %q", tt.name, v, tt.want)
+		}
+	}
+}
+
+func TestPolicyAmounts(t *testing.T) {
+	var p policy
+	err := json.Unmarshal([]byte(`{"maxamount": 0.3, "limit": "1500 mDCR",
+		"maxfeerate": 0.0001}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxAmount != 3e7 || p.Limit != 15e7 || p.MaxFeeRate != 1e4 {
+		t.Fatalf("got %+v", p)
+	}
+
+	// 0.1 + 0.2 DCR is exactly 0.3 DCR in atoms.
+	s := &spend{amount: 2e7, size: 250}
+	v, err := p.evaluate(s, 0)
+	if err != nil || len(v) != 0 {
+		t.Fatalf("got %v %v", v, err)
+	}
+	p = policy{Limit: 3e7}
+	v, err = p.evaluate(s, 1e7)
+	if err != nil || len(v) != 0 {
+		t.Fatalf("got %v %v", v, err)
+	}
+	v, err = p.evaluate(s, 1e7+1)
+	if err != nil || len(v) != 1 {
+		t.Fatalf("got %v %v", v, err)
+	}
+
+	for _, invalid := range []string{`-1`, `1e-3`, `0.000000001`,
+		`"1 BTC"`, `true`} {
+		err := json.Unmarshal([]byte(`{"maxamount": `+invalid+`}`), &p)
+		if err == nil {
+			t.Fatalf("%v: no error", invalid)
+		}
+	}
+}
+
+func TestSpentSince(t *testing.T) {
+	now := time.Now()
+	l := []ledgerEntry{
+		{TxID: "a", Amount: 1e8, Timestamp: now.Add(-48 * time.Hour).Unix()},
+		{TxID: "b", Amount: 2e8, Timestamp: now.Add(-time.Hour).Unix()},
+		{TxID: "c", Amount: 4e8, Timestamp: now.Unix()},
+	}
+	since := now.Add(-24 * time.Hour)
+	if got := spentSince(l, since, ""); got != 6e8 {
+		t.Fatalf("got %v", got)
+	}
+	// Signing the same transaction again must not count twice.
+	if got := spentSince(l, since, "c"); got != 2e8 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// TestPolicyForgedValueIn verifies that the fee rate is evaluated with the
+// values of the previous outputs and not with the values claimed by the
+// transaction.
+func TestPolicyForgedValueIn(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice := newClient(configs["alice"])
+	bob := newClient(configs["bob"])
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	escrow := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")[0]
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=5")
+	err := os.MkdirAll(alice.cfg.policyDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(alice.policyFilename(escrow),
+		[]byte(`{"maxfeerate": 0.001}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the change so that 4 DCR go to the miners and claim an input
+	// value that makes the fee look small.
+	txS := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=1"))
+	tx := mustDecodeTx(t, txS)
+	tx.TxOut = tx.TxOut[:1]
+	txS, err = multisig.EncodeTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := forgeValueIn(t, txS, 1e8+1e5)
+	s, err := txSpend(mustDecodeTx(t, forged), alice.cfg.params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.fee != 1e5 {
+		t.Fatalf("got fee %v", s.fee)
+	}
+
+	sign := func(args ...string) error {
+		_, err := capture(t, func() error {
+			return alice.run(context.Background(),
+				append([]string{"signmultisigtx"}, args...))
+		})
+		return err
+	}
+	for _, args := range [][]string{
+		{"tx=" + forged},
+		{"tx=" + forged, "override=looks fine"},
+	} {
+		err = sign(args...)
+		if err == nil || !strings.Contains(err.Error(), "refusing to "+
+			"sign: input 0") {
+			t.Fatalf("%v: got %v", args, err)
+		}
+	}
+
+	// The real fee violates the policy.
+	err = sign("tx=" + txS)
+	if err == nil || !strings.Contains(err.Error(), "fee rate") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func mustDecodeTx(t *testing.T, txS string) *wire.MsgTx {
+	t.Helper()
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// TestPolicySpoofedRedeemScript verifies that the policy of the contract an
+// input really spends from applies, even when the transaction carries the
+// redeem script of another contract.
+func TestPolicySpoofedRedeemScript(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice := newClient(configs["alice"])
+	bob := newClient(configs["bob"])
+	var keys []string
+	for _, c := range []*client{alice, bob} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	lines := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")
+	escrow := lines[0]
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=5")
+	err := os.MkdirAll(alice.cfg.policyDir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(alice.policyFilename(escrow),
+		[]byte(`{"maxamount": 1}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 1 of 2 contract of the same keys has no policy.
+	lines = strings.Split(run(t, alice, "createmultisigaddress", "n=1",
+		"contract=escrow", "keys="+strings.Join(keys, ",")), "\n")
+	spoofed, err := hex.DecodeString(lines[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txS := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=2"))
+	tx := mustDecodeTx(t, txS)
+	for _, txIn := range tx.TxIn {
+		txIn.SignatureScript = spoofed
+	}
+	forged, err := multisig.EncodeTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{txS, forged} {
+		_, err = capture(t, func() error {
+			return alice.run(context.Background(),
+				[]string{"signmultisigtx", "tx=" + s})
+		})
+		if err == nil || !strings.Contains(err.Error(),
+			"refusing to sign") {
+			t.Fatalf("got %v", err)
+		}
+	}
+	expect(t, err.Error(), "redeem script does not match")
+
+	// Previous outputs that can not be verified are never signed, with
+	// or without a policy.
+	os.Remove(alice.policyFilename(escrow))
+	tx = mustDecodeTx(t, txS)
+	tx.TxIn[0].PreviousOutPoint.Hash[0] ^= 1
+	unknown, err := multisig.EncodeTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = capture(t, func() error {
+		return alice.run(context.Background(),
+			[]string{"signmultisigtx", "tx=" + unknown})
+	})
+	if err == nil || !strings.Contains(err.Error(),
+		"unable to verify previous outputs") {
+		t.Fatalf("got %v", err)
+	}
+}
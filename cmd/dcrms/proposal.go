@@ -0,0 +1,620 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	// Proposal states.
+	proposalOpen      = "open"
+	proposalSigned    = "signed"
+	proposalBroadcast = "broadcast"
+	proposalRejected  = "rejected"
+
+	// proposalLockTimeout is how long to wait for another signer to
+	// release a proposal.
+	proposalLockTimeout = 10 * time.Second
+)
+
+// proposalFile is a transaction proposal in the shared proposal directory.
+// Signatures are not written to the proposal file but to approval files next
+// to it. When a proposal is read its approvals are merged into Tx and the
+// proposal is signed once Tx is complete.
+type proposalFile struct {
+	ID          string             `json:"id"` // Txid
+	State       string             `json:"state"`
+	Description string             `json:"description,omitempty"`
+	Tx          string             `json:"tx"`
+	Created     int64              `json:"created"`
+	Updated     int64              `json:"updated"`
+	Approvals   []proposalApproval `json:"-"` // From approval files
+	Rejection   string             `json:"rejection,omitempty"`
+	Broadcast   string             `json:"broadcast,omitempty"` // Txid
+}
+
+// proposalApproval records the keys that signed during a single approval and
+// the transaction with their signatures. Every approval is written to its own
+// file, <txid>-<hash>.sig, so that signers that approve at the same time on
+// different computers never write the same file of a synced folder.
+type proposalApproval struct {
+	Timestamp int64         `json:"timestamp"`
+	Signers   []auditSigner `json:"signers"`
+	Tx        string        `json:"tx"`
+}
+
+// proposalDir returns the configured proposal directory.
+func (c *client) proposalDir() (string, error) {
+	if c.cfg.ProposalDir == "" {
+		return "", fmt.Errorf("proposal directory not set, use " +
+			"-proposaldir")
+	}
+	return c.cfg.ProposalDir, nil
+}
+
+// proposalFilename returns the filename of the proposal with the provided id.
+func proposalFilename(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+// lockProposal takes the lock of the proposal with the provided id. The
+// returned function releases the lock.
+func lockProposal(dir, id string, timeout time.Duration) (func(), error) {
+	filename := filepath.Join(dir, id+".lock")
+	unlock, err := lockFile(filename, timeout)
+	if err == errLocked {
+		return nil, fmt.Errorf("proposal %v is locked by another "+
+			"signer, remove %v if the lock is stale", id, filename)
+	}
+	return unlock, err
+}
+
+// approvalFilename returns the filename of the approval that produced the
+// provided transaction.
+func approvalFilename(dir, id, tx string) string {
+	h := sha256.Sum256([]byte(tx))
+	return filepath.Join(dir, id+"-"+hex.EncodeToString(h[:8])+".sig")
+}
+
+// writeApproval writes the provided approval of the proposal with the
+// provided id.
+func writeApproval(dir, id string, a *proposalApproval) error {
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(approvalFilename(dir, id, a.Tx),
+		append(b, '\n'))
+}
+
+// readApprovals returns the approvals of the proposal with the provided id,
+// oldest first. Unreadable approvals, e.g. files that are still being
+// synced, are skipped.
+func readApprovals(dir, id string) ([]proposalApproval, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, id+"-*.sig"))
+	if err != nil {
+		return nil, err
+	}
+	approvals := make([]proposalApproval, 0, len(matches))
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			log.Warningf("approval %v: %v", m, err)
+			continue
+		}
+		var a proposalApproval
+		err = json.Unmarshal(b, &a)
+		if err != nil {
+			log.Warningf("approval %v: %v", m, err)
+			continue
+		}
+		approvals = append(approvals, a)
+	}
+	sort.SliceStable(approvals, func(i, j int) bool {
+		return approvals[i].Timestamp < approvals[j].Timestamp
+	})
+	return approvals, nil
+}
+
+// signingComplete returns true if every input of tx has enough signatures.
+func signingComplete(tx *wire.MsgTx) (bool, error) {
+	status, err := multisig.SigningStatus(tx)
+	if err != nil {
+		return false, err
+	}
+	for _, s := range status {
+		if s.Signatures < s.Required {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// mergeApprovals merges the signatures of all approvals into the proposal.
+// An open proposal becomes signed once every input has enough signatures.
+func mergeApprovals(dir string, p *proposalFile) error {
+	approvals, err := readApprovals(dir, p.ID)
+	if err != nil {
+		return err
+	}
+	if len(approvals) == 0 {
+		return nil
+	}
+	tx, err := multisig.DecodeTx(p.Tx)
+	if err != nil {
+		return fmt.Errorf("proposal %v: %v", p.ID, err)
+	}
+	for _, a := range approvals {
+		signed, err := multisig.DecodeTx(a.Tx)
+		if err == nil {
+			err = multisig.MergeSignatures(tx, signed)
+		}
+		if err != nil {
+			log.Warningf("proposal %v: approval: %v", p.ID, err)
+			continue
+		}
+		p.Approvals = append(p.Approvals, a)
+	}
+	p.Tx, err = multisig.EncodeTx(tx)
+	if err != nil {
+		return err
+	}
+	if p.State != proposalOpen {
+		return nil
+	}
+	complete, err := signingComplete(tx)
+	if err != nil {
+		return fmt.Errorf("proposal %v: %v", p.ID, err)
+	}
+	if complete {
+		p.State = proposalSigned
+	}
+	return nil
+}
+
+// readProposal reads the proposal with the provided id and merges its
+// approvals.
+func readProposal(dir, id string) (*proposalFile, error) {
+	b, err := ioutil.ReadFile(proposalFilename(dir, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("proposal not found: %v", id)
+		}
+		return nil, err
+	}
+	var p proposalFile
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("proposal %v: %v", id, err)
+	}
+	if p.ID != id {
+		return nil, fmt.Errorf("proposal %v: invalid id %v", id, p.ID)
+	}
+	// The id is all signers compare, the transaction must match it.
+	tx, err := multisig.DecodeTx(p.Tx)
+	if err != nil {
+		return nil, fmt.Errorf("proposal %v: %v", id, err)
+	}
+	if tx.TxHash().String() != id {
+		return nil, fmt.Errorf("proposal %v: transaction %v does not "+
+			"match the id", id, tx.TxHash())
+	}
+	err = mergeApprovals(dir, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// writeProposal writes the provided proposal. The file is replaced atomically
+// so that readers never see a partial proposal. The caller must hold the
+// proposal lock.
+func writeProposal(dir string, p *proposalFile) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(proposalFilename(dir, p.ID), append(b, '\n'))
+}
+
+// updateProposal locks, reads, updates and writes back the proposal with the
+// provided id. It is used for state changes only, signatures are added with
+// writeApproval.
+func updateProposal(dir, id string, f func(*proposalFile) error) (*proposalFile, error) {
+	unlock, err := lockProposal(dir, id, proposalLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	p, err := readProposal(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	err = f(p)
+	if err != nil {
+		return nil, err
+	}
+	p.Updated = time.Now().Unix()
+	err = writeProposal(dir, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// listProposals returns all proposals in the provided directory, oldest
+// first.
+func listProposals(dir string) ([]*proposalFile, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	proposals := make([]*proposalFile, 0, len(matches))
+	for _, m := range matches {
+		id := strings.TrimSuffix(filepath.Base(m), ".json")
+		p, err := readProposal(dir, id)
+		if err != nil {
+			log.Warningf("%v", err)
+			continue
+		}
+		proposals = append(proposals, p)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].Created < proposals[j].Created
+	})
+	return proposals, nil
+}
+
+// missingSigners returns the hex encoded public keys that have not yet signed
+// an input that still requires signatures.
+func missingSigners(tx *wire.MsgTx) ([]string, error) {
+	var (
+		missing []string
+		seen    = make(map[string]struct{})
+	)
+	status, err := multisig.SigningStatus(tx)
+	if err != nil {
+		return nil, err
+	}
+	for k := range tx.TxIn {
+		if status[k].Signatures >= status[k].Required {
+			continue
+		}
+		_, pubKeys, sigs, err := multisig.InputSignatures(tx, k)
+		if err != nil {
+			return nil, err
+		}
+		for _, pk := range pubKeys {
+			key := hex.EncodeToString(pk)
+			if _, ok := sigs[key]; ok {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
+
+// walletOwnsKey returns true if the wallet controls the hex encoded public
+// key.
+func (c *client) walletOwnsKey(ctx context.Context, pubKey string) (bool, error) {
+	pk, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return false, err
+	}
+	addr, err := dcrutil.NewAddressPubKeyHash(dcrutil.Hash160(pk),
+		c.cfg.params, dcrec.STEcdsaSecp256k1)
+	if err != nil {
+		return false, err
+	}
+	var va jt.ValidateAddressResult
+	err = c.walletCall(ctx, "validateaddress", &va, addr.Address())
+	if err != nil {
+		return false, err
+	}
+	return va.IsValid && va.IsMine, nil
+}
+
+// printProposalFile prints a proposal and the inputs with their signing
+// status, the outputs and the fee of its transaction.
+func (c *client) printProposalFile(ctx context.Context, p *proposalFile) error {
+	tx, err := multisig.DecodeTx(p.Tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Proposal     : %v\n", p.ID)
+	fmt.Printf("State        : %v\n", p.State)
+	if p.Description != "" {
+		fmt.Printf("Description  : %v\n", p.Description)
+	}
+	fmt.Printf("Created      : %v\n", time.Unix(p.Created, 0))
+	err = c.printTxSummary(ctx, os.Stdout, tx)
+	if err != nil {
+		return err
+	}
+	if p.Rejection != "" {
+		fmt.Printf("Rejection    : %v\n", p.Rejection)
+	}
+	if p.Broadcast != "" {
+		fmt.Printf("Broadcast    : %v\n", p.Broadcast)
+	}
+	return nil
+}
+
+func (c *client) propose(ctx context.Context, a map[string]string) error {
+	dir, err := c.proposalDir()
+	if err != nil {
+		return err
+	}
+	txS, err := ArgAsString("tx", a)
+	if err != nil {
+		return err
+	}
+	description, _ := ArgAsString("description", a)
+
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		return err
+	}
+	if len(tx.TxIn) == 0 {
+		return fmt.Errorf("transaction has no inputs")
+	}
+	complete, err := signingComplete(tx)
+	if err != nil {
+		return fmt.Errorf("not a multisig spend: %v", err)
+	}
+	state := proposalOpen
+	if complete {
+		state = proposalSigned
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	id := tx.TxHash().String()
+	unlock, err := lockProposal(dir, id, proposalLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if fileExists(proposalFilename(dir, id)) {
+		return fmt.Errorf("proposal already exists: %v", id)
+	}
+	now := time.Now().Unix()
+	p := &proposalFile{
+		ID:          id,
+		State:       state,
+		Description: description,
+		Tx:          txS,
+		Created:     now,
+		Updated:     now,
+	}
+	err = writeProposal(dir, p)
+	if err != nil {
+		return err
+	}
+
+	err = c.printProposalFile(ctx, p)
+	if err != nil {
+		return err
+	}
+	return c.audit(auditProposal, struct {
+		TxID    string        `json:"txid"`
+		Expiry  uint32        `json:"expiry"`
+		Outputs []auditOutput `json:"outputs"`
+	}{
+		TxID:    id,
+		Expiry:  tx.Expiry,
+		Outputs: auditOutputs(tx, c.cfg.params),
+	})
+}
+
+func (c *client) pending(ctx context.Context, a map[string]string) error {
+	dir, err := c.proposalDir()
+	if err != nil {
+		return err
+	}
+	proposals, err := listProposals(dir)
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool)
+	found := false
+	for _, p := range proposals {
+		if p.State != proposalOpen {
+			continue
+		}
+		tx, err := multisig.DecodeTx(p.Tx)
+		if err != nil {
+			log.Warningf("proposal %v: %v", p.ID, err)
+			continue
+		}
+		missing, err := missingSigners(tx)
+		if err != nil {
+			log.Warningf("proposal %v: %v", p.ID, err)
+			continue
+		}
+		var ours []string
+		for _, pk := range missing {
+			mine, ok := owned[pk]
+			if !ok {
+				mine, err = c.walletOwnsKey(ctx, pk)
+				if err != nil {
+					return err
+				}
+				owned[pk] = mine
+			}
+			if mine {
+				ours = append(ours, pk)
+			}
+		}
+		if len(ours) == 0 {
+			continue
+		}
+
+		if found {
+			fmt.Printf("\n")
+		}
+		found = true
+		err = c.printProposalFile(ctx, p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Our keys     : %v\n",
+			pubKeyAddresses(ours, c.cfg.params))
+	}
+	if !found {
+		fmt.Printf("No proposals require our signature\n")
+	}
+	return nil
+}
+
+func (c *client) approve(ctx context.Context, a map[string]string) error {
+	dir, err := c.proposalDir()
+	if err != nil {
+		return err
+	}
+	id, err := ArgAsString("id", a)
+	if err != nil {
+		return err
+	}
+
+	// The approval is written to its own file, no lock is needed.
+	p, err := readProposal(dir, id)
+	if err != nil {
+		return err
+	}
+	if p.State != proposalOpen {
+		return fmt.Errorf("proposal %v is %v", id, p.State)
+	}
+	err = c.printProposalFile(ctx, p)
+	if err != nil {
+		return err
+	}
+	srtr, signers, err := c.signTx(ctx, os.Stdout, p.Tx, a)
+	if err != nil {
+		return err
+	}
+	if len(signers) == 0 {
+		return fmt.Errorf("wallet did not add any signatures")
+	}
+	err = writeApproval(dir, id, &proposalApproval{
+		Timestamp: time.Now().Unix(),
+		Signers:   signers,
+		Tx:        srtr.Hex,
+	})
+	if err != nil {
+		return err
+	}
+
+	p, err = readProposal(dir, id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("State        : %v\n", p.State)
+	return nil
+}
+
+func (c *client) reject(ctx context.Context, a map[string]string) error {
+	dir, err := c.proposalDir()
+	if err != nil {
+		return err
+	}
+	id, err := ArgAsString("id", a)
+	if err != nil {
+		return err
+	}
+	reason, err := ArgAsString("reason", a)
+	if err != nil || reason == "" {
+		return fmt.Errorf("reject requires a reason")
+	}
+
+	p, err := updateProposal(dir, id, func(p *proposalFile) error {
+		switch p.State {
+		case proposalOpen, proposalSigned:
+		default:
+			return fmt.Errorf("proposal %v is %v", id, p.State)
+		}
+		p.State = proposalRejected
+		p.Rejection = reason
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = c.printProposalFile(ctx, p)
+	if err != nil {
+		return err
+	}
+	return c.audit(auditRejection, struct {
+		TxID   string `json:"txid"`
+		Reason string `json:"reason"`
+	}{
+		TxID:   id,
+		Reason: reason,
+	})
+}
+
+// broadcastProposal broadcasts a fully signed proposal and marks it as
+// broadcast.
+func (c *client) broadcastProposal(ctx context.Context, id string) error {
+	dir, err := c.proposalDir()
+	if err != nil {
+		return err
+	}
+
+	var (
+		txHash string
+		tx     *wire.MsgTx
+	)
+	_, err = updateProposal(dir, id, func(p *proposalFile) error {
+		if p.State != proposalSigned {
+			return fmt.Errorf("proposal %v is %v", id, p.State)
+		}
+		tx, err = multisig.DecodeTx(p.Tx)
+		if err != nil {
+			return err
+		}
+		txHash, err = c.ms.Broadcast(ctx, tx)
+		if err != nil {
+			return err
+		}
+		p.State = proposalBroadcast
+		p.Broadcast = txHash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v\n", txHash)
+
+	return c.audit(auditBroadcast, struct {
+		TxID    string        `json:"txid"`
+		Outputs []auditOutput `json:"outputs"`
+	}{
+		TxID:    txHash,
+		Outputs: auditOutputs(tx, c.cfg.params),
+	})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+func TestLockProposal(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockProposal(dir, "a", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lockProposal(dir, "a", 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("got %v", err)
+	}
+	// Other proposals are not affected.
+	unlockB, err := lockProposal(dir, "b", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockB()
+
+	// Waiters get the lock once it is released.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		unlock()
+	}()
+	unlockA, err := lockProposal(dir, "a", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockA()
+}
+
+// copyDir copies the files of src to dst, as a folder sync would.
+func copyDir(t *testing.T, dst, src string) {
+	t.Helper()
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join(src, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dst, f.Name()), b, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestProposalSyncedApprovals approves a proposal on two computers that share
+// a synced folder before either approval was synced.
+func TestProposalSyncedApprovals(t *testing.T) {
+	alice, bob := t.TempDir(), t.TempDir()
+	keys := testKeys(3)
+	tx, redeemScript := testMultisigTx(t, keys)
+	p := &proposalFile{
+		ID:    tx.TxHash().String(),
+		State: proposalOpen,
+		Tx:    txHex(t, tx),
+	}
+	err := writeProposal(alice, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyDir(t, bob, alice)
+
+	for k, dir := range []string{alice, bob} {
+		err := writeApproval(dir, p.ID, &proposalApproval{
+			Timestamp: int64(k),
+			Tx: txHex(t, partialSign(t, tx, redeemScript,
+				keys[k])),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := readProposal(dir, p.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.State != proposalOpen || len(p.Approvals) != 1 {
+			t.Fatalf("got %v %v", p.State, len(p.Approvals))
+		}
+	}
+	copyDir(t, alice, bob)
+	copyDir(t, bob, alice)
+
+	for _, dir := range []string{alice, bob} {
+		p, err := readProposal(dir, p.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.State != proposalSigned || len(p.Approvals) != 2 {
+			t.Fatalf("got %v %v", p.State, len(p.Approvals))
+		}
+		missing, err := missingSigners(mustDecodeTx(t, p.Tx))
+		if err != nil || len(missing) != 0 {
+			t.Fatalf("got %v %v", missing, err)
+		}
+	}
+
+	proposals, err := listProposals(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proposals) != 1 || proposals[0].ID != p.ID {
+		t.Fatalf("got %v", proposals)
+	}
+	_, err = readProposal(alice, "missing")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("got %v", err)
+	}
+
+	// A proposal file that carries another transaction under the id.
+	other := tx.Copy()
+	other.TxOut[0].Value--
+	err = writeProposal(alice, &proposalFile{
+		ID:    p.ID,
+		State: proposalOpen,
+		Tx:    txHex(t, other),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readProposal(alice, p.ID)
+	if err == nil || !strings.Contains(err.Error(), "does not match "+
+		"the id") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// TestProposalRacingApprovals lets two cosigners approve the same proposal at
+// the same time.
+func TestProposalRacingApprovals(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "carol")
+	proposalDir := t.TempDir()
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		cfg.ProposalDir = proposalDir
+		clients[name] = newClient(cfg)
+	}
+	alice, bob, carol := clients["alice"], clients["bob"], clients["carol"]
+
+	var keys []string
+	for _, c := range []*client{alice, bob, carol} {
+		keys = append(keys, lastLine(run(t, c, "getnewkey",
+			"contract=escrow")))
+	}
+	var escrow string
+	for _, c := range []*client{alice, bob, carol} {
+		escrow = strings.Split(run(t, c, "createmultisigaddress", "n=2",
+			"contract=escrow", "keys="+strings.Join(keys, ",")),
+			"\n")[0]
+	}
+	run(t, alice, "sendtomultisig", "address="+escrow, "amount=1")
+	tx := lastLine(run(t, alice, "createmultisigtx", "address="+escrow,
+		"to="+payee, "amount=0.5"))
+	id := field(t, run(t, alice, "propose", "tx="+tx), "Proposal")
+
+	_, err := capture(t, func() error {
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for _, c := range []*client{bob, carol} {
+			wg.Add(1)
+			go func(c *client) {
+				defer wg.Done()
+				errs <- c.approve(context.Background(),
+					map[string]string{"id": id})
+			}(c)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := readProposal(proposalDir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State != proposalSigned || len(p.Approvals) != 2 {
+		t.Fatalf("got %v %v", p.State, len(p.Approvals))
+	}
+	run(t, alice, "broadcastmultisigtx", "id="+id)
+	expect(t, run(t, alice, "getmultisigbalance", "address="+escrow),
+		"0.4")
+}
+
+func TestMissingSigners(t *testing.T) {
+	keys := testKeys(3)
+	tx, redeemScript := testMultisigTx(t, keys)
+	pubKey := func(i int) string {
+		pk := secp256k1.PrivKeyFromBytes(keys[i]).PubKey()
+		return hex.EncodeToString(pk.SerializeCompressed())
+	}
+
+	missing, err := missingSigners(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 3 {
+		t.Fatalf("got %v", missing)
+	}
+
+	signed := partialSign(t, tx, redeemScript, keys[1])
+	missing, err = missingSigners(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 2 || missing[0] != pubKey(0) ||
+		missing[1] != pubKey(2) {
+		t.Fatalf("got %v", missing)
+	}
+
+	err = multisig.MergeSignatures(signed, partialSign(t, tx, redeemScript,
+		keys[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err = missingSigners(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Fatalf("got %v", missing)
+	}
+}
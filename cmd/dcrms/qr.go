@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/makiuchi-d/gozxing"
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/skip2/go-qrcode"
+)
+
+// Payloads that do not fit in a single QR code are split in frames of the
+// form dcrms:<part>/<total>:<checksum>:<data>. The checksum covers the whole
+// payload and ties the frames of a payload together. Payloads that fit are
+// encoded as is so that any QR reader can scan them.
+const (
+	qrFramePrefix = "dcrms:"
+	qrFrameSize   = 400 // Maximum payload bytes per frame
+	qrImageSize   = 512 // Pixels of PNG and GIF codes
+	qrFrameDelay  = 80  // Hundredths of a second per animated GIF frame
+
+	// qrMaxFrames is the number of frames of the hex encoding of the
+	// largest standard transaction, 100000 bytes. It bounds the frame
+	// count read from untrusted codes.
+	qrMaxFrames = (2*100000 + qrFrameSize - 1) / qrFrameSize
+)
+
+// qrChecksum returns the checksum of a multi-part payload.
+func qrChecksum(payload string) string {
+	return hex.EncodeToString(chainhash.HashB([]byte(payload))[:4])
+}
+
+// qrFrames splits the provided payload in frames.
+func qrFrames(payload string) []string {
+	if len(payload) <= qrFrameSize && !strings.HasPrefix(payload,
+		qrFramePrefix) {
+		return []string{payload}
+	}
+	total := (len(payload) + qrFrameSize - 1) / qrFrameSize
+	checksum := qrChecksum(payload)
+	frames := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * qrFrameSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		frames = append(frames, fmt.Sprintf("%v%v/%v:%v:%v",
+			qrFramePrefix, i+1, total, checksum,
+			payload[i*qrFrameSize:end]))
+	}
+	return frames
+}
+
+// qrJoin reassembles the payload of the provided frames, in any order.
+// Frames may be repeated, as happens when an animation is captured.
+func qrJoin(frames []string) (string, error) {
+	if len(frames) == 0 {
+		return "", fmt.Errorf("no QR codes")
+	}
+	if len(frames) == 1 && !strings.HasPrefix(frames[0], qrFramePrefix) {
+		return frames[0], nil
+	}
+
+	var (
+		parts    []string
+		checksum string
+	)
+	for _, f := range frames {
+		s := strings.SplitN(strings.TrimPrefix(f, qrFramePrefix), ":", 3)
+		if !strings.HasPrefix(f, qrFramePrefix) || len(s) != 3 {
+			return "", fmt.Errorf("not a multi-part QR frame: %.20q",
+				f)
+		}
+		n := strings.SplitN(s[0], "/", 2)
+		if len(n) != 2 {
+			return "", fmt.Errorf("invalid QR frame number: %v", s[0])
+		}
+		part, err := strconv.Atoi(n[0])
+		if err != nil {
+			return "", fmt.Errorf("invalid QR frame number: %v", s[0])
+		}
+		total, err := strconv.Atoi(n[1])
+		if err != nil || total <= 0 || part <= 0 || part > total {
+			return "", fmt.Errorf("invalid QR frame number: %v", s[0])
+		}
+		if total > qrMaxFrames {
+			return "", fmt.Errorf("too many QR frames: %v, maximum "+
+				"is %v", total, qrMaxFrames)
+		}
+		if parts == nil {
+			parts = make([]string, total)
+			checksum = s[1]
+		}
+		if total != len(parts) || s[1] != checksum {
+			return "", fmt.Errorf("QR frame %v belongs to another "+
+				"payload", s[0])
+		}
+		if parts[part-1] != "" && parts[part-1] != s[2] {
+			return "", fmt.Errorf("conflicting QR frame %v", s[0])
+		}
+		parts[part-1] = s[2]
+	}
+	for k := range parts {
+		if parts[k] == "" {
+			return "", fmt.Errorf("missing QR frame %v/%v", k+1,
+				len(parts))
+		}
+	}
+	payload := strings.Join(parts, "")
+	if qrChecksum(payload) != checksum {
+		return "", fmt.Errorf("invalid QR payload checksum")
+	}
+	return payload, nil
+}
+
+// qrOutput renders the provided payload as QR codes as requested by the qr
+// flag: on the terminal, in numbered PNG files or in an animated GIF.
+func (c *client) qrOutput(payload string) error {
+	return qrOutputTo(c.cfg.QR, payload)
+}
+
+// qrTxOutput returns the QR output of the n-th, counting from 1, of several
+// transactions printed by one action. Files are numbered per transaction so
+// that they do not overwrite each other, the terminal is shared.
+func qrTxOutput(qr string, n int) string {
+	if qr == "" || qr == "terminal" {
+		return qr
+	}
+	ext := filepath.Ext(qr)
+	return fmt.Sprintf("%v-tx%v%v", strings.TrimSuffix(qr, ext), n, ext)
+}
+
+// qrOutputTo renders the provided payload as QR codes to qr, see qrOutput.
+func qrOutputTo(qr, payload string) error {
+	if qr == "" {
+		return nil
+	}
+	frames := qrFrames(payload)
+	codes := make([]*qrcode.QRCode, 0, len(frames))
+	for _, f := range frames {
+		q, err := qrcode.New(f, qrcode.Medium)
+		if err != nil {
+			return fmt.Errorf("qr: %v", err)
+		}
+		codes = append(codes, q)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(qr)); {
+	case qr == "terminal":
+		for k, q := range codes {
+			if len(codes) > 1 {
+				fmt.Printf("QR frame %v/%v\n", k+1, len(codes))
+			}
+			fmt.Printf("%v", q.ToSmallString(false))
+		}
+	case ext == ".png":
+		for k, q := range codes {
+			filename := qr
+			if len(codes) > 1 {
+				filename = fmt.Sprintf("%v-%v.png",
+					strings.TrimSuffix(qr,
+						filepath.Ext(qr)), k+1)
+			}
+			err := q.WriteFile(qrImageSize, filename)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "QR code written to %v\n", filename)
+		}
+	case ext == ".gif":
+		// Codes are rendered as two color paletted images
+		anim := &gif.GIF{}
+		for _, q := range codes {
+			frame, ok := q.Image(qrImageSize).(*image.Paletted)
+			if !ok {
+				return fmt.Errorf("qr: unexpected image type")
+			}
+			anim.Image = append(anim.Image, frame)
+			anim.Delay = append(anim.Delay, qrFrameDelay)
+		}
+		f, err := os.Create(qr)
+		if err != nil {
+			return err
+		}
+		err = gif.EncodeAll(f, anim)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "QR code written to %v\n", qr)
+	default:
+		return fmt.Errorf("invalid qr output: %v", qr)
+	}
+	return nil
+}
+
+// readQR returns the texts of the QR codes in the provided PNG file, or of
+// every frame of the provided GIF file.
+func readQR(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var images []image.Image
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gif":
+		anim, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		for _, img := range anim.Image {
+			images = append(images, img)
+		}
+	default:
+		img, err := png.Decode(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		images = append(images, img)
+	}
+
+	texts := make([]string, 0, len(images))
+	reader := zxqrcode.NewQRCodeReader()
+	for k, img := range images {
+		bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		res, err := reader.Decode(bmp, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%v: frame %v: no QR code found",
+				filename, k)
+		}
+		texts = append(texts, res.GetText())
+	}
+	return texts, nil
+}
+
+// qrInput is an action argument read from QR code images, as name=files.
+type qrInput struct {
+	name  string
+	files []string
+}
+
+// qrInputs is the value of the repeatable qrin flag.
+type qrInputs []qrInput
+
+// String returns the flag value.
+func (q *qrInputs) String() string {
+	s := make([]string, 0, len(*q))
+	for _, in := range *q {
+		s = append(s, in.name+"="+strings.Join(in.files, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+// Set adds an argument read from the comma separated image files.
+func (q *qrInputs) Set(v string) error {
+	a := strings.SplitN(v, "=", 2)
+	if len(a) != 2 || a[0] == "" || a[1] == "" {
+		return fmt.Errorf("use argument=file[,file...]")
+	}
+	*q = append(*q, qrInput{name: a[0], files: strings.Split(a[1], ",")})
+	return nil
+}
+
+// qrArgs returns the action arguments read from QR code images. Frames of a
+// multi-part payload may be spread over several files. Arguments read more
+// than once, e.g. the keys of several cosigners, are comma separated.
+func (c *client) qrArgs() ([]string, error) {
+	var (
+		names  []string
+		values = make(map[string][]string)
+	)
+	for _, in := range c.cfg.QRIn {
+		var frames []string
+		for _, filename := range in.files {
+			texts, err := readQR(filename)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, texts...)
+		}
+		payload, err := qrJoin(frames)
+		if err != nil {
+			return nil, fmt.Errorf("qrin %v: %v", in.name, err)
+		}
+		if _, ok := values[in.name]; !ok {
+			names = append(names, in.name)
+		}
+		values[in.name] = append(values[in.name], payload)
+	}
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, name+"="+strings.Join(values[name], ","))
+	}
+	return args, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQRFrames(t *testing.T) {
+	short := "03" + strings.Repeat("ab", 32)
+	if frames := qrFrames(short); len(frames) != 1 || frames[0] != short {
+		t.Fatalf("got %q", frames)
+	}
+
+	long := strings.Repeat("0123456789abcdef", 70)
+	frames := qrFrames(long)
+	if len(frames) != 3 || !strings.HasPrefix(frames[0], "dcrms:1/3:") {
+		t.Fatalf("got %q", frames)
+	}
+	other := qrFrames(strings.Repeat("fedcba9876543210", 70))
+	tests := []struct {
+		frames []string
+		err    string
+	}{
+		{[]string{short}, ""},
+		{frames, ""},
+		{[]string{frames[2], frames[0], frames[1], frames[0]}, ""},
+		{frames[:2], "missing QR frame 3/3"},
+		{[]string{frames[0], other[1], frames[2]}, "another payload"},
+		{[]string{frames[0], short}, "not a multi-part QR frame"},
+		{[]string{"dcrms:4/3:00000000:ab"}, "invalid QR frame number"},
+		{[]string{fmt.Sprintf("dcrms:1/%v:00000000:ab", qrMaxFrames)},
+			fmt.Sprintf("missing QR frame 2/%v", qrMaxFrames)},
+		{[]string{fmt.Sprintf("dcrms:1/%v:00000000:ab", qrMaxFrames+1)},
+			"too many QR frames"},
+		{[]string{"dcrms:1/2147483647:00000000:ab"}, "too many QR frames"},
+		{[]string{strings.Replace(frames[0], ":01", ":00", 1), frames[1],
+			frames[2]}, "invalid QR payload checksum"},
+	}
+	for k, tt := range tests {
+		payload, err := qrJoin(tt.frames)
+		if tt.err == "" {
+			if err != nil {
+				t.Fatalf("%v: %v", k, err)
+			}
+			if payload != short && payload != long {
+				t.Fatalf("%v: got %v", k, payload)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: got %v, want %v", k, err, tt.err)
+		}
+	}
+}
+
+// TestQR passes keys, the contract and transactions between cosigners as PNG
+// files and an animated GIF.
+func TestQR(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob", "carol")
+	clients := make(map[string]*client, len(configs))
+	for name, cfg := range configs {
+		clients[name] = newClient(cfg)
+	}
+	alice, bob, carol := clients["alice"], clients["bob"], clients["carol"]
+	dir := t.TempDir()
+	qr := func(c *client, out string, in ...string) {
+		c.cfg.QRIn = nil
+		if out != "" && out != "terminal" {
+			out = filepath.Join(dir, out)
+		}
+		c.cfg.QR = out
+		for _, v := range in {
+			err := c.cfg.QRIn.Set(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// Key statements and the contract.
+	var keys []string
+	for _, name := range []string{"alice", "bob", "carol"} {
+		qr(clients[name], name+".png")
+		run(t, clients[name], "getnewkey", "contract=escrow")
+		keys = append(keys, "keys="+file(name+".png"))
+	}
+	qr(alice, "contract.png", keys...)
+	lines := strings.Split(run(t, alice, "createmultisigaddress", "n=2",
+		"contract=escrow"), "\n")
+	qr(bob, "", "address="+file("contract.png"))
+	if field(t, run(t, bob, "multisiginfo"), "Address") != lines[0] {
+		t.Fatalf("got %v", lines)
+	}
+
+	// A transaction too large for a single code.
+	qr(alice, "")
+	for i := 0; i < 3; i++ {
+		run(t, alice, "sendtomultisig", "address="+lines[0], "amount=1")
+	}
+	qr(alice, "tx.png")
+	tx := lastLine(run(t, alice, "createmultisigtx", "address="+lines[0],
+		"to="+payee, "amount=2.5"))
+	if len(qrFrames(tx)) < 2 {
+		t.Fatalf("tx fits a single frame: %v", len(tx))
+	}
+	var frames []string
+	for k := range qrFrames(tx) {
+		frames = append(frames, file(fmt.Sprintf("tx-%v.png", k+1)))
+	}
+	qr(bob, "tx.gif", "tx="+strings.Join(frames[1:], ","))
+	_, err := capture(t, func() error {
+		return bob.run(context.Background(), []string{"signmultisigtx"})
+	})
+	if err == nil || !strings.Contains(err.Error(), "missing QR frame 1") {
+		t.Fatalf("got %v", err)
+	}
+	qr(bob, "tx.gif", "tx="+strings.Join(frames, ","))
+	expect(t, run(t, bob, "signmultisigtx"), "*NOT* COMPLETE")
+	qr(carol, "", "tx="+file("tx.gif"))
+	out := run(t, carol, "signmultisigtx")
+	expect(t, out, "SIGNING COMPLETE")
+
+	// Every consolidation transaction gets its own file.
+	qr(alice, "")
+	run(t, alice, "sendtomultisig", "address="+lines[0], "amount=1")
+	qr(alice, "cons.gif")
+	out = run(t, alice, "consolidatemultisig", "address="+lines[0],
+		"minvalue=2", "maxinputs=2")
+	txs := strings.Split(strings.TrimSpace(out), "\n")
+	if len(txs) != 2 {
+		t.Fatalf("got %v", out)
+	}
+	for k, tx := range txs {
+		frames, err := readQR(file(fmt.Sprintf("cons-tx%v.gif", k+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := qrJoin(frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload != tx {
+			t.Fatalf("transaction %v: QR code does not match", k+1)
+		}
+	}
+
+	// Terminal codes are printed before the output.
+	qr(carol, "terminal")
+	out = run(t, carol, "multisiginfo", "address="+lines[2])
+	expect(t, out, "█")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	it "github.com/decred/dcrdata/api/types"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// txFixture describes a contract, the state of the explorer and the spend
+// that is built from it.
+type txFixture struct {
+	Address       string                `json:"address"`
+	RedeemScript  string                `json:"redeemscript"`
+	To            string                `json:"to"`
+	Amount        string                `json:"amount"`
+	Confirmations int64                 `json:"confirmations"`
+	Utxos         []it.AddressTxnOutput `json:"utxos"`
+	Txs           map[string]string     `json:"txs"` // Raw txs by id
+}
+
+// ServeHTTP serves the dcrdata and insight calls made while building a
+// transaction from the fixture.
+func (f *txFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	utxos := func(address string) []it.AddressTxnOutput {
+		u := make([]it.AddressTxnOutput, 0, len(f.Utxos))
+		for _, utxo := range f.Utxos {
+			if utxo.Address == address {
+				u = append(u, utxo)
+			}
+		}
+		return u
+	}
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/addr/") &&
+		strings.HasSuffix(path, "/utxo"):
+		address := strings.TrimSuffix(strings.TrimPrefix(path,
+			"/addr/"), "/utxo")
+		writeJSON(utxos(address))
+	case strings.HasPrefix(path, "/addr/"):
+		address := strings.TrimPrefix(path, "/addr/")
+		var balance int64
+		for _, u := range utxos(address) {
+			balance += u.Satoshis
+		}
+		writeJSON(it.InsightAddressInfo{
+			Address:    address,
+			Balance:    dcrutil.Amount(balance).ToCoin(),
+			BalanceSat: balance,
+		})
+	case strings.HasPrefix(path, "/tx/hex/"):
+		rawTx, ok := f.Txs[strings.TrimPrefix(path, "/tx/hex/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%v", rawTx)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fixtureWallet answers the wallet calls made while building a transaction
+// from the fixture. The wallet knows the contract of the fixture.
+type fixtureWallet struct {
+	f *txFixture
+}
+
+func (w fixtureWallet) Call(ctx context.Context, method string, res interface{}, args ...interface{}) error {
+	switch method {
+	case "getmultisigoutinfo":
+		*res.(*jt.GetMultisigOutInfoResult) =
+			jt.GetMultisigOutInfoResult{
+				Address:      w.f.Address,
+				RedeemScript: w.f.RedeemScript,
+			}
+		return nil
+	}
+	return fmt.Errorf("unexpected wallet call: %v", method)
+}
+
+// buildFixtureTx builds the spend described by the fixture the way
+// createmultisigtx does. It returns a description of the transaction or of
+// the error that prevented it from being built.
+func buildFixtureTx(t *testing.T, c *client, f *txFixture) string {
+	t.Helper()
+	ctx := context.Background()
+	var b strings.Builder
+
+	balance, err := capture(t, func() error {
+		return c.getMultiSigBalance(ctx, map[string]string{
+			"address": f.Address,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(&b, "balance %v", balance)
+
+	amount, err := multisig.ParseAmount(f.Amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := multisig.New(multisig.Config{
+		Params:   c.cfg.params,
+		Wallet:   fixtureWallet{f: f},
+		Explorer: explorer{c},
+	})
+	res, err := ms.BuildTx(ctx, &multisig.TxRequest{
+		UtxoRequest: multisig.UtxoRequest{
+			Addresses:     []string{f.Address},
+			Confirmations: f.Confirmations,
+		},
+		To:     f.To,
+		Amount: amount,
+	})
+	if err != nil {
+		fmt.Fprintf(&b, "error %v\n", err)
+		return b.String()
+	}
+	for k, txIn := range res.Tx.TxIn {
+		fmt.Fprintf(&b, "input %v %v %v %v\n", k,
+			txIn.PreviousOutPoint,
+			multisig.TreeString(txIn.PreviousOutPoint.Tree),
+			dcrutil.Amount(txIn.ValueIn))
+	}
+	fmt.Fprintf(&b, "fee %v\nchange %v\ntx %v\n", res.Fee, res.Change,
+		txHex(t, res.Tx))
+	return b.String()
+}
+
+// TestTxBuildGolden builds transactions from the explorer fixtures in
+// testdata/txbuild and compares them to the golden files next to them. Run
+// with -update to regenerate the golden files.
+func TestTxBuildGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "txbuild",
+		"*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures")
+	}
+	for _, filename := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(filename), ".json")
+		t.Run(name, func(t *testing.T) {
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f txFixture
+			err = json.Unmarshal(b, &f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(&f)
+			defer srv.Close()
+
+			c := newClient(&config{
+				HTTPTimeout:     time.Second,
+				HTTPMaxResponse: defaultHTTPMaxResponse,
+				NoCache:         true,
+				FetchWorkers:    defaultFetchWorkers,
+				dcrdata:         srv.URL,
+				insight:         srv.URL,
+				params:          chaincfg.TestNet3Params(),
+			})
+			got := buildFixtureTx(t, c, &f)
+
+			golden := strings.TrimSuffix(filename, ".json") +
+				".golden"
+			if *update {
+				err = ioutil.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Fatalf("got:\n%v\nwant:\n%v", got,
+					string(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/hdkeychain/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+	"github.com/gorilla/websocket"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+const (
+	mockHeight        = 1000
+	mockConfirmations = 10
+)
+
+// mockChain is the blockchain shared by the mock wallets. Every transaction
+// is considered mined with mockConfirmations confirmations. It also serves
+// the dcrdata and insight calls dcrms makes.
+type mockChain struct {
+	sync.Mutex
+	params *chaincfg.Params
+	txs    map[string]*wire.MsgTx
+	spent  map[wire.OutPoint]string
+	seq    int
+}
+
+func newMockChain() *mockChain {
+	return &mockChain{
+		params: chaincfg.TestNet3Params(),
+		txs:    make(map[string]*wire.MsgTx),
+		spent:  make(map[wire.OutPoint]string),
+	}
+}
+
+// prevOut returns the output spent by the provided outpoint.
+func (mc *mockChain) prevOut(op wire.OutPoint) (*wire.TxOut, bool) {
+	tx, ok := mc.txs[op.Hash.String()]
+	if !ok || int(op.Index) >= len(tx.TxOut) {
+		return nil, false
+	}
+	return tx.TxOut[op.Index], true
+}
+
+// addTx mines the provided transaction after verifying that it only spends
+// unspent outputs with valid signatures. Funding transactions created by the
+// mock wallets spend outputs that are not part of the chain.
+func (mc *mockChain) addTx(tx *wire.MsgTx, verify bool) error {
+	mc.Lock()
+	defer mc.Unlock()
+
+	txID := tx.TxHash().String()
+	if _, ok := mc.txs[txID]; ok {
+		return fmt.Errorf("transaction already exists: %v", txID)
+	}
+	if verify {
+		var in, out int64
+		for k, txIn := range tx.TxIn {
+			op := txIn.PreviousOutPoint
+			if s, ok := mc.spent[op]; ok {
+				return fmt.Errorf("input %v: %v already spent "+
+					"by %v", k, op, s)
+			}
+			prev, ok := mc.prevOut(op)
+			if !ok {
+				return fmt.Errorf("input %v: unknown outpoint %v",
+					k, op)
+			}
+			vm, err := txscript.NewEngine(prev.PkScript, tx, k,
+				txscript.ScriptVerifyCleanStack, prev.Version, nil)
+			if err == nil {
+				err = vm.Execute()
+			}
+			if err != nil {
+				return fmt.Errorf("input %v: %v", k, err)
+			}
+			in += prev.Value
+		}
+		for _, txOut := range tx.TxOut {
+			out += txOut.Value
+		}
+		if in < out {
+			return fmt.Errorf("outputs exceed inputs: %v > %v", out,
+				in)
+		}
+	}
+	for _, txIn := range tx.TxIn {
+		mc.spent[txIn.PreviousOutPoint] = txID
+	}
+	mc.txs[txID] = tx
+	return nil
+}
+
+// utxos returns the unspent outputs that pay to the provided address.
+func (mc *mockChain) utxos(address string) []it.AddressTxnOutput {
+	mc.Lock()
+	defer mc.Unlock()
+
+	utxos := make([]it.AddressTxnOutput, 0)
+	for txID, tx := range mc.txs {
+		for k, txOut := range tx.TxOut {
+			op := wire.OutPoint{Hash: tx.TxHash(), Index: uint32(k)}
+			if _, ok := mc.spent[op]; ok {
+				continue
+			}
+			_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+				txOut.Version, txOut.PkScript, mc.params, false)
+			if err != nil || len(addrs) != 1 ||
+				addrs[0].Address() != address {
+				continue
+			}
+			utxos = append(utxos, it.AddressTxnOutput{
+				Address:       address,
+				TxnID:         txID,
+				Vout:          uint32(k),
+				ScriptPubKey:  hex.EncodeToString(txOut.PkScript),
+				Height:        mockHeight - mockConfirmations + 1,
+				Amount:        dcrutil.Amount(txOut.Value).ToCoin(),
+				Satoshis:      txOut.Value,
+				Confirmations: mockConfirmations,
+			})
+		}
+	}
+	return utxos
+}
+
+// appearances returns the number of transactions that pay to the provided
+// address, spent or not.
+func (mc *mockChain) appearances(address string) int64 {
+	mc.Lock()
+	defer mc.Unlock()
+
+	var n int64
+	for _, tx := range mc.txs {
+		for _, txOut := range tx.TxOut {
+			_, addrs, _, err := txscript.ExtractPkScriptAddrs(
+				txOut.Version, txOut.PkScript, mc.params, false)
+			if err == nil && len(addrs) == 1 &&
+				addrs[0].Address() == address {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// ServeHTTP serves the dcrdata (/api) and insight (/insight/api) calls.
+func (mc *mockChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	path := r.URL.Path
+	switch {
+	case path == "/api/block/best":
+		// Not it.BlockDataBasic, its time does not round trip.
+		writeJSON(map[string]interface{}{"height": mockHeight,
+			"hash": strings.Repeat("00", 32)})
+	case strings.HasPrefix(path, "/api/block/"):
+		fmt.Fprintf(w, "%v", strings.Repeat("00", 32))
+	case strings.HasPrefix(path, "/api/tx/hex/"):
+		mc.Lock()
+		tx, ok := mc.txs[strings.TrimPrefix(path, "/api/tx/hex/")]
+		mc.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := tx.Bytes()
+		fmt.Fprintf(w, "%x", b)
+	case strings.HasPrefix(path, "/insight/api/addr/") &&
+		strings.HasSuffix(path, "/utxo"):
+		address := strings.TrimSuffix(strings.TrimPrefix(path,
+			"/insight/api/addr/"), "/utxo")
+		writeJSON(mc.utxos(address))
+	case strings.HasPrefix(path, "/insight/api/addr/"):
+		address := strings.TrimPrefix(path, "/insight/api/addr/")
+		var balance int64
+		for _, u := range mc.utxos(address) {
+			balance += u.Satoshis
+		}
+		writeJSON(it.InsightAddressInfo{
+			Address:       address,
+			Balance:       dcrutil.Amount(balance).ToCoin(),
+			BalanceSat:    balance,
+			TxAppearances: mc.appearances(address),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// mockWallet is an in-process dcrwallet that implements the JSON-RPC calls
+// used by dcrms over a websocket. Keys are derived deterministically from the
+// wallet name.
+type mockWallet struct {
+	sync.Mutex
+	name    string
+	chain   *mockChain
+	keys    []*secp256k1.PrivateKey
+	account *hdkeychain.ExtendedKey // Default account
+	scripts map[string][]byte       // Imported redeem scripts by P2SH address
+	balance dcrutil.Amount
+}
+
+func newMockWallet(name string, chain *mockChain) *mockWallet {
+	seed := sha256.Sum256([]byte("account" + name))
+	master, err := hdkeychain.NewMaster(seed[:], chain.params)
+	if err != nil {
+		panic(err)
+	}
+	account, err := master.Child(hdkeychain.HardenedKeyStart)
+	if err != nil {
+		panic(err)
+	}
+	return &mockWallet{
+		name:    name,
+		chain:   chain,
+		account: account,
+		scripts: make(map[string][]byte),
+		balance: 100e8,
+	}
+}
+
+// rpcError is a JSON-RPC error.
+type rpcError struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+}
+
+// key returns the private key that belongs to the provided hash160 of a
+// public key.
+func (mw *mockWallet) key(hash160 []byte) (*secp256k1.PrivateKey, bool) {
+	for _, k := range mw.keys {
+		pk := k.PubKey().SerializeCompressed()
+		if string(dcrutil.Hash160(pk)) == string(hash160) {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func (mw *mockWallet) getNewAddress() (interface{}, error) {
+	seed := sha256.Sum256([]byte(mw.name + strconv.Itoa(len(mw.keys))))
+	k := secp256k1.PrivKeyFromBytes(seed[:])
+	mw.keys = append(mw.keys, k)
+	addr, err := dcrutil.NewAddressPubKeyHash(
+		dcrutil.Hash160(k.PubKey().SerializeCompressed()),
+		mw.chain.params, dcrec.STEcdsaSecp256k1)
+	if err != nil {
+		return nil, err
+	}
+	return addr.Address(), nil
+}
+
+func (mw *mockWallet) validateAddress(address string) (interface{}, error) {
+	addr, err := dcrutil.DecodeAddress(address, mw.chain.params)
+	if err != nil {
+		return jt.ValidateAddressResult{}, nil
+	}
+	va := jt.ValidateAddressResult{IsValid: true, Address: address}
+	pkh, ok := addr.(*dcrutil.AddressPubKeyHash)
+	if !ok {
+		return va, nil
+	}
+	k, ok := mw.key(pkh.Hash160()[:])
+	if !ok {
+		return va, nil
+	}
+	pk, err := dcrutil.NewAddressSecpPubKeyCompressed(k.PubKey(),
+		mw.chain.params)
+	if err != nil {
+		return nil, err
+	}
+	va.IsMine = true
+	va.PubKeyAddr = pk.String()
+	va.PubKey = hex.EncodeToString(pk.PubKey().SerializeCompressed())
+	return va, nil
+}
+
+// syncAddressIndex adds the account keys of the branch up to index to the
+// signing keys.
+func (mw *mockWallet) syncAddressIndex(branch uint32, index int) (interface{}, error) {
+	b, err := mw.account.Child(branch)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i <= index; i++ {
+		child, err := b.Child(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		priv, err := child.SerializedPrivKey()
+		if err != nil {
+			return nil, err
+		}
+		k := secp256k1.PrivKeyFromBytes(priv)
+		if _, ok := mw.key(dcrutil.Hash160(
+			k.PubKey().SerializeCompressed())); !ok {
+			mw.keys = append(mw.keys, k)
+		}
+	}
+	return nil, nil
+}
+
+func (mw *mockWallet) signMessage(address, message string) (interface{}, error) {
+	addr, err := dcrutil.DecodeAddress(address, mw.chain.params)
+	if err != nil {
+		return nil, err
+	}
+	pkh, ok := addr.(*dcrutil.AddressPubKeyHash)
+	if !ok {
+		return nil, fmt.Errorf("not a pubkey hash address: %v", address)
+	}
+	k, ok := mw.key(pkh.Hash160()[:])
+	if !ok {
+		return nil, fmt.Errorf("unknown address: %v", address)
+	}
+	var b bytes.Buffer
+	wire.WriteVarString(&b, 0, "Decred Signed Message:\n")
+	wire.WriteVarString(&b, 0, message)
+	sig := ecdsa.SignCompact(k, chainhash.HashB(b.Bytes()), true)
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (mw *mockWallet) createMultisig(n int, keys []string) (interface{}, error) {
+	pubKeys := make([]*dcrutil.AddressSecpPubKey, 0, len(keys))
+	for _, k := range keys {
+		addr, err := dcrutil.DecodeAddress(k, mw.chain.params)
+		if err != nil {
+			return nil, err
+		}
+		pk, ok := addr.(*dcrutil.AddressSecpPubKey)
+		if !ok {
+			return nil, fmt.Errorf("not a public key: %v", k)
+		}
+		pubKeys = append(pubKeys, pk)
+	}
+	script, err := txscript.MultiSigScript(pubKeys, n)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := dcrutil.NewAddressScriptHash(script, mw.chain.params)
+	if err != nil {
+		return nil, err
+	}
+	mw.scripts[addr.Address()] = script
+	return jt.CreateMultiSigResult{
+		Address:      addr.Address(),
+		RedeemScript: hex.EncodeToString(script),
+	}, nil
+}
+
+func (mw *mockWallet) getMultisigOutInfo(txID string, vout uint32) (interface{}, error) {
+	h, err := chainhash.NewHashFromStr(txID)
+	if err != nil {
+		return nil, err
+	}
+	mw.chain.Lock()
+	txOut, ok := mw.chain.prevOut(wire.OutPoint{Hash: *h, Index: vout})
+	mw.chain.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown outpoint %v:%v", txID, vout)
+	}
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.Version,
+		txOut.PkScript, mw.chain.params, false)
+	if err != nil || len(addrs) != 1 {
+		return nil, fmt.Errorf("not a script hash output")
+	}
+	script, ok := mw.scripts[addrs[0].Address()]
+	if !ok {
+		return nil, fmt.Errorf("unknown script: %v", addrs[0])
+	}
+	pubKeys, err := txscript.PushedData(script)
+	if err != nil {
+		return nil, err
+	}
+	n, m, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return nil, err
+	}
+	moir := jt.GetMultisigOutInfoResult{
+		Address:      addrs[0].Address(),
+		RedeemScript: hex.EncodeToString(script),
+		M:            uint8(m),
+		N:            uint8(n),
+		TxHash:       txID,
+		BlockHeight:  mockHeight - mockConfirmations + 1,
+		Amount:       dcrutil.Amount(txOut.Value).ToCoin(),
+	}
+	for _, pk := range pubKeys {
+		moir.Pubkeys = append(moir.Pubkeys, hex.EncodeToString(pk))
+	}
+	return moir, nil
+}
+
+func (mw *mockWallet) signRawTransaction(txS string) (interface{}, error) {
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		return nil, err
+	}
+	partial := tx.Copy()
+	for k := range tx.TxIn {
+		redeemScript, pubKeys, sigs, err := multisig.InputSignatures(tx, k)
+		if err != nil {
+			return nil, err
+		}
+		builder := txscript.NewScriptBuilder()
+		for _, pk := range pubKeys {
+			if _, ok := sigs[hex.EncodeToString(pk)]; ok {
+				continue
+			}
+			key, ok := mw.key(dcrutil.Hash160(pk))
+			if !ok {
+				continue
+			}
+			sig, err := txscript.RawTxInSignature(tx, k,
+				redeemScript, txscript.SigHashAll,
+				key.Serialize(), dcrec.STEcdsaSecp256k1)
+			if err != nil {
+				return nil, err
+			}
+			builder.AddData(sig)
+		}
+		script, err := builder.AddData(redeemScript).Script()
+		if err != nil {
+			return nil, err
+		}
+		partial.TxIn[k].SignatureScript = script
+	}
+	err = multisig.MergeSignatures(tx, partial)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := multisig.SigningStatus(tx)
+	if err != nil {
+		return nil, err
+	}
+	complete := true
+	for _, s := range status {
+		if s.Signatures < s.Required {
+			complete = false
+		}
+	}
+	b, err := tx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return jt.SignRawTransactionResult{
+		Hex:      hex.EncodeToString(b),
+		Complete: complete,
+	}, nil
+}
+
+func (mw *mockWallet) sendRawTransaction(txS string) (interface{}, error) {
+	tx, err := multisig.DecodeTx(txS)
+	if err != nil {
+		return nil, err
+	}
+	err = mw.chain.addTx(tx, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx.TxHash().String(), nil
+}
+
+func (mw *mockWallet) sendToAddress(address string, amount float64) (interface{}, error) {
+	addr, err := dcrutil.DecodeAddress(address, mw.chain.params)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	value, err := dcrutil.NewAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if value > mw.balance {
+		return nil, fmt.Errorf("insufficient balance")
+	}
+
+	// Spend an output of the wallet that is not part of the mock chain.
+	mw.chain.Lock()
+	mw.chain.seq++
+	prev := sha256.Sum256([]byte(mw.name + strconv.Itoa(mw.chain.seq)))
+	mw.chain.Unlock()
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint((*chainhash.Hash)(&prev), 0,
+		wire.TxTreeRegular), int64(value), nil))
+	tx.AddTxOut(wire.NewTxOut(int64(value), pkScript))
+	err = mw.chain.addTx(tx, false)
+	if err != nil {
+		return nil, err
+	}
+	mw.balance -= value
+	return tx.TxHash().String(), nil
+}
+
+// call executes a single JSON-RPC call.
+func (mw *mockWallet) call(method string, params []json.RawMessage) (interface{}, error) {
+	mw.Lock()
+	defer mw.Unlock()
+
+	var (
+		s      string
+		n      int
+		keys   []string
+		amount float64
+		vout   uint32
+	)
+	param := func(i int, v interface{}) error {
+		if i >= len(params) {
+			return fmt.Errorf("%v: missing parameter %v", method, i)
+		}
+		return json.Unmarshal(params[i], v)
+	}
+
+	switch method {
+	case "getbalance":
+		return jt.GetBalanceResult{
+			TotalSpendable: mw.balance.ToCoin(),
+		}, nil
+	case "getnewaddress":
+		return mw.getNewAddress()
+	case "validateaddress":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		return mw.validateAddress(s)
+	case "getmasterpubkey":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		if s != "default" {
+			return nil, fmt.Errorf("unknown account: %v", s)
+		}
+		return mw.account.Neuter().String(), nil
+	case "accountsyncaddressindex":
+		if err := param(1, &vout); err != nil {
+			return nil, err
+		}
+		if err := param(2, &n); err != nil {
+			return nil, err
+		}
+		return mw.syncAddressIndex(vout, n)
+	case "importscript":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		script, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := dcrutil.NewAddressScriptHash(script,
+			mw.chain.params)
+		if err != nil {
+			return nil, err
+		}
+		mw.scripts[addr.Address()] = script
+		return nil, nil
+	case "signmessage":
+		var message string
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		if err := param(1, &message); err != nil {
+			return nil, err
+		}
+		return mw.signMessage(s, message)
+	case "createmultisig":
+		if err := param(0, &n); err != nil {
+			return nil, err
+		}
+		if err := param(1, &keys); err != nil {
+			return nil, err
+		}
+		return mw.createMultisig(n, keys)
+	case "getmultisigoutinfo":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		if err := param(1, &vout); err != nil {
+			return nil, err
+		}
+		return mw.getMultisigOutInfo(s, vout)
+	case "signrawtransaction":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		return mw.signRawTransaction(s)
+	case "sendrawtransaction":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		return mw.sendRawTransaction(s)
+	case "sendtoaddress":
+		if err := param(0, &s); err != nil {
+			return nil, err
+		}
+		if err := param(1, &amount); err != nil {
+			return nil, err
+		}
+		return mw.sendToAddress(s, amount)
+	}
+	return nil, fmt.Errorf("unknown method: %v", method)
+}
+
+// ServeHTTP upgrades the connection to a websocket and serves JSON-RPC
+// requests until the client disconnects.
+func (mw *mockWallet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "user" || pass != "pass" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var upgrader websocket.Upgrader
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	for {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+			ID     uint32            `json:"id"`
+		}
+		err := ws.ReadJSON(&req)
+		if err != nil {
+			return
+		}
+		var reply struct {
+			Result interface{} `json:"result"`
+			Error  *rpcError   `json:"error"`
+			ID     uint32      `json:"id"`
+		}
+		reply.ID = req.ID
+		reply.Result, err = mw.call(req.Method, req.Params)
+		if err != nil {
+			reply.Result = nil
+			reply.Error = &rpcError{Code: -1, Message: err.Error()}
+		}
+		err = ws.WriteJSON(reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// mockServers starts the shared mock chain and one mock wallet per name.
+// It returns a client configuration for every wallet.
+func mockServers(t *testing.T, names ...string) (*mockChain, map[string]*config) {
+	t.Helper()
+	chain := newMockChain()
+	explorer := httptest.NewServer(chain)
+	t.Cleanup(explorer.Close)
+
+	configs := make(map[string]*config, len(names))
+	for _, name := range names {
+		srv := httptest.NewTLSServer(newMockWallet(name, chain))
+		t.Cleanup(srv.Close)
+		dir := t.TempDir()
+		configs[name] = &config{
+			User:            "user",
+			Pass:            "pass",
+			Net:             "testnet3",
+			HTTPTimeout:     5 * time.Second,
+			HTTPMaxResponse: defaultHTTPMaxResponse,
+			NoCache:         true,
+			FetchWorkers:    defaultFetchWorkers,
+			ca: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+				Bytes: srv.Certificate().Raw}),
+			wallet:         "wss://" + srv.Listener.Addr().String() + "/ws",
+			dcrdata:        explorer.URL + "/api",
+			insight:        explorer.URL + "/insight/api",
+			params:         chain.params,
+			policyDir:      filepath.Join(dir, "policy"),
+			auditDir:       dir,
+			coordinatorDir: filepath.Join(dir, "coordinator"),
+		}
+	}
+	return chain, configs
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	"github.com/marcopeereboom/dcrms/multisig"
+)
+
+// errWizardAborted is returned when the input of the wizard ends.
+var errWizardAborted = errors.New("wizard aborted")
+
+// wizard walks a user through the multisig workflow by prompting for every
+// value. Prompts and results are written to out, warnings to stderr.
+type wizard struct {
+	c        *client
+	in       *bufio.Scanner
+	out      io.Writer
+	contract string // Contract the wizard is working on
+}
+
+// wizardSteps are the steps offered by the wizard menu.
+var wizardSteps = []struct {
+	name string
+	run  func(*wizard, context.Context) error
+}{
+	{"Create a new contract", (*wizard).create},
+	{"Import a contract from its redeem script", (*wizard).importContract},
+	{"Fund a contract from this wallet", (*wizard).fund},
+	{"Propose a spend from a contract", (*wizard).propose},
+	{"Review and sign a transaction", (*wizard).sign},
+	{"Broadcast a signed transaction", (*wizard).broadcast},
+}
+
+func (c *client) wizard(ctx context.Context, a map[string]string) error {
+	return c.runWizard(ctx, os.Stdin, os.Stdout)
+}
+
+// runWizard runs the wizard until the user quits or in ends.
+func (c *client) runWizard(ctx context.Context, in io.Reader, out io.Writer) error {
+	w := &wizard{
+		c:   c,
+		in:  bufio.NewScanner(in),
+		out: out,
+	}
+	fmt.Fprintf(w.out, "dcrms wizard on %v\n", c.cfg.params.Name)
+	for {
+		fmt.Fprintf(w.out, "\n")
+		if w.contract != "" {
+			fmt.Fprintf(w.out, "Contract: %v\n", w.contract)
+		}
+		for k, s := range wizardSteps {
+			fmt.Fprintf(w.out, "  %v) %v\n", k+1, s.name)
+		}
+		fmt.Fprintf(w.out, "  q) Quit\n")
+		choice, err := w.prompt("Choice", "")
+		if err != nil {
+			return err
+		}
+		if choice == "q" {
+			return nil
+		}
+		step, err := strconv.Atoi(choice)
+		if err != nil || step < 1 || step > len(wizardSteps) {
+			fmt.Fprintf(w.out, "Invalid choice: %v\n", choice)
+			continue
+		}
+
+		// A failed step returns to the menu, only running out of input
+		// ends the wizard.
+		err = wizardSteps[step-1].run(w, ctx)
+		switch {
+		case errors.Is(err, errWizardAborted):
+			return err
+		case err != nil:
+			fmt.Fprintf(w.out, "Error: %v\n", err)
+		}
+	}
+}
+
+// prompt asks for a value and returns it without surrounding white space, or
+// def if the user enters nothing.
+func (w *wizard) prompt(label, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%v [%v]: ", label, def)
+	} else {
+		fmt.Fprintf(w.out, "%v: ", label)
+	}
+	if !w.in.Scan() {
+		fmt.Fprintf(w.out, "\n")
+		if err := w.in.Err(); err != nil {
+			return "", err
+		}
+		return "", errWizardAborted
+	}
+	v := strings.TrimSpace(w.in.Text())
+	if v == "" {
+		return def, nil
+	}
+	return v, nil
+}
+
+// ask prompts until check accepts the value.
+func (w *wizard) ask(label, def string, check func(string) error) (string, error) {
+	for {
+		v, err := w.prompt(label, def)
+		if err != nil {
+			return "", err
+		}
+		if err = check(v); err == nil {
+			return v, nil
+		}
+		fmt.Fprintf(w.out, "Invalid %v: %v\n", strings.ToLower(label),
+			err)
+	}
+}
+
+// confirm asks a yes or no question, no being the default.
+func (w *wizard) confirm(question string) (bool, error) {
+	v, err := w.prompt(question+" [y/N]", "")
+	if err != nil {
+		return false, err
+	}
+	v = strings.ToLower(v)
+	return v == "y" || v == "yes", nil
+}
+
+// checkNetwork returns an error if the provided address is invalid or does
+// not belong to the network.
+func checkNetwork(s string, params *chaincfg.Params) (dcrutil.Address, error) {
+	addr, err := dcrutil.DecodeAddress(s, params)
+	if err != nil {
+		return nil, fmt.Errorf("not a %v address: %v", params.Name, s)
+	}
+	return addr, nil
+}
+
+// checkContract returns an error if the provided address is not a pay to
+// script hash address of the network.
+func checkContract(s string, params *chaincfg.Params) error {
+	addr, err := checkNetwork(s, params)
+	if err != nil {
+		return err
+	}
+	if _, ok := addr.(*dcrutil.AddressScriptHash); !ok {
+		return fmt.Errorf("not a contract address: %v", s)
+	}
+	return nil
+}
+
+// checkThreshold returns an error unless 1 <= m <= n.
+func checkThreshold(s string, n int) error {
+	m, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("not a number: %v", s)
+	}
+	if m < 1 || m > n {
+		return fmt.Errorf("must be between 1 and %v", n)
+	}
+	return nil
+}
+
+// checkAmount returns an error unless the provided amount is positive.
+func checkAmount(s string) error {
+	amount, err := multisig.ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+// selectContract returns the contract the wizard is working on, asking for
+// one if there is none yet.
+func (w *wizard) selectContract() (string, error) {
+	address, err := w.ask("Contract address", w.contract,
+		func(s string) error {
+			return checkContract(s, w.c.cfg.params)
+		})
+	if err != nil {
+		return "", err
+	}
+	w.contract = address
+	return address, nil
+}
+
+// printContract prints the summary of a contract.
+func (w *wizard) printContract(m int, keys []string) {
+	fmt.Fprintf(w.out, "\nSignatures   : %v of %v\n", m, len(keys))
+	for _, k := range keys {
+		fmt.Fprintf(w.out, "Public key   : %v\n", k)
+	}
+}
+
+// create creates a new contract from keys provided by the user or generated
+// by the wallet.
+func (w *wizard) create(ctx context.Context) error {
+	v, err := w.ask("Number of cosigners (N)", "", func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("not a number: %v", s)
+		}
+		if n < 1 || n > txscript.MaxPubKeysPerMultiSig {
+			return fmt.Errorf("must be between 1 and %v",
+				txscript.MaxPubKeysPerMultiSig)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	n, _ := strconv.Atoi(v)
+
+	// Keys come with a statement that proves possession for a named
+	// contract unless the user explicitly opts out, which is logged.
+	name, err := w.prompt("Contract name", "")
+	if err != nil {
+		return err
+	}
+	var unverified string
+	if name == "" {
+		ok, err := w.confirm("Accept public keys without proof of " +
+			"possession?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("key statements require a contract " +
+				"name")
+		}
+		unverified, err = w.ask("Reason", "", func(s string) error {
+			if s == "" {
+				return fmt.Errorf("required")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if name != "" {
+		fmt.Fprintf(w.out, "Enter the key statement of every "+
+			"cosigner (dcrms getnewkey contract=%q), or nothing to "+
+			"use a new key from this wallet.\n", name)
+	} else {
+		fmt.Fprintf(w.out, "Enter the public key of every cosigner, "+
+			"or nothing to use a new key from this wallet.\n")
+	}
+	keys := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for len(keys) < n {
+		var key string
+		label := fmt.Sprintf("Public key %v", len(keys)+1)
+		_, err := w.ask(label, "", func(s string) error {
+			key = s
+			if s == "" {
+				return nil
+			}
+			if name != "" {
+				ks, err := multisig.ParseKeyStatement(s)
+				if err != nil {
+					return err
+				}
+				err = ks.Verify(name, w.c.cfg.params)
+				if err != nil {
+					return err
+				}
+				key = ks.Key
+			}
+			if seen[key] {
+				return fmt.Errorf("duplicate key: %v", key)
+			}
+			_, err := multisig.CheckKey(key, w.c.cfg.params)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			_, key, err = w.c.newKey(ctx)
+			if err != nil {
+				return fmt.Errorf("new key: %v", err)
+			}
+			fmt.Fprintf(w.out, "New key      : %v\n", key)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	v, err = w.ask("Required signatures (M)", "", func(s string) error {
+		return checkThreshold(s, n)
+	})
+	if err != nil {
+		return err
+	}
+	m, _ := strconv.Atoi(v)
+
+	w.printContract(m, keys)
+	ok, err := w.confirm("Create contract?")
+	if err != nil || !ok {
+		return err
+	}
+	return w.importKeys(ctx, m, keys, unverified)
+}
+
+// importContract imports a contract created by a cosigner into the wallet.
+func (w *wizard) importContract(ctx context.Context) error {
+	var contract *multisig.Contract
+	_, err := w.ask("Redeem script", "", func(s string) error {
+		script, err := hex.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("not hex")
+		}
+		contract, err = multisig.NewContract(script, w.c.cfg.params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w.out, "\nAddress      : %v", contract.Address)
+	w.printContract(contract.M, contract.PubKeys)
+	ok, err := w.confirm("Import contract?")
+	if err != nil || !ok {
+		return err
+	}
+	return w.importKeys(ctx, contract.M, contract.PubKeys, "")
+}
+
+// importKeys creates the m of len(keys) contract in the wallet and makes it
+// the contract the wizard works on.
+func (w *wizard) importKeys(ctx context.Context, m int, keys []string, unverified string) error {
+	contract, err := w.c.createContract(ctx, m, keys, unverified)
+	if err != nil {
+		return err
+	}
+	w.contract = contract.Address
+	fmt.Fprintf(w.out, "Address      : %v\n", contract.Address)
+	fmt.Fprintf(w.out, "Redeem script: %x\n", contract.RedeemScript)
+	fmt.Fprintf(w.out, "Share the redeem script with the cosigners so "+
+		"they can import the contract.\n")
+	return nil
+}
+
+// fund sends funds from the wallet to a contract.
+func (w *wizard) fund(ctx context.Context) error {
+	address, err := w.selectContract()
+	if err != nil {
+		return err
+	}
+
+	var balance jt.GetBalanceResult
+	err = w.c.walletCall(ctx, "getbalance", &balance)
+	if err != nil {
+		return fmt.Errorf("getbalance: %v", err)
+	}
+	spendable, err := dcrutil.NewAmount(balance.TotalSpendable)
+	if err != nil {
+		return fmt.Errorf("getbalance: %v", err)
+	}
+	fmt.Fprintf(w.out, "Spendable    : %v\n", spendable)
+	v, err := w.ask("Amount", "", func(s string) error {
+		if err := checkAmount(s); err != nil {
+			return err
+		}
+		if amount, _ := multisig.ParseAmount(s); amount > spendable {
+			return fmt.Errorf("balance too low: available %v",
+				spendable)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	amount, _ := multisig.ParseAmount(v)
+
+	fmt.Fprintf(w.out, "\nSend %v from this wallet to %v.\n", amount,
+		address)
+	ok, err := w.confirm("This can not be undone, send?")
+	if err != nil || !ok {
+		return err
+	}
+	var txHash string
+	err = w.c.walletCall(ctx, "sendtoaddress", &txHash, address,
+		amount.ToCoin())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "%v\n", txHash)
+	return nil
+}
+
+// propose builds an unsigned transaction that spends from a contract.
+func (w *wizard) propose(ctx context.Context) error {
+	address, err := w.selectContract()
+	if err != nil {
+		return err
+	}
+	a := map[string]string{
+		"address":       address,
+		"confirmations": strconv.Itoa(defaultConfirmations),
+	}
+	a["to"], err = w.ask("Destination address", "", func(s string) error {
+		_, err := checkNetwork(s, w.c.cfg.params)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a["amount"], err = w.ask("Amount", "", checkAmount)
+	if err != nil {
+		return err
+	}
+	memo, err := w.ask("Memo (empty for none)", "", func(s string) error {
+		if s == "" {
+			return nil
+		}
+		_, err := multisig.ParseMemo(s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if memo != "" {
+		a["memo"] = memo
+	}
+	a["expiry"], err = w.ask("Expiry (height or +blocks)", "+288",
+		argHeight.check)
+	if err != nil {
+		return err
+	}
+
+	amount, _ := ArgAsAmount("amount", a)
+	expiry, lockTime, err := w.c.txTiming(ctx, a)
+	if err != nil {
+		return err
+	}
+	memoData, _ := memoArg(a)
+	res, err := w.c.ms.BuildTx(ctx, &multisig.TxRequest{
+		UtxoRequest: w.c.utxoRequest([]string{address},
+			defaultConfirmations, a),
+		To:       a["to"],
+		Amount:   amount,
+		Memo:     memoData,
+		Expiry:   expiry,
+		LockTime: lockTime,
+	})
+	if err != nil {
+		return err
+	}
+	tx, err := multisig.EncodeTx(res.Tx)
+	if err != nil {
+		return err
+	}
+	err = w.summary(ctx, res.Tx)
+	if err != nil {
+		return err
+	}
+	ok, err := w.confirm("Propose this transaction?")
+	if err != nil || !ok {
+		return err
+	}
+	err = w.c.auditProposal(res.Tx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "Send this transaction to the cosigners to "+
+		"sign:\n")
+	fmt.Fprintf(w.out, "%v\n", tx)
+	return nil
+}
+
+// askTx prompts for a transaction and prints its summary.
+func (w *wizard) askTx(ctx context.Context, label string) (string, error) {
+	tx, err := w.ask(label, "", func(s string) error {
+		_, err := multisig.DecodeTx(s)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	msgTx, err := multisig.DecodeTx(tx)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(w.out, "\n")
+	err = w.summary(ctx, msgTx)
+	if err != nil {
+		return "", err
+	}
+	return tx, nil
+}
+
+// summary prints the inputs, outputs and fee of the provided transaction.
+func (w *wizard) summary(ctx context.Context, tx *wire.MsgTx) error {
+	err := w.c.printTxSummary(ctx, w.out, tx)
+	if err != nil {
+		return err
+	}
+	w.c.warnExpiry(ctx, tx)
+	return nil
+}
+
+// sign signs a transaction with the wallet after the user reviewed it and
+// offers to broadcast it when signing is complete.
+func (w *wizard) sign(ctx context.Context) error {
+	tx, err := w.askTx(ctx, "Transaction")
+	if err != nil {
+		return err
+	}
+	ok, err := w.confirm("Sign this transaction?")
+	if err != nil || !ok {
+		return err
+	}
+	srtr, _, err := w.c.signTx(ctx, w.out, tx, map[string]string{})
+	if err != nil {
+		return err
+	}
+	if !srtr.Complete {
+		fmt.Fprintf(w.out, "More signatures are required, send this "+
+			"transaction to the next cosigner:\n")
+		fmt.Fprintf(w.out, "%v\n", srtr.Hex)
+		return nil
+	}
+	fmt.Fprintf(w.out, "Signing is complete.\n")
+	fmt.Fprintf(w.out, "%v\n", srtr.Hex)
+	return w.confirmBroadcast(ctx, srtr.Hex)
+}
+
+// broadcast broadcasts a fully signed transaction.
+func (w *wizard) broadcast(ctx context.Context) error {
+	tx, err := w.askTx(ctx, "Signed transaction")
+	if err != nil {
+		return err
+	}
+	return w.confirmBroadcast(ctx, tx)
+}
+
+// confirmBroadcast broadcasts the provided transaction once the user
+// confirms.
+func (w *wizard) confirmBroadcast(ctx context.Context, tx string) error {
+	msgTx, err := multisig.DecodeTx(tx)
+	if err != nil {
+		return err
+	}
+	status, err := multisig.SigningStatus(msgTx)
+	if err != nil {
+		return err
+	}
+	if !multisig.Complete(status) {
+		return fmt.Errorf("transaction is not fully signed")
+	}
+	ok, err := w.confirm("This can not be undone, broadcast?")
+	if err != nil || !ok {
+		return err
+	}
+	txHash, err := w.c.broadcastTx(ctx, msgTx)
+	if txHash != "" {
+		fmt.Fprintf(w.out, "%v\n", txHash)
+	}
+	return err
+}
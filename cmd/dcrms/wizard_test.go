@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+)
+
+// wizardSession feeds the provided lines to the wizard and returns everything
+// it printed.
+func wizardSession(t *testing.T, c *client, lines ...string) string {
+	t.Helper()
+	in := strings.NewReader(strings.Join(lines, "\n") + "\n")
+	var out bytes.Buffer
+	stdout, err := capture(t, func() error {
+		return c.runWizard(context.Background(), in, &out)
+	})
+	if err != nil {
+		t.Fatalf("%v: %v", lines, err)
+	}
+	if stdout != "" {
+		t.Fatalf("%v: written to stdout: %q", lines, stdout)
+	}
+	return out.String()
+}
+
+// after returns the line that follows the first line containing marker.
+func after(t *testing.T, out, marker string) string {
+	t.Helper()
+	lines := strings.Split(out, "\n")
+	for k, l := range lines {
+		if strings.Contains(l, marker) && k+1 < len(lines) {
+			return lines[k+1]
+		}
+	}
+	t.Fatalf("%q not found in %q", marker, out)
+	return ""
+}
+
+// promptField returns the value of a "Label : value" line that may follow an
+// unanswered prompt, input not being echoed.
+func promptField(t *testing.T, out, label string) string {
+	t.Helper()
+	m := regexp.MustCompile(regexp.QuoteMeta(label) + ` *: (\S+)\n`).
+		FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("%v not found in %q", label, out)
+	}
+	return m[1]
+}
+
+func TestWizard(t *testing.T) {
+	_, configs := mockServers(t, "alice", "bob")
+	alice, bob := newClient(configs["alice"]), newClient(configs["bob"])
+	bobStatement := lastLine(run(t, bob, "getnewkey", "contract=escrow"))
+	bobKey := strings.Split(bobStatement, ":")[0]
+	mainnetKey, err := dcrutil.NewAddressSecpPubKeyCompressed(
+		secp256k1.PrivKeyFromBytes([]byte{1}).PubKey(),
+		chaincfg.MainNetParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a 2 of 2 contract with bob's key and a new key, rejecting
+	// bare keys, keys of another network, duplicate keys and an invalid
+	// threshold, then fund it after declining once.
+	out := wizardSession(t, alice,
+		"7", "1", "2", "escrow", bobKey, bobStatement,
+		mainnetKey.String()+strings.TrimPrefix(bobStatement, bobKey),
+		bobStatement, "",
+		"3", "0", "2", "y",
+		"3", "", "1000", "5", "n",
+		"3", "", "5", "y",
+		"q")
+	for _, want := range []string{
+		"Invalid choice: 7",
+		"Invalid public key 1: not a key statement",
+		"Invalid public key 2: not a testnet3 address",
+		"Invalid public key 2: duplicate key",
+		"Invalid required signatures (m): must be between 1 and 2",
+		"Signatures   : 2 of 2",
+		"Invalid amount: balance too low",
+		"Send 5 DCR from this wallet",
+	} {
+		expect(t, out, want)
+	}
+	escrow := promptField(t, out, "Address")
+	redeemScript := promptField(t, out, "Redeem script")
+	expect(t, run(t, bob, "getmultisigbalance", "address="+escrow), "5")
+
+	// Bob imports the contract from the redeem script.
+	out = wizardSession(t, bob, "2", "00", redeemScript, "y", "q")
+	expect(t, out, "Invalid redeem script: not a multisig script")
+	if promptField(t, out, "Address") != escrow {
+		t.Fatalf("imported contract mismatch: %v", out)
+	}
+
+	// Alice proposes a spend and signs it, bob signs and broadcasts it.
+	out = wizardSession(t, alice,
+		"4", escrow, "TsX", payee, "1", "INV-1", "", "y", "q")
+	expect(t, out, "Invalid destination address: not a testnet3 address")
+	expect(t, out, `memo "INV-1"`)
+	tx := after(t, out, "to sign:")
+	out = wizardSession(t, alice, "6", tx, "5", tx, "y", "q")
+	expect(t, out, "Error: transaction is not fully signed")
+	tx = after(t, out, "next cosigner:")
+	out = wizardSession(t, bob, "5", tx, "y", "y", "q")
+	expect(t, out, "Signing is complete.")
+	expect(t, run(t, bob, "getmultisigbalance", "address="+escrow), "3.99")
+
+	// Bare keys are refused unless the user gives a reason to accept
+	// them, which is logged.
+	out = wizardSession(t, bob, "1", "1", "", "n", "q")
+	expect(t, out, "Error: key statements require a contract name")
+	out = wizardSession(t, bob, "1", "1", "", "y", "", "lost statements",
+		bobKey, "1", "y", "q")
+	expect(t, out, "Invalid reason: required")
+	expect(t, out, "Signatures   : 1 of 1")
+	expect(t, run(t, bob, "auditlog", "export"),
+		`"unverified": "lost statements"`)
+
+	// Running out of input aborts the wizard.
+	_, err = capture(t, func() error {
+		return alice.runWizard(context.Background(),
+			strings.NewReader("1\n"), os.Stdout)
+	})
+	if !errors.Is(err, errWizardAborted) {
+		t.Fatalf("got %v", err)
+	}
+}
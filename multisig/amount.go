@@ -0,0 +1,70 @@
+package multisig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/dcrutil/v3"
+)
+
+// amountUnits are the unit suffixes accepted by ParseAmount and the number of
+// decimals that can be expressed in each of them, which is also the power of
+// ten of atoms per unit.
+var amountUnits = []struct {
+	suffix   string
+	decimals int
+}{
+	{"mdcr", 5},
+	{"dcr", 8},
+	{"atoms", 0},
+	{"atom", 0},
+}
+
+// ParseAmount parses a decimal amount, e.g. 1.5, 1.5DCR, 1500 mDCR or 150000000
+// atoms, into atoms without going through floating point. Amounts without a
+// unit are in DCR. Amounts that can not be expressed in whole atoms are
+// rejected rather than rounded.
+func ParseAmount(s string) (dcrutil.Amount, error) {
+	v := strings.TrimSpace(s)
+	decimals := 8
+	for _, u := range amountUnits {
+		if strings.HasSuffix(strings.ToLower(v), u.suffix) {
+			v = strings.TrimSpace(v[:len(v)-len(u.suffix)])
+			decimals = u.decimals
+			break
+		}
+	}
+
+	whole, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return 0, fmt.Errorf("invalid amount: %q", s)
+			}
+		}
+	}
+	if len(frac) > decimals {
+		return 0, fmt.Errorf("invalid amount %q: more than %v "+
+			"decimals", s, decimals)
+	}
+
+	// Scale to atoms by padding the fraction to all decimals.
+	digits := strings.TrimLeft(whole+frac+strings.Repeat("0",
+		decimals-len(frac)), "0")
+	if digits == "" {
+		return 0, nil
+	}
+	atoms, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || atoms > dcrutil.MaxAmount {
+		return 0, fmt.Errorf("invalid amount %q: exceeds %v", s,
+			dcrutil.Amount(dcrutil.MaxAmount))
+	}
+	return dcrutil.Amount(atoms), nil
+}
@@ -0,0 +1,59 @@
+package multisig
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrutil/v3"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount  string
+		want    dcrutil.Amount
+		wantErr bool
+	}{
+		{"1", 1e8, false},
+		{"1.5", 15e7, false},
+		{".5", 5e7, false},
+		{"5.", 5e8, false},
+		{"0.3", 3e7, false},
+		{"0.00000001", 1, false},
+		{"0.000000001", 0, true},
+		{"1.5DCR", 15e7, false},
+		{"1.5 dcr", 15e7, false},
+		{"1500mDCR", 15e7, false},
+		{"0.00001 mDCR", 1, false},
+		{"0.000001mDCR", 0, true},
+		{"150000000atoms", 15e7, false},
+		{"1 atom", 1, false},
+		{"1.5atoms", 0, true},
+		{"0", 0, false},
+		{"21000000", dcrutil.MaxAmount, false},
+		{"21000000.00000001", 0, true},
+		{"99999999999999999999", 0, true},
+		{"", 0, true},
+		{".", 0, true},
+		{"DCR", 0, true},
+		{"-1", 0, true},
+		{"+1", 0, true},
+		{"1e8", 0, true},
+		{"1,5", 0, true},
+		{"1.5 BTC", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.amount)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error, got %v", tt.amount,
+					got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tt.amount, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%q: got %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
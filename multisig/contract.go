@@ -0,0 +1,182 @@
+package multisig
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+// ContractRequest describes a contract to create.
+type ContractRequest struct {
+	M    int      // Signatures required
+	Keys []string // Public key addresses of the cosigners
+}
+
+// Contract is a multisig contract.
+type Contract struct {
+	Address      string   // P2SH address
+	RedeemScript []byte   // Multisig redeem script
+	M            int      // Signatures required
+	N            int      // Number of keys
+	PubKeys      []string // Public key addresses in redeem script order
+}
+
+// NewContract returns the contract of the provided multisig redeem script.
+func NewContract(redeemScript []byte, params *chaincfg.Params) (*Contract, error) {
+	if !txscript.IsMultisigScript(redeemScript) {
+		return nil, fmt.Errorf("not a multisig script")
+	}
+	n, m, err := txscript.CalcMultiSigStats(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	sh, err := dcrutil.NewAddressScriptHash(redeemScript, params)
+	if err != nil {
+		return nil, fmt.Errorf("NewAddressScriptHash: %v", err)
+	}
+	pushes, err := txscript.PushedData(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	contract := &Contract{
+		Address:      sh.Address(),
+		RedeemScript: redeemScript,
+		M:            m,
+		N:            n,
+		PubKeys:      make([]string, 0, len(pushes)),
+	}
+	for _, pk := range pushes {
+		a, err := dcrutil.NewAddressSecpPubKey(pk, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %x: %v", pk,
+				err)
+		}
+		contract.PubKeys = append(contract.PubKeys, a.String())
+	}
+	return contract, nil
+}
+
+// CreateContract creates a multisig contract and imports its redeem script
+// into the wallet. Keys must be distinct compressed public key addresses of
+// the network, see CheckKeys.
+func (c *Client) CreateContract(ctx context.Context, req *ContractRequest) (*Contract, error) {
+	w, err := c.wallet()
+	if err != nil {
+		return nil, err
+	}
+	if req.M <= 0 || req.M > len(req.Keys) {
+		return nil, fmt.Errorf("invalid number of signatures: %v of %v",
+			req.M, len(req.Keys))
+	}
+	err = CheckKeys(req.Keys, c.cfg.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	var msa jt.CreateMultiSigResult
+	err = w.Call(ctx, "createmultisig", &msa, req.M, req.Keys)
+	if err != nil {
+		return nil, err
+	}
+	log.Tracef("%v", msa)
+	redeemScript, err := hex.DecodeString(msa.RedeemScript)
+	if err != nil {
+		return nil, fmt.Errorf("decode redeem script: %v", err)
+	}
+	contract, err := NewContract(redeemScript, c.cfg.Params)
+	if err != nil {
+		return nil, err
+	}
+	if contract.Address != msa.Address {
+		return nil, fmt.Errorf("redeem script does not match address: "+
+			"%v %v", contract.Address, msa.Address)
+	}
+	return contract, nil
+}
+
+// redeemScript returns the redeem script of the contract the provided utxo
+// pays to. The script is verified to hash to the address of the utxo.
+func (c *Client) redeemScript(ctx context.Context, utxo *it.AddressTxnOutput) ([]byte, error) {
+	w, err := c.wallet()
+	if err != nil {
+		return nil, err
+	}
+	var moir jt.GetMultisigOutInfoResult
+	err = w.Call(ctx, "getmultisigoutinfo", &moir, utxo.TxnID, utxo.Vout)
+	if err != nil {
+		return nil, fmt.Errorf("getMultisigOutInfo: %v", err)
+	}
+	redeemScript, err := hex.DecodeString(moir.RedeemScript)
+	if err != nil {
+		return nil, fmt.Errorf("decode string: %v", err)
+	}
+	sh, err := dcrutil.NewAddressScriptHash(redeemScript, c.cfg.Params)
+	if err != nil {
+		return nil, fmt.Errorf("NewAddressScriptHash: %v", err)
+	}
+	if sh.Address() != utxo.Address {
+		return nil, fmt.Errorf("redeem script does not match "+
+			"address: %v %v", sh.Address(), utxo.Address)
+	}
+	return redeemScript, nil
+}
+
+// redeemScripts returns the redeem scripts, keyed by address, of the
+// addresses the provided utxos pay to. Scripts that are not known are looked
+// up with the wallet.
+func (c *Client) redeemScripts(ctx context.Context, known map[string][]byte, utxos []it.AddressTxnOutput) (map[string][]byte, error) {
+	redeemScripts := make(map[string][]byte)
+	for k := range utxos {
+		address := utxos[k].Address
+		if _, ok := redeemScripts[address]; ok {
+			continue
+		}
+		if redeemScript, ok := known[address]; ok {
+			sh, err := dcrutil.NewAddressScriptHash(redeemScript,
+				c.cfg.Params)
+			if err != nil || sh.Address() != address {
+				return nil, fmt.Errorf("redeem script does "+
+					"not match address: %v", address)
+			}
+			redeemScripts[address] = redeemScript
+			continue
+		}
+		redeemScript, err := c.redeemScript(ctx, &utxos[k])
+		if err != nil {
+			return nil, err
+		}
+		redeemScripts[address] = redeemScript
+	}
+	return redeemScripts, nil
+}
+
+// ContractInfo returns the contract of the provided multisig address. The
+// wallet must know the contract and the address must have received funds.
+func (c *Client) ContractInfo(ctx context.Context, address string) (*Contract, error) {
+	e, err := c.explorer()
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := e.Utxos(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("no information available for: %v", address)
+	}
+
+	// Fish out the first utxo to get to the multisig address info
+	utxo := utxos[0]
+	utxo.Address = address
+	redeemScript, err := c.redeemScript(ctx, &utxo)
+	if err != nil {
+		return nil, err
+	}
+	return NewContract(redeemScript, c.cfg.Params)
+}
@@ -0,0 +1,188 @@
+package multisig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+)
+
+// Descriptors describe a contract as network:sh(multi(m,key,...))#checksum,
+// or sortedmulti when the keys are in lexicographic order, with hex encoded
+// compressed public keys. The checksum is the output descriptor checksum used
+// by Bitcoin Core and covers the network.
+
+const (
+	descriptorInputCharset = "0123456789()[],'/*abcdefgh@:$%{}" +
+		"IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~" +
+		"ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+var descriptorGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312,
+	0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// descriptorChecksum returns the checksum of the provided descriptor.
+func descriptorChecksum(s string) (string, error) {
+	polymod := func(chk uint64, value int) uint64 {
+		top := chk >> 35
+		chk = (chk&0x7ffffffff)<<5 ^ uint64(value)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= descriptorGenerator[i]
+			}
+		}
+		return chk
+	}
+
+	chk := uint64(1)
+	cls, clsCount := 0, 0
+	for _, r := range s {
+		v := strings.IndexRune(descriptorInputCharset, r)
+		if v < 0 {
+			return "", fmt.Errorf("invalid descriptor character: %q",
+				r)
+		}
+		chk = polymod(chk, v&31)
+		cls = cls*3 + v>>5
+		clsCount++
+		if clsCount == 3 {
+			chk = polymod(chk, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		chk = polymod(chk, cls)
+	}
+	for i := 0; i < 8; i++ {
+		chk = polymod(chk, 0)
+	}
+	chk ^= 1
+
+	var b strings.Builder
+	for i := 0; i < 8; i++ {
+		b.WriteByte(descriptorChecksumCharset[(chk>>(5*(7-i)))&31])
+	}
+	return b.String(), nil
+}
+
+// Descriptor returns the descriptor of the contract of the provided redeem
+// script.
+func Descriptor(redeemScript []byte, params *chaincfg.Params) (string, error) {
+	if !txscript.IsMultisigScript(redeemScript) {
+		return "", fmt.Errorf("not a multisig script")
+	}
+	_, m, err := txscript.CalcMultiSigStats(redeemScript)
+	if err != nil {
+		return "", err
+	}
+	pushes, err := txscript.PushedData(redeemScript)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(pushes))
+	for _, pk := range pushes {
+		keys = append(keys, hex.EncodeToString(pk))
+	}
+	multi := "multi"
+	if sort.StringsAreSorted(keys) {
+		multi = "sortedmulti"
+	}
+	d := fmt.Sprintf("%v:sh(%v(%v,%v))", params.Name, multi, m,
+		strings.Join(keys, ","))
+	checksum, err := descriptorChecksum(d)
+	if err != nil {
+		return "", err
+	}
+	return d + "#" + checksum, nil
+}
+
+// IsDescriptor returns true if the provided string looks like a descriptor
+// rather than an address.
+func IsDescriptor(s string) bool {
+	return strings.Contains(s, "(")
+}
+
+// ParseDescriptor returns the contract of the provided descriptor after
+// verifying its checksum and network.
+func ParseDescriptor(s string, params *chaincfg.Params) (*Contract, error) {
+	i := strings.LastIndexByte(s, '#')
+	if i < 0 {
+		return nil, fmt.Errorf("descriptor has no checksum: %v", s)
+	}
+	d, checksum := s[:i], s[i+1:]
+	want, err := descriptorChecksum(d)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != want {
+		return nil, fmt.Errorf("invalid descriptor checksum: %v", s)
+	}
+
+	network := params.Name + ":"
+	if !strings.HasPrefix(d, network) {
+		return nil, fmt.Errorf("descriptor is not for %v: %v",
+			params.Name, s)
+	}
+	d = strings.TrimPrefix(d, network)
+	var sorted bool
+	switch {
+	case strings.HasPrefix(d, "sh(multi(") && strings.HasSuffix(d, "))"):
+		d = strings.TrimSuffix(strings.TrimPrefix(d, "sh(multi("), "))")
+	case strings.HasPrefix(d, "sh(sortedmulti(") &&
+		strings.HasSuffix(d, "))"):
+		d = strings.TrimSuffix(strings.TrimPrefix(d, "sh(sortedmulti("),
+			"))")
+		sorted = true
+	default:
+		return nil, fmt.Errorf("unsupported descriptor: %v", s)
+	}
+
+	args := strings.Split(d, ",")
+	m, err := strconv.Atoi(args[0])
+	if err != nil || len(args) < 2 {
+		return nil, fmt.Errorf("invalid descriptor threshold: %v", s)
+	}
+	pks := make([][]byte, 0, len(args)-1)
+	seen := make(map[string]bool, len(args)-1)
+	for _, k := range args[1:] {
+		pk, err := hex.DecodeString(k)
+		if err != nil || len(pk) != 33 {
+			return nil, fmt.Errorf("invalid descriptor key: %v", k)
+		}
+		if seen[k] {
+			return nil, fmt.Errorf("duplicate key: %v", k)
+		}
+		seen[k] = true
+		pks = append(pks, pk)
+	}
+	if sorted {
+		sort.Slice(pks, func(i, j int) bool {
+			return bytes.Compare(pks[i], pks[j]) < 0
+		})
+	}
+	pubKeys := make([]*dcrutil.AddressSecpPubKey, 0, len(pks))
+	for _, pk := range pks {
+		a, err := dcrutil.NewAddressSecpPubKey(pk, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid descriptor key %x: %v",
+				pk, err)
+		}
+		pubKeys = append(pubKeys, a)
+	}
+	if m <= 0 || m > len(pubKeys) {
+		return nil, fmt.Errorf("invalid number of signatures: %v of %v",
+			m, len(pubKeys))
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, m)
+	if err != nil {
+		return nil, err
+	}
+	return NewContract(redeemScript, params)
+}
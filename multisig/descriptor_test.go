@@ -0,0 +1,96 @@
+package multisig
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	// Test vector of the output descriptor checksum.
+	got, err := descriptorChecksum("raw(deadbeef)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "89f8spxm" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	redeemScript := mustDecodeHex(t, escrowScript)
+	d, err := Descriptor(redeemScript, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(d, "testnet3:sh(sortedmulti(2,0254cf9dc4") {
+		t.Fatalf("got %v", d)
+	}
+	contract, err := ParseDescriptor(d, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contract.Address != escrowAddr ||
+		!bytes.Equal(contract.RedeemScript, redeemScript) {
+		t.Fatalf("got %v %x", contract.Address, contract.RedeemScript)
+	}
+
+	// Unsorted keys keep their order.
+	unsorted := append([]byte{}, redeemScript...)
+	copy(unsorted[1:35], redeemScript[35:69])
+	copy(unsorted[35:69], redeemScript[1:35])
+	d, err = Descriptor(unsorted, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(d, "testnet3:sh(multi(2,02b687") {
+		t.Fatalf("got %v", d)
+	}
+	contract, err = ParseDescriptor(d, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contract.RedeemScript, unsorted) {
+		t.Fatalf("got %x", contract.RedeemScript)
+	}
+
+	key := "0254cf9dc4798eabd6dd1e34a6ea2a4d387bc6b766b1c73609a27d12da3ab9d977"
+	tests := []struct {
+		d   string
+		err string
+	}{
+		{strings.Replace(d, "multi(2", "multi(1", 1),
+			"invalid descriptor checksum"},
+		{strings.TrimSuffix(d, d[strings.IndexByte(d, '#'):]),
+			"no checksum"},
+		{"mainnet" + d[len("testnet3"):], "invalid descriptor checksum"},
+		{withChecksum(t, "mainnet:sh(multi(1,"+key+"))"),
+			"not for testnet3"},
+		{withChecksum(t, "testnet3:wsh(multi(1,"+key+"))"),
+			"unsupported descriptor"},
+		{withChecksum(t, "testnet3:sh(multi(2,"+key+"))"),
+			"invalid number of signatures: 2 of 1"},
+		{withChecksum(t, "testnet3:sh(multi(1,"+key+","+key+"))"),
+			"duplicate key"},
+		{withChecksum(t, "testnet3:sh(multi(1,00))"),
+			"invalid descriptor key"},
+	}
+	for _, tt := range tests {
+		_, err := ParseDescriptor(tt.d, params)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: got %v, want %v", tt.d, err, tt.err)
+		}
+	}
+}
+
+func withChecksum(t *testing.T, d string) string {
+	t.Helper()
+	checksum, err := descriptorChecksum(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d + "#" + checksum
+}
@@ -0,0 +1,324 @@
+package multisig
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/hdkeychain/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	// ExternalBranch is the branch of the deposit addresses of HD
+	// contracts.
+	ExternalBranch = 0
+
+	// InternalBranch is the branch of the change addresses of HD
+	// contracts.
+	InternalBranch = 1
+
+	// DefaultGapLimit is the number of consecutive unused addresses after
+	// which scanning a branch stops.
+	DefaultGapLimit = 20
+)
+
+// HDContract is an m of n multisig contract whose addresses are derived from
+// the account extended public keys of the cosigners. The contract at
+// branch/index uses the public keys xpub/path/branch/index, in the order of
+// the extended keys. Without a path these are the account branches dcrwallet
+// derives its own addresses from.
+type HDContract struct {
+	M     int      // Signatures required
+	XPubs []string // Account extended public keys of the cosigners
+	Path  []uint32 // Derivation path from the extended keys to the branches
+
+	keys   []*hdkeychain.ExtendedKey
+	params *chaincfg.Params
+}
+
+// ParseHDPath parses a derivation path of the form 0/5. Extended public keys
+// can not derive hardened children, hardened steps are an error. An empty
+// string is the empty path.
+func ParseHDPath(s string) ([]uint32, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var path []uint32
+	for _, step := range strings.Split(s, "/") {
+		if strings.HasSuffix(step, "'") || strings.HasSuffix(step, "h") {
+			return nil, fmt.Errorf("invalid path %v: hardened step "+
+				"%v can not be derived from extended public keys",
+				s, step)
+		}
+		i, err := strconv.ParseUint(step, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %v: %v", s, err)
+		}
+		if i >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid path %v: hardened step "+
+				"%v can not be derived from extended public keys",
+				s, step)
+		}
+		path = append(path, uint32(i))
+	}
+	return path, nil
+}
+
+// HDPathString returns the string form of a derivation path as parsed by
+// ParseHDPath.
+func HDPathString(path []uint32) string {
+	steps := make([]string, 0, len(path))
+	for _, step := range path {
+		steps = append(steps, strconv.FormatUint(uint64(step), 10))
+	}
+	return strings.Join(steps, "/")
+}
+
+// NewHDContract returns the m of len(xpubs) HD contract whose addresses are
+// derived below path. The extended keys must be distinct public keys of the
+// network and path must not contain hardened steps.
+func NewHDContract(m int, xpubs []string, path []uint32, params *chaincfg.Params) (*HDContract, error) {
+	if len(xpubs) == 0 || len(xpubs) > txscript.MaxPubKeysPerMultiSig {
+		return nil, fmt.Errorf("invalid number of keys: %v", len(xpubs))
+	}
+	if m <= 0 || m > len(xpubs) {
+		return nil, fmt.Errorf("invalid number of signatures: %v of %v",
+			m, len(xpubs))
+	}
+	for _, step := range path {
+		if step >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("invalid path %v: hardened step",
+				HDPathString(path))
+		}
+	}
+	h := &HDContract{
+		M:      m,
+		XPubs:  xpubs,
+		Path:   path,
+		keys:   make([]*hdkeychain.ExtendedKey, 0, len(xpubs)),
+		params: params,
+	}
+	seen := make(map[string]bool, len(xpubs))
+	for _, xpub := range xpubs {
+		key, err := hdkeychain.NewKeyFromString(xpub, params)
+		if err != nil {
+			return nil, fmt.Errorf("not a %v extended key: %v",
+				params.Name, xpub)
+		}
+		if key.IsPrivate() {
+			return nil, fmt.Errorf("private extended key, use the " +
+				"extended public key")
+		}
+		if seen[xpub] {
+			return nil, fmt.Errorf("duplicate key: %v", xpub)
+		}
+		seen[xpub] = true
+		h.keys = append(h.keys, key)
+	}
+	return h, nil
+}
+
+// Derive returns the contract at the provided branch and index.
+func (h *HDContract) Derive(branch, index uint32) (*Contract, error) {
+	pubKeys := make([]*dcrutil.AddressSecpPubKey, 0, len(h.keys))
+	steps := append(append([]uint32{}, h.Path...), branch, index)
+	for k, key := range h.keys {
+		child := key
+		var err error
+		for _, step := range steps {
+			child, err = child.Child(step)
+			if err != nil {
+				return nil, fmt.Errorf("derive %v/%v: %v",
+					h.XPubs[k], HDPathString(steps), err)
+			}
+		}
+		pk, err := dcrutil.NewAddressSecpPubKey(child.SerializedPubKey(),
+			h.params)
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pk)
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, h.M)
+	if err != nil {
+		return nil, err
+	}
+	return NewContract(redeemScript, h.params)
+}
+
+// HDAddress is a derived address of an HD contract.
+type HDAddress struct {
+	Branch   uint32
+	Index    uint32
+	Contract *Contract
+}
+
+// HDScan is the result of scanning the chain for the used addresses of an HD
+// contract.
+type HDScan struct {
+	Used []HDAddress // Used addresses in branch and index order
+	Next [2]uint32   // First index after the last used one, per branch
+}
+
+// Addresses returns the used addresses.
+func (s *HDScan) Addresses() []string {
+	addresses := make([]string, 0, len(s.Used))
+	for _, a := range s.Used {
+		addresses = append(addresses, a.Contract.Address)
+	}
+	return addresses
+}
+
+// RedeemScripts returns the redeem scripts of the used addresses by address.
+func (s *HDScan) RedeemScripts() map[string][]byte {
+	redeemScripts := make(map[string][]byte, len(s.Used))
+	for _, a := range s.Used {
+		redeemScripts[a.Contract.Address] = a.Contract.RedeemScript
+	}
+	return redeemScripts
+}
+
+// Derivations returns the used address every input of the provided
+// transaction spends from.
+func (s *HDScan) Derivations(tx *wire.MsgTx) ([]HDAddress, error) {
+	status, err := SigningStatus(tx)
+	if err != nil {
+		return nil, err
+	}
+	derivations := make([]HDAddress, 0, len(status))
+	for k := range status {
+		var found bool
+		for _, a := range s.Used {
+			if string(a.Contract.RedeemScript) ==
+				string(status[k].RedeemScript) {
+				derivations = append(derivations, a)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("input %v: not a used address "+
+				"of the contract", k)
+		}
+	}
+	return derivations, nil
+}
+
+// UsageExplorer is implemented by explorers that can tell whether an address
+// has ever received funds. Explorers that do not implement it are asked for
+// the utxos of the address instead, which misses addresses that have been
+// spent from.
+type UsageExplorer interface {
+	Used(ctx context.Context, address string) (bool, error)
+}
+
+// used returns true if the provided address has been used.
+func (c *Client) used(ctx context.Context, address string) (bool, error) {
+	e, err := c.explorer()
+	if err != nil {
+		return false, err
+	}
+	if ue, ok := e.(UsageExplorer); ok {
+		return ue.Used(ctx, address)
+	}
+	utxos, err := e.Utxos(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	return len(utxos) > 0, nil
+}
+
+// ScanHD derives the addresses of both branches of the provided contract
+// until gapLimit consecutive addresses are unused.
+func (c *Client) ScanHD(ctx context.Context, h *HDContract, gapLimit int) (*HDScan, error) {
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+	var scan HDScan
+	for _, branch := range []uint32{ExternalBranch, InternalBranch} {
+		for index, gap := uint32(0), 0; gap < gapLimit; index++ {
+			contract, err := h.Derive(branch, index)
+			if err != nil {
+				return nil, err
+			}
+			used, err := c.used(ctx, contract.Address)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", contract.Address,
+					err)
+			}
+			if !used {
+				gap++
+				continue
+			}
+			gap = 0
+			scan.Used = append(scan.Used, HDAddress{
+				Branch:   branch,
+				Index:    index,
+				Contract: contract,
+			})
+			scan.Next[branch] = index + 1
+		}
+	}
+	return &scan, nil
+}
+
+// PrepareHDSign makes the wallet able to sign the inputs of the provided
+// transaction that spend from an HD contract. The extended public key of the
+// wallet account must be one of the contract keys. The wallet derives the
+// account keys up to the index of every input and imports their redeem
+// scripts. It returns the derivation of every input. The wallet only derives
+// the account branches, contracts with a path can not be signed.
+func (c *Client) PrepareHDSign(ctx context.Context, tx *wire.MsgTx, h *HDContract, scan *HDScan, account string) ([]HDAddress, error) {
+	if len(h.Path) > 0 {
+		return nil, fmt.Errorf("the wallet can not sign for keys "+
+			"derived below path %v, only for the account branches",
+			HDPathString(h.Path))
+	}
+	w, err := c.wallet()
+	if err != nil {
+		return nil, err
+	}
+	derivations, err := scan.Derivations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var xpub string
+	err = w.Call(ctx, "getmasterpubkey", &xpub, account)
+	if err != nil {
+		return nil, fmt.Errorf("getmasterpubkey: %v", err)
+	}
+	var cosigner bool
+	for _, k := range h.XPubs {
+		cosigner = cosigner || k == xpub
+	}
+	if !cosigner {
+		return nil, fmt.Errorf("wallet account %v is not a cosigner",
+			account)
+	}
+
+	imported := make(map[string]bool, len(derivations))
+	for _, d := range derivations {
+		if imported[d.Contract.Address] {
+			continue
+		}
+		err = w.Call(ctx, "accountsyncaddressindex", nil, account,
+			d.Branch, d.Index)
+		if err != nil {
+			return nil, fmt.Errorf("accountsyncaddressindex: %v", err)
+		}
+		err = w.Call(ctx, "importscript", nil,
+			hex.EncodeToString(d.Contract.RedeemScript), false)
+		if err != nil {
+			return nil, fmt.Errorf("importscript: %v", err)
+		}
+		imported[d.Contract.Address] = true
+	}
+	return derivations, nil
+}
@@ -0,0 +1,257 @@
+package multisig
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/hdkeychain/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+// testXPubs returns n account extended public keys.
+func testXPubs(t *testing.T, n int, params *chaincfg.Params) []string {
+	t.Helper()
+	xpubs := make([]string, 0, n)
+	for _, seed := range testKeys(n) {
+		master, err := hdkeychain.NewMaster(seed, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		account, err := master.Child(hdkeychain.HardenedKeyStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		xpubs = append(xpubs, account.Neuter().String())
+	}
+	return xpubs
+}
+
+func TestNewHDContract(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	xpubs := testXPubs(t, 3, params)
+	mainnet := testXPubs(t, 1, chaincfg.MainNetParams())
+	master, err := hdkeychain.NewMaster(testKeys(1)[0], params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		m     int
+		xpubs []string
+		err   string
+	}{
+		{2, xpubs, ""},
+		{4, xpubs, "invalid number of signatures: 4 of 3"},
+		{1, nil, "invalid number of keys"},
+		{1, []string{xpubs[0], xpubs[0]}, "duplicate key"},
+		{1, mainnet, "not a testnet3 extended key"},
+		{1, []string{master.String()}, "private extended key"},
+	}
+	for _, tt := range tests {
+		_, err := NewHDContract(tt.m, tt.xpubs, nil, params)
+		if tt.err == "" && err != nil {
+			t.Fatal(err)
+		}
+		if tt.err != "" && (err == nil ||
+			!strings.Contains(err.Error(), tt.err)) {
+			t.Fatalf("got %v, want %v", err, tt.err)
+		}
+	}
+
+	// Every index has its own 2 of 3 contract.
+	h, err := NewHDContract(2, xpubs, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, branch := range []uint32{ExternalBranch, InternalBranch} {
+		for index := uint32(0); index < 3; index++ {
+			contract, err := h.Derive(branch, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contract.M != 2 || contract.N != 3 {
+				t.Fatalf("got %v of %v", contract.M, contract.N)
+			}
+			if seen[contract.Address] {
+				t.Fatalf("address reused: %v", contract.Address)
+			}
+			seen[contract.Address] = true
+		}
+	}
+}
+
+func TestParseHDPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []uint32
+		err  string
+	}{
+		{"", nil, ""},
+		{"0", []uint32{0}, ""},
+		{"0/5", []uint32{0, 5}, ""},
+		{"0'/5", nil, "hardened step 0'"},
+		{"5h", nil, "hardened step 5h"},
+		{"2147483648", nil, "hardened step 2147483648"},
+		{"0//5", nil, "invalid path"},
+		{"m/0", nil, "invalid path"},
+	}
+	for _, tt := range tests {
+		path, err := ParseHDPath(tt.path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("%q: got %v, want %v", tt.path, err,
+					tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tt.path, err)
+		}
+		if len(path) != len(tt.want) || HDPathString(path) != tt.path {
+			t.Fatalf("%q: got %v", tt.path, path)
+		}
+	}
+}
+
+// TestHDContractPath verifies that a contract with a path derives the same
+// addresses as one made of the extended keys at that path.
+func TestHDContractPath(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	xpubs := testXPubs(t, 3, params)
+	h, err := NewHDContract(2, xpubs, []uint32{0, 5}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived := make([]string, 0, len(xpubs))
+	for _, xpub := range xpubs {
+		key, err := hdkeychain.NewKeyFromString(xpub, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range []uint32{0, 5} {
+			key, err = key.Child(step)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		derived = append(derived, key.String())
+	}
+	below, err := NewHDContract(2, derived, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewHDContract(2, xpubs, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, branch := range []uint32{ExternalBranch, InternalBranch} {
+		a, err := h.Derive(branch, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := below.Derive(branch, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := plain.Derive(branch, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Address != b.Address || a.Address == c.Address {
+			t.Fatalf("got %v, want %v, not %v", a.Address,
+				b.Address, c.Address)
+		}
+	}
+
+	_, err = NewHDContract(2, xpubs, []uint32{hdkeychain.HardenedKeyStart},
+		params)
+	if err == nil || !strings.Contains(err.Error(), "hardened") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestScanHD(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	h, err := NewHDContract(2, testXPubs(t, 3, params), nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deposits at external 0 and 3, change at internal 0.
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxOut(wire.NewTxOut(1e8, []byte{0x51}))
+	var used []*Contract
+	for _, d := range [][2]uint32{{0, 0}, {0, 3}, {1, 0}} {
+		contract, err := h.Derive(d[0], d[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, contract)
+		fundingTx.AddTxOut(p2shTx(t, contract.RedeemScript,
+			1e8).TxOut[0])
+	}
+	e := &testExplorer{utxos: make(map[string][]it.AddressTxnOutput)}
+	fundingTxID := e.addTx(fundingTx)
+	for k, contract := range used {
+		e.utxos[contract.Address] = []it.AddressTxnOutput{{
+			TxnID:         fundingTxID,
+			Vout:          uint32(k + 1),
+			Satoshis:      1e8,
+			Confirmations: 10,
+		}}
+	}
+	c := New(Config{Params: params, Explorer: e})
+	ctx := context.Background()
+
+	// The gap between index 0 and 3 is only found with a large enough
+	// gap limit.
+	scan, err := c.ScanHD(ctx, h, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Used) != 2 || scan.Next != [2]uint32{1, 1} {
+		t.Fatalf("got %+v", scan)
+	}
+	scan, err = c.ScanHD(ctx, h, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.Used) != 3 || scan.Next != [2]uint32{4, 1} {
+		t.Fatalf("got %+v", scan)
+	}
+	for k, address := range scan.Addresses() {
+		if address != used[k].Address {
+			t.Fatalf("got %v, want %v", address, used[k].Address)
+		}
+	}
+	if len(scan.RedeemScripts()) != 3 {
+		t.Fatalf("got %v", scan.RedeemScripts())
+	}
+
+	// The derivation of every input is known.
+	utxos, err := c.Utxos(ctx, &UtxoRequest{Addresses: scan.Addresses()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := allUtxos(utxos)
+	tx, _, err := c.unsignedTx(ctx, scan.RedeemScripts(), list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derivations, err := scan.Derivations(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, d := range derivations {
+		if d.Contract.Address != list[k].Address {
+			t.Fatalf("input %v: got %v/%v", k, d.Branch, d.Index)
+		}
+	}
+	scan.Used = scan.Used[:1]
+	if _, err := scan.Derivations(tx); err == nil {
+		t.Fatal("expected unknown input")
+	}
+}
@@ -0,0 +1,132 @@
+package multisig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// signedMessageMagic is prepended to messages by dcrwallet signmessage.
+const signedMessageMagic = "Decred Signed Message:\n"
+
+// KeyStatement is a cosigner public key address together with the signature
+// of its KeyMessage, which proves that the cosigner controls the private key
+// and intends to use it in the named contract.
+type KeyStatement struct {
+	Key       string // Public key address
+	Signature []byte // Compact signature of KeyMessage
+}
+
+// KeyMessage returns the message a cosigner signs to prove possession of key
+// for the named contract.
+func KeyMessage(key, contract string) string {
+	return fmt.Sprintf("dcrms key %v for contract %v", key, contract)
+}
+
+// String returns the statement as key:signature, the signature being base64
+// encoded like dcrwallet signmessage returns it.
+func (ks *KeyStatement) String() string {
+	return ks.Key + ":" + base64.StdEncoding.EncodeToString(ks.Signature)
+}
+
+// ParseKeyStatement parses a statement as returned by KeyStatement.String.
+func ParseKeyStatement(s string) (*KeyStatement, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("not a key statement: %v", s)
+	}
+	sig, err := base64.StdEncoding.DecodeString(s[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key statement signature: %v", s)
+	}
+	return &KeyStatement{Key: s[:i], Signature: sig}, nil
+}
+
+// Verify returns an error unless the statement carries a valid key that
+// signed the KeyMessage of the named contract.
+func (ks *KeyStatement) Verify(contract string, params *chaincfg.Params) error {
+	pk, err := CheckKey(ks.Key, params)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	wire.WriteVarString(&b, 0, signedMessageMagic)
+	wire.WriteVarString(&b, 0, KeyMessage(ks.Key, contract))
+	signer, _, err := ecdsa.RecoverCompact(ks.Signature,
+		chainhash.HashB(b.Bytes()))
+	if err != nil || !signer.IsEqual(pk.PubKey()) {
+		return fmt.Errorf("key %v did not sign contract %q", ks.Key,
+			contract)
+	}
+	return nil
+}
+
+// CheckKey returns the public key address of key after verifying that it is
+// a compressed secp256k1 public key address of the network. Hex encoded keys
+// are refused since they do not identify the network.
+func CheckKey(key string, params *chaincfg.Params) (*dcrutil.AddressSecpPubKey, error) {
+	if b, err := hex.DecodeString(key); err == nil {
+		if len(b) == 65 && b[0] == 0x04 {
+			return nil, fmt.Errorf("uncompressed public key: %v",
+				key)
+		}
+		return nil, fmt.Errorf("hex public key, use a %v public key "+
+			"address: %v", params.Name, key)
+	}
+	addr, err := dcrutil.DecodeAddress(key, params)
+	if err != nil {
+		return nil, fmt.Errorf("not a %v address: %v", params.Name, key)
+	}
+	pk, ok := addr.(*dcrutil.AddressSecpPubKey)
+	if !ok {
+		return nil, fmt.Errorf("not a secp256k1 public key address: %v",
+			key)
+	}
+	if pk.Format() != dcrutil.PKFCompressed {
+		return nil, fmt.Errorf("uncompressed public key: %v", key)
+	}
+	return pk, nil
+}
+
+// CheckKeys checks every key with CheckKey and refuses duplicate keys.
+func CheckKeys(keys []string, params *chaincfg.Params) error {
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		pk, err := CheckKey(key, params)
+		if err != nil {
+			return err
+		}
+		s := string(pk.ScriptAddress())
+		if seen[s] {
+			return fmt.Errorf("duplicate key: %v", key)
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// VerifyKeyStatements verifies that every statement was signed for the
+// named contract and returns the keys of the statements.
+func VerifyKeyStatements(statements []string, contract string, params *chaincfg.Params) ([]string, error) {
+	keys := make([]string, 0, len(statements))
+	for _, s := range statements {
+		ks, err := ParseKeyStatement(s)
+		if err != nil {
+			return nil, err
+		}
+		err = ks.Verify(contract, params)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, ks.Key)
+	}
+	return keys, CheckKeys(keys, params)
+}
@@ -0,0 +1,107 @@
+package multisig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// signKey returns the statement of the provided private key for contract
+// the way dcrwallet signmessage signs it.
+func signKey(t *testing.T, k []byte, contract string, params *chaincfg.Params) *KeyStatement {
+	t.Helper()
+	priv := secp256k1.PrivKeyFromBytes(k)
+	pk, err := dcrutil.NewAddressSecpPubKeyCompressed(priv.PubKey(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	wire.WriteVarString(&b, 0, "Decred Signed Message:\n")
+	wire.WriteVarString(&b, 0, KeyMessage(pk.String(), contract))
+	return &KeyStatement{
+		Key:       pk.String(),
+		Signature: ecdsa.SignCompact(priv, chainhash.HashB(b.Bytes()), true),
+	}
+}
+
+func TestKeyStatements(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	keys := testKeys(3)
+	var statements []string
+	for _, k := range keys {
+		statements = append(statements,
+			signKey(t, k, "escrow", params).String())
+	}
+	got, err := VerifyKeyStatements(statements, "escrow", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range got {
+		if !strings.HasPrefix(statements[k], got[k]+":") {
+			t.Fatalf("got %v, want %v", got[k], statements[k])
+		}
+	}
+
+	// Another key's signature, another contract name, a duplicate and
+	// another network are all refused.
+	stolen := signKey(t, keys[0], "escrow", params)
+	stolen.Key = signKey(t, keys[1], "escrow", params).Key
+	mainnet := signKey(t, keys[2], "escrow", chaincfg.MainNetParams())
+	uncompressed := hex.EncodeToString(secp256k1.PrivKeyFromBytes(
+		keys[0]).PubKey().SerializeUncompressed())
+	tests := []struct {
+		statements []string
+		contract   string
+		err        string
+	}{
+		{statements, "payroll", `did not sign contract "payroll"`},
+		{[]string{stolen.String()}, "escrow", "did not sign"},
+		{[]string{statements[0], statements[0]}, "escrow",
+			"duplicate key"},
+		{[]string{mainnet.String()}, "escrow", "not a testnet3 address"},
+		{[]string{got[0]}, "escrow", "not a key statement"},
+		{[]string{got[0] + ":!"}, "escrow", "invalid key statement"},
+		{[]string{uncompressed + ":"}, "escrow", "uncompressed public key"},
+	}
+	for _, tt := range tests {
+		_, err := VerifyKeyStatements(tt.statements, tt.contract, params)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: got %v, want %v", tt.statements, err, tt.err)
+		}
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+	key := signKey(t, testKeys(1)[0], "", params).Key
+	compressed := hex.EncodeToString(secp256k1.PrivKeyFromBytes(
+		testKeys(1)[0]).PubKey().SerializeCompressed())
+	tests := []struct {
+		keys []string
+		err  string
+	}{
+		{[]string{key}, ""},
+		{[]string{key, key}, "duplicate key"},
+		{[]string{compressed}, "hex public key"},
+		{[]string{escrowAddr}, "not a secp256k1 public key address"},
+		{[]string{"a"}, "not a testnet3 address"},
+	}
+	for _, tt := range tests {
+		err := CheckKeys(tt.keys, params)
+		if tt.err == "" && err != nil {
+			t.Fatalf("%v: %v", tt.keys, err)
+		}
+		if tt.err != "" && (err == nil ||
+			!strings.Contains(err.Error(), tt.err)) {
+			t.Fatalf("%v: got %v, want %v", tt.keys, err, tt.err)
+		}
+	}
+}
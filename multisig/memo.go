@@ -0,0 +1,77 @@
+package multisig
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/decred/dcrd/txscript/v3"
+)
+
+// ParseMemo returns the memo data of the provided memo. Memos prefixed with
+// 0x are hex encoded, all others are used verbatim as UTF-8.
+func ParseMemo(memo string) ([]byte, error) {
+	data := []byte(memo)
+	if strings.HasPrefix(memo, "0x") {
+		var err error
+		data, err = hex.DecodeString(memo[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex memo: %v", err)
+		}
+	} else if !utf8.ValidString(memo) {
+		return nil, fmt.Errorf("memo is not valid UTF-8")
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty memo")
+	}
+	return data, nil
+}
+
+// MemoScript returns the null data script that carries the provided memo.
+func MemoScript(memo []byte) ([]byte, error) {
+	if len(memo) == 0 {
+		return nil, fmt.Errorf("empty memo")
+	}
+	if len(memo) > txscript.MaxDataCarrierSize {
+		return nil, fmt.Errorf("memo too large: %v bytes, maximum %v",
+			len(memo), txscript.MaxDataCarrierSize)
+	}
+	return txscript.GenerateProvablyPruneableOut(memo)
+}
+
+// TxMemo returns the memo carried by a null data output script.
+func TxMemo(pkScript []byte) ([]byte, bool) {
+	if txscript.GetScriptClass(0, pkScript, false) != txscript.NullDataTy {
+		return nil, false
+	}
+	pushes, err := txscript.PushedData(pkScript)
+	if err != nil {
+		return nil, false
+	}
+	var memo []byte
+	for _, push := range pushes {
+		memo = append(memo, push...)
+	}
+	return memo, true
+}
+
+// MemoString returns a printable representation of a memo. Printable UTF-8
+// memos are quoted, all others are hex encoded.
+func MemoString(memo []byte) string {
+	if utf8.Valid(memo) {
+		printable := true
+		for _, r := range string(memo) {
+			if !unicode.IsPrint(r) {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return strconv.Quote(string(memo))
+		}
+	}
+	return "0x" + hex.EncodeToString(memo)
+}
@@ -0,0 +1,53 @@
+package multisig
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMemo(t *testing.T) {
+	tests := []struct {
+		memo    string
+		want    []byte
+		wantErr bool
+	}{
+		{"INV-2020-0042", []byte("INV-2020-0042"), false},
+		{"0xdeadbeef", []byte{0xde, 0xad, 0xbe, 0xef}, false},
+		{"0xdeadbee", nil, true},
+		{"", nil, true},
+		{"0x", nil, true},
+		{string(bytes.Repeat([]byte{'a'}, 256)),
+			bytes.Repeat([]byte{'a'}, 256), false},
+		{string(bytes.Repeat([]byte{'a'}, 257)), nil, true},
+	}
+	for _, tt := range tests {
+		data, err := ParseMemo(tt.memo)
+		var script []byte
+		if err == nil {
+			script, err = MemoScript(data)
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", tt.memo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tt.memo, err)
+		}
+		memo, ok := TxMemo(script)
+		if !ok {
+			t.Fatalf("%q: not a memo script", tt.memo)
+		}
+		if !bytes.Equal(memo, tt.want) {
+			t.Fatalf("%q: got %x, want %x", tt.memo, memo, tt.want)
+		}
+	}
+
+	if got := MemoString([]byte("INV-1")); got != `"INV-1"` {
+		t.Fatalf("got %v", got)
+	}
+	if got := MemoString([]byte{0x00, 0xff}); got != "0x00ff" {
+		t.Fatalf("got %v", got)
+	}
+}
@@ -0,0 +1,91 @@
+// Package multisig creates Decred m of n multisig contracts and builds,
+// inspects, signs and broadcasts the transactions that spend from them.
+//
+// Contracts are created and signed by dcrwallet and chain data is looked up
+// with a dcrdata/insight explorer. Both are provided by the caller through the
+// Wallet and Explorer interfaces, which makes it possible to add caching,
+// proxies or test doubles. Nothing is printed, all results are returned.
+package multisig
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	it "github.com/decred/dcrdata/api/types"
+	"github.com/juju/loggo"
+)
+
+const (
+	// DefaultFetchWorkers is the number of previous transactions that are
+	// fetched concurrently when none is configured.
+	DefaultFetchWorkers = 8
+)
+
+var (
+	log = loggo.GetLogger("dcrms.multisig")
+)
+
+// Wallet performs dcrwallet JSON-RPC calls. A *wsrpc.Client satisfies this
+// interface.
+type Wallet interface {
+	Call(ctx context.Context, method string, res interface{}, args ...interface{}) error
+}
+
+// Explorer looks up chain data.
+type Explorer interface {
+	// Utxos returns all unspent outputs, regardless of confirmations,
+	// that pay to the provided address.
+	Utxos(ctx context.Context, address string) ([]it.AddressTxnOutput, error)
+
+	// RawTx returns the hex encoded transaction with the provided id.
+	// Confirmed is true when the transaction is known to be mined, in
+	// which case the result may be cached.
+	RawTx(ctx context.Context, txID *chainhash.Hash, confirmed bool) ([]byte, error)
+}
+
+// Config is the configuration of a Client.
+type Config struct {
+	Params   *chaincfg.Params
+	Wallet   Wallet
+	Explorer Explorer
+
+	// FetchWorkers is the number of previous transactions that are
+	// fetched concurrently. DefaultFetchWorkers is used when zero.
+	FetchWorkers int
+
+	// Progress, when set, is called whenever another previous
+	// transaction has been fetched.
+	Progress func(done, total int)
+}
+
+// Client creates contracts and builds transactions with the configured wallet
+// and explorer.
+type Client struct {
+	cfg Config
+}
+
+// New returns a client for the provided configuration. Params must be set.
+func New(cfg Config) *Client {
+	if cfg.FetchWorkers <= 0 {
+		cfg.FetchWorkers = DefaultFetchWorkers
+	}
+	return &Client{cfg: cfg}
+}
+
+// wallet returns the configured wallet or an error if there is none.
+func (c *Client) wallet() (Wallet, error) {
+	if c.cfg.Wallet == nil {
+		return nil, fmt.Errorf("no wallet configured")
+	}
+	return c.cfg.Wallet, nil
+}
+
+// explorer returns the configured explorer or an error if there is none.
+func (c *Client) explorer() (Explorer, error) {
+	if c.cfg.Explorer == nil {
+		return nil, fmt.Errorf("no explorer configured")
+	}
+	return c.cfg.Explorer, nil
+}
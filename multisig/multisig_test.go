@@ -0,0 +1,182 @@
+package multisig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+const (
+	fundingTxID = "ce365de0a58a8ad89d5fd173c0d6191bf4c111448ea112661c8200eb5ca0fb67"
+	escrowAddr  = "TcerhCZvVVzjYKQoKUybohE75ZxPgPqManG"
+
+	// escrowScript is the 2 of 3 redeem script of escrowAddr.
+	escrowScript = "52210254cf9dc4798eabd6dd1e34a6ea2a4d387bc6b766b1c7360" +
+		"9a27d12da3ab9d9772102b687ff58749bd90dd50b37776312d73e91549dccd" +
+		"f81327bda9cb42df855f2652103a2d4d194f1369e147dc88bbc5d7c280ca32" +
+		"3da1b660cc7e7782db59db491fd2e53ae"
+)
+
+// testExplorer is an explorer that knows a fixed set of utxos and
+// transactions.
+type testExplorer struct {
+	utxos map[string][]it.AddressTxnOutput
+	txs   map[chainhash.Hash]*wire.MsgTx
+}
+
+// addTx adds the provided transaction and returns its id.
+func (e *testExplorer) addTx(tx *wire.MsgTx) string {
+	if e.txs == nil {
+		e.txs = make(map[chainhash.Hash]*wire.MsgTx)
+	}
+	txHash := tx.TxHash()
+	e.txs[txHash] = tx
+	return txHash.String()
+}
+
+func (e *testExplorer) Utxos(ctx context.Context, address string) ([]it.AddressTxnOutput, error) {
+	return e.utxos[address], nil
+}
+
+func (e *testExplorer) RawTx(ctx context.Context, txID *chainhash.Hash, confirmed bool) ([]byte, error) {
+	tx, ok := e.txs[*txID]
+	if !ok {
+		return nil, fmt.Errorf("unknown tx: %v", txID)
+	}
+	b, err := tx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// p2shTx returns a transaction whose outputs pay the provided values to the
+// script hash of the redeem script.
+func p2shTx(t *testing.T, redeemScript []byte, values ...int64) *wire.MsgTx {
+	t.Helper()
+	script, err := p2shScript(redeemScript, chaincfg.TestNet3Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx()
+	for _, v := range values {
+		tx.AddTxOut(wire.NewTxOut(v, script))
+	}
+	return tx
+}
+
+// multiOutputUtxos returns utxos of a single transaction that paid the same
+// address twice, e.g. a batched exchange withdrawal.
+func multiOutputUtxos() []it.AddressTxnOutput {
+	return []it.AddressTxnOutput{
+		{
+			Address:       escrowAddr,
+			TxnID:         fundingTxID,
+			Vout:          0,
+			Amount:        1,
+			Satoshis:      1e8,
+			Confirmations: 10,
+		},
+		{
+			Address:       escrowAddr,
+			TxnID:         fundingTxID,
+			Vout:          2,
+			Amount:        2,
+			Satoshis:      2e8,
+			Confirmations: 10,
+		},
+	}
+}
+
+// testKeys returns n deterministic private keys.
+func testKeys(n int) [][]byte {
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		k := sha256.Sum256([]byte{byte(i)})
+		keys = append(keys, k[:])
+	}
+	return keys
+}
+
+// testMultisigTx returns an unsigned spend of a 2 of 3 contract made of the
+// provided keys.
+func testMultisigTx(t *testing.T, keys [][]byte) (*wire.MsgTx, []byte) {
+	t.Helper()
+	params := chaincfg.TestNet3Params()
+	pubKeys := make([]*dcrutil.AddressSecpPubKey, 0, len(keys))
+	for _, k := range keys {
+		pk := secp256k1.PrivKeyFromBytes(k).PubKey()
+		addr, err := dcrutil.NewAddressSecpPubKeyCompressed(pk, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys = append(pubKeys, addr)
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx()
+	for i := uint32(0); i < 2; i++ {
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: i}, 1e8,
+			redeemScript))
+	}
+	tx.AddTxOut(wire.NewTxOut(19e7, []byte{txscript.OP_TRUE}))
+	return tx, redeemScript
+}
+
+// partialSign returns a copy of tx with every input signed by key only, as
+// dcrwallet does for a single cosigner.
+func partialSign(t *testing.T, tx *wire.MsgTx, redeemScript, key []byte) *wire.MsgTx {
+	t.Helper()
+	signed := tx.Copy()
+	for k := range signed.TxIn {
+		sig, err := txscript.RawTxInSignature(tx, k, redeemScript,
+			txscript.SigHashAll, key, dcrec.STEcdsaSecp256k1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		script, err := txscript.NewScriptBuilder().AddData(sig).
+			AddOp(txscript.OP_0).AddData(redeemScript).Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed.TxIn[k].SignatureScript = script
+	}
+	return signed
+}
+
+func TestNoDependencies(t *testing.T) {
+	c := New(Config{Params: chaincfg.TestNet3Params()})
+	ctx := context.Background()
+	_, err := c.CreateContract(ctx, &ContractRequest{M: 1,
+		Keys: []string{"a"}})
+	if err == nil {
+		t.Fatal("expected no wallet error")
+	}
+	_, err = c.Utxos(ctx, &UtxoRequest{Addresses: []string{escrowAddr}})
+	if err == nil {
+		t.Fatal("expected no explorer error")
+	}
+}
@@ -0,0 +1,256 @@
+package multisig
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	jt "decred.org/dcrwallet/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// DecodeTx decodes a hex encoded transaction.
+func DecodeTx(s string) (*wire.MsgTx, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeString %v", err)
+	}
+	tx := wire.NewMsgTx()
+	err = tx.FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("FromBytes: %v", err)
+	}
+	return tx, nil
+}
+
+// EncodeTx returns the hex encoding of the provided transaction.
+func EncodeTx(tx *wire.MsgTx) (string, error) {
+	b, err := tx.Bytes()
+	if err != nil {
+		return "", fmt.Errorf("serialize: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// InputStatus is the signing status of a single multisig input.
+type InputStatus struct {
+	OutPoint     wire.OutPoint
+	RedeemScript []byte
+	Signatures   int // Signatures present
+	Required     int // Signatures required
+}
+
+// SigningStatus returns the signing status of every input of a multisig
+// transaction. Unsigned inputs carry the bare redeem script, signed inputs
+// carry the signatures followed by a push of the redeem script.
+func SigningStatus(tx *wire.MsgTx) ([]InputStatus, error) {
+	status := make([]InputStatus, 0, len(tx.TxIn))
+	for k, txIn := range tx.TxIn {
+		redeemScript := txIn.SignatureScript
+		signatures := 0
+		if !txscript.IsMultisigScript(redeemScript) {
+			pushes, err := txscript.PushedData(redeemScript)
+			if err != nil || len(pushes) == 0 {
+				return nil, fmt.Errorf("input %v: invalid "+
+					"signature script", k)
+			}
+			redeemScript = pushes[len(pushes)-1]
+			for _, push := range pushes[:len(pushes)-1] {
+				if len(push) != 0 {
+					signatures++
+				}
+			}
+		}
+		_, m, err := txscript.CalcMultiSigStats(redeemScript)
+		if err != nil {
+			return nil, fmt.Errorf("input %v: %v", k, err)
+		}
+		status = append(status, InputStatus{
+			OutPoint:     txIn.PreviousOutPoint,
+			RedeemScript: redeemScript,
+			Signatures:   signatures,
+			Required:     m,
+		})
+	}
+	return status, nil
+}
+
+// Complete returns true if every input has all required signatures.
+func Complete(status []InputStatus) bool {
+	for _, s := range status {
+		if s.Signatures < s.Required {
+			return false
+		}
+	}
+	return true
+}
+
+// InputSignatures returns the redeem script, its public keys and the valid
+// signatures, keyed by hex encoded public key, present on the provided input.
+func InputSignatures(tx *wire.MsgTx, idx int) ([]byte, [][]byte, map[string][]byte, error) {
+	sigScript := tx.TxIn[idx].SignatureScript
+	redeemScript := sigScript
+	var sigs [][]byte
+	if !txscript.IsMultisigScript(sigScript) {
+		pushes, err := txscript.PushedData(sigScript)
+		if err != nil || len(pushes) == 0 {
+			return nil, nil, nil, fmt.Errorf("input %v: invalid "+
+				"signature script", idx)
+		}
+		redeemScript = pushes[len(pushes)-1]
+		sigs = pushes[:len(pushes)-1]
+	}
+	pubKeys, err := txscript.PushedData(redeemScript)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("input %v: %v", idx, err)
+	}
+
+	signatures := make(map[string][]byte, len(sigs))
+	for _, sig := range sigs {
+		if len(sig) < 2 {
+			continue
+		}
+		hashType := txscript.SigHashType(sig[len(sig)-1])
+		hash, err := txscript.CalcSignatureHash(redeemScript, hashType,
+			tx, idx, nil)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("input %v: %v", idx,
+				err)
+		}
+		signature, err := ecdsa.ParseDERSignature(sig[:len(sig)-1])
+		if err != nil {
+			continue
+		}
+		for _, pk := range pubKeys {
+			pubKey, err := secp256k1.ParsePubKey(pk)
+			if err != nil {
+				continue
+			}
+			if signature.Verify(hash, pubKey) {
+				signatures[hex.EncodeToString(pk)] = sig
+				break
+			}
+		}
+	}
+	return redeemScript, pubKeys, signatures, nil
+}
+
+// InputSigners returns the public keys, hex encoded and in redeem script
+// order, that produced the signatures present on the provided input.
+func InputSigners(tx *wire.MsgTx, idx int) ([]string, error) {
+	_, pubKeys, signatures, err := InputSignatures(tx, idx)
+	if err != nil {
+		return nil, err
+	}
+	var signers []string
+	for _, pk := range pubKeys {
+		k := hex.EncodeToString(pk)
+		if _, ok := signatures[k]; ok {
+			signers = append(signers, k)
+		}
+	}
+	return signers, nil
+}
+
+// MergeSignatures adds the signatures of src that are missing from dst. Both
+// transactions must be the same proposal. Signatures are ordered as their
+// public keys appear in the redeem script and missing ones are padded with
+// OP_0, which is what dcrwallet produces for partially signed inputs.
+func MergeSignatures(dst, src *wire.MsgTx) error {
+	if dst.TxHash() != src.TxHash() {
+		return fmt.Errorf("transaction mismatch: %v != %v",
+			src.TxHash(), dst.TxHash())
+	}
+	for k := range dst.TxIn {
+		redeemScript, pubKeys, sigs, err := InputSignatures(dst, k)
+		if err != nil {
+			return err
+		}
+		srcScript, _, srcSigs, err := InputSignatures(src, k)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(redeemScript, srcScript) {
+			return fmt.Errorf("input %v: redeem script mismatch", k)
+		}
+		for pk, sig := range srcSigs {
+			if _, ok := sigs[pk]; !ok {
+				sigs[pk] = sig
+			}
+		}
+		if len(sigs) == 0 {
+			continue
+		}
+
+		_, m, err := txscript.CalcMultiSigStats(redeemScript)
+		if err != nil {
+			return fmt.Errorf("input %v: %v", k, err)
+		}
+		builder := txscript.NewScriptBuilder()
+		n := 0
+		for _, pk := range pubKeys {
+			sig, ok := sigs[hex.EncodeToString(pk)]
+			if !ok || n == m {
+				continue
+			}
+			builder.AddData(sig)
+			n++
+		}
+		for ; n < m; n++ {
+			builder.AddOp(txscript.OP_0)
+		}
+		builder.AddData(redeemScript)
+		script, err := builder.Script()
+		if err != nil {
+			return fmt.Errorf("input %v: %v", k, err)
+		}
+		dst.TxIn[k].SignatureScript = script
+	}
+	return nil
+}
+
+// Sign adds the signatures of the wallet keys to the provided transaction and
+// returns the signed transaction and whether signing is complete.
+func (c *Client) Sign(ctx context.Context, tx *wire.MsgTx) (*wire.MsgTx, bool, error) {
+	w, err := c.wallet()
+	if err != nil {
+		return nil, false, err
+	}
+	txS, err := EncodeTx(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	var srtr jt.SignRawTransactionResult
+	err = w.Call(ctx, "signrawtransaction", &srtr, txS)
+	if err != nil {
+		return nil, false, err
+	}
+	signedTx, err := DecodeTx(srtr.Hex)
+	if err != nil {
+		return nil, false, err
+	}
+	return signedTx, srtr.Complete, nil
+}
+
+// Broadcast sends the provided fully signed transaction to the network and
+// returns its id.
+func (c *Client) Broadcast(ctx context.Context, tx *wire.MsgTx) (string, error) {
+	w, err := c.wallet()
+	if err != nil {
+		return "", err
+	}
+	txS, err := EncodeTx(tx)
+	if err != nil {
+		return "", err
+	}
+	var txHash string
+	err = w.Call(ctx, "sendrawtransaction", &txHash, txS)
+	if err != nil {
+		return "", err
+	}
+	return txHash, nil
+}
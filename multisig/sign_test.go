@@ -0,0 +1,119 @@
+package multisig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+func TestSigningStatus(t *testing.T) {
+	redeemScript := mustDecodeHex(t, escrowScript)
+
+	// escrowScript must hash to escrowAddr.
+	sh, err := dcrutil.NewAddressScriptHash(redeemScript,
+		chaincfg.TestNet3Params())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.Address() != escrowAddr {
+		t.Fatalf("got address %v, want %v", sh.Address(), escrowAddr)
+	}
+
+	sig := bytes.Repeat([]byte{0x30}, 71)
+	signed1, err := txscript.NewScriptBuilder().AddData(sig).
+		AddData(redeemScript).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed2, err := txscript.NewScriptBuilder().AddData(sig).AddData(sig).
+		AddData(redeemScript).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := wire.NewMsgTx()
+	for _, script := range [][]byte{redeemScript, signed1, signed2} {
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, 1e8, script))
+	}
+	status, err := SigningStatus(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range []int{0, 1, 2} {
+		if status[k].Signatures != want || status[k].Required != 2 {
+			t.Fatalf("input %v: got %v/%v, want %v/2", k,
+				status[k].Signatures, status[k].Required, want)
+		}
+	}
+
+	// Not a multisig input.
+	tx.TxIn[0].SignatureScript = []byte{txscript.OP_TRUE}
+	_, err = SigningStatus(tx)
+	if err == nil {
+		t.Fatal("expected invalid signature script error")
+	}
+}
+
+func TestMergeSignatures(t *testing.T) {
+	keys := testKeys(3)
+	tx, redeemScript := testMultisigTx(t, keys)
+
+	// Merge in reverse key order to verify redeem script ordering.
+	merged := partialSign(t, tx, redeemScript, keys[2])
+	err := MergeSignatures(merged, partialSign(t, tx, redeemScript,
+		keys[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := SigningStatus(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, s := range status {
+		if s.Signatures != 2 {
+			t.Fatalf("input %v: got %v signatures", k, s.Signatures)
+		}
+		signers, err := InputSigners(merged, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk := secp256k1.PrivKeyFromBytes(keys[0]).PubKey()
+		if len(signers) != 2 || signers[0] !=
+			hex.EncodeToString(pk.SerializeCompressed()) {
+			t.Fatalf("input %v: got signers %v", k, signers)
+		}
+	}
+
+	// The merged transaction must satisfy the redeem script.
+	pkScript, err := txscript.PayToScriptHashScript(
+		dcrutil.Hash160(redeemScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range merged.TxIn {
+		vm, err := txscript.NewEngine(pkScript, merged, k,
+			txscript.ScriptVerifyCleanStack, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = vm.Execute()
+		if err != nil {
+			t.Fatalf("input %v: %v", k, err)
+		}
+	}
+
+	// A different transaction must not be merged.
+	other := tx.Copy()
+	other.TxOut[0].Value--
+	err = MergeSignatures(merged, other)
+	if err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("got %v", err)
+	}
+}
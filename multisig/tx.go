@@ -0,0 +1,445 @@
+package multisig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"decred.org/dcrwallet/wallet/txrules"
+	"decred.org/dcrwallet/wallet/txsizes"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+// SigScriptSize returns the estimated size of the signature script that
+// redeems a multisig output with the provided redeem script.
+func SigScriptSize(redeemScript []byte) (int, error) {
+	_, m, err := txscript.CalcMultiSigStats(redeemScript)
+	if err != nil {
+		return 0, err
+	}
+	// Note that size * signers slightly overpays
+	return m*txsizes.RedeemP2PKHSigScriptSize +
+		txscript.CanonicalDataSize(redeemScript), nil
+}
+
+// inputSizes returns the estimated signature script sizes of the inputs that
+// spend the provided utxos. Every input carries its own redeem script.
+func inputSizes(redeemScripts map[string][]byte, utxoList []it.AddressTxnOutput) ([]int, error) {
+	sizes := make([]int, 0, len(utxoList))
+	for k := range utxoList {
+		size, err := SigScriptSize(redeemScripts[utxoList[k].Address])
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// EstimateFee returns the relay fee of a transaction with the provided input
+// signature script sizes, outputs and optional change script size.
+func EstimateFee(inputSizes []int, txOuts []*wire.TxOut, changeSize int) dcrutil.Amount {
+	sz := txsizes.EstimateSerializeSize(inputSizes, txOuts, changeSize)
+	return txrules.FeeForSerializeSize(txrules.DefaultRelayFeePerKb, sz)
+}
+
+// AddChange adds an output that returns the value of the inputs, foundAtoms,
+// minus the outputs and fee to changeScript. Change that would be dust is
+// not added and goes to the miners instead. It returns the fee and change.
+func AddChange(unsignedTx *wire.MsgTx, inputSizes []int, foundAtoms dcrutil.Amount, changeScript []byte) (dcrutil.Amount, dcrutil.Amount, error) {
+	var outValue dcrutil.Amount
+	for _, txOut := range unsignedTx.TxOut {
+		outValue += dcrutil.Amount(txOut.Value)
+	}
+	fee := EstimateFee(inputSizes, unsignedTx.TxOut, len(changeScript))
+	change := foundAtoms - outValue - fee
+	if change > 0 && !txrules.IsDustAmount(change, len(changeScript),
+		txrules.DefaultRelayFeePerKb) {
+		unsignedTx.AddTxOut(wire.NewTxOut(int64(change), changeScript))
+		return fee, change, nil
+	}
+
+	// Without change the transaction is smaller.
+	fee = EstimateFee(inputSizes, unsignedTx.TxOut, 0)
+	if foundAtoms-outValue < fee {
+		return 0, 0, fmt.Errorf("insufficient funds: have %v, need %v "+
+			"plus fee %v", foundAtoms, outValue, fee)
+	}
+	return foundAtoms - outValue, 0, nil
+}
+
+// SetTxTiming sets the expiry and lock time of the provided transaction. A
+// lock time is only enforced when at least one input is not final.
+func SetTxTiming(tx *wire.MsgTx, expiry, lockTime uint32) {
+	tx.Expiry = expiry
+	tx.LockTime = lockTime
+	if lockTime == 0 {
+		return
+	}
+	for _, txIn := range tx.TxIn {
+		txIn.Sequence = wire.MaxTxInSequenceNum - 1
+	}
+}
+
+// unsignedTx returns a transaction that spends the provided utxos and the
+// estimated sizes of the signature scripts of its inputs.
+func (c *Client) unsignedTx(ctx context.Context, known map[string][]byte, utxoList []it.AddressTxnOutput) (*wire.MsgTx, []int, error) {
+	// Get redeem scripts
+	redeemScripts, err := c.redeemScripts(ctx, known, utxoList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get previous outpoints
+	txIns, err := c.assembleTxIns(ctx, redeemScripts, utxoList)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getPrevOutpoints: %v", err)
+	}
+
+	// Assemble tx
+	unsignedTx := wire.NewMsgTx()
+	for k := range txIns {
+		unsignedTx.AddTxIn(txIns[k])
+	}
+
+	inputSizes, err := inputSizes(redeemScripts, utxoList)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unsignedTx, inputSizes, nil
+}
+
+// payToScript returns the output script that pays to the provided address.
+func (c *Client) payToScript(address string) ([]byte, error) {
+	addr, err := dcrutil.DecodeAddress(address, c.cfg.Params)
+	if err != nil {
+		return nil, err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, fmt.Errorf("PayToAddrScript: %v", err)
+	}
+	return script, nil
+}
+
+// TxRequest describes a payment from one or more multisig addresses.
+type TxRequest struct {
+	UtxoRequest
+
+	To     string         // Destination address
+	Change string         // Change address, the first address when empty
+	Amount dcrutil.Amount // Amount paid to the destination
+	Memo   []byte         // Optional memo, see MemoScript
+
+	Expiry   uint32 // Absolute expiry height, zero means none
+	LockTime uint32 // Zero means none
+}
+
+// TxResult is an unsigned transaction.
+type TxResult struct {
+	Tx     *wire.MsgTx
+	Inputs []it.AddressTxnOutput // Spent utxos in input order
+	Fee    dcrutil.Amount
+	Change dcrutil.Amount // Zero when there is no change output
+}
+
+// BuildTx returns an unsigned transaction that pays the requested amount.
+// Utxos are selected in outpoint order until they cover the amount and the fee
+// of spending them, unless inputs are explicitly requested in which case all
+// of them are spent. Change that would be dust goes to the miners.
+func (c *Client) BuildTx(ctx context.Context, req *TxRequest) (*TxResult, error) {
+	if len(req.Addresses) == 0 {
+		return nil, fmt.Errorf("no multisig address")
+	}
+	changeAddress := req.Change
+	if changeAddress == "" {
+		changeAddress = req.Addresses[0]
+	}
+	changeScript, err := c.payToScript(changeAddress)
+	if err != nil {
+		return nil, err
+	}
+	script, err := c.payToScript(req.To)
+	if err != nil {
+		return nil, err
+	}
+	var memo []byte
+	if req.Memo != nil {
+		memo, err = MemoScript(req.Memo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Find all utxos
+	utxos, err := c.Utxos(ctx, &req.UtxoRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Outputs
+	txOuts := []*wire.TxOut{wire.NewTxOut(int64(req.Amount), script)}
+	if memo != nil {
+		txOuts = append(txOuts, wire.NewTxOut(0, memo))
+	}
+
+	// Select utxos, explicitly selected inputs are all spent
+	utxoList, foundAtoms := allUtxos(utxos)
+	if len(utxoList) == 0 {
+		return nil, fmt.Errorf("0 utxos found to assemble transaction")
+	}
+	redeemScripts, err := c.redeemScripts(ctx, req.RedeemScripts, utxoList)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Inputs) == 0 {
+		// Every input adds to the fee, keep selecting until the fee
+		// of a transaction with change is covered as well.
+		sizes := make(map[string]int, len(redeemScripts))
+		for address, redeemScript := range redeemScripts {
+			sizes[address], err = SigScriptSize(redeemScript)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fee := func(selected []it.AddressTxnOutput) dcrutil.Amount {
+			inputSizes := make([]int, 0, len(selected))
+			for k := range selected {
+				inputSizes = append(inputSizes,
+					sizes[selected[k].Address])
+			}
+			return EstimateFee(inputSizes, txOuts, len(changeScript))
+		}
+		utxoList, foundAtoms = SelectUtxos(utxos, req.Amount, fee)
+	}
+	if foundAtoms <= req.Amount {
+		return nil, fmt.Errorf("not enough total value: %v", foundAtoms)
+	}
+
+	unsignedTx, inputSizes, err := c.unsignedTx(ctx, redeemScripts,
+		utxoList)
+	if err != nil {
+		return nil, err
+	}
+	for _, txOut := range txOuts {
+		unsignedTx.AddTxOut(txOut)
+	}
+
+	// Change
+	fee, change, err := AddChange(unsignedTx, inputSizes, foundAtoms,
+		changeScript)
+	if err != nil {
+		return nil, err
+	}
+	SetTxTiming(unsignedTx, req.Expiry, req.LockTime)
+
+	return &TxResult{
+		Tx:     unsignedTx,
+		Inputs: utxoList,
+		Fee:    fee,
+		Change: change,
+	}, nil
+}
+
+// SweepRequest describes a transaction that spends every utxo of one or more
+// multisig addresses.
+type SweepRequest struct {
+	UtxoRequest
+
+	To   string // Destination address
+	Memo []byte // Optional memo, see MemoScript
+
+	Expiry   uint32 // Absolute expiry height, zero means none
+	LockTime uint32 // Zero means none
+}
+
+// Sweep returns an unsigned transaction that spends all requested utxos to a
+// single destination. The fee is taken from the swept amount.
+func (c *Client) Sweep(ctx context.Context, req *SweepRequest) (*TxResult, error) {
+	script, err := c.payToScript(req.To)
+	if err != nil {
+		return nil, err
+	}
+	var memo []byte
+	if req.Memo != nil {
+		memo, err = MemoScript(req.Memo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Find all utxos and spend every one of them
+	utxos, err := c.Utxos(ctx, &req.UtxoRequest)
+	if err != nil {
+		return nil, err
+	}
+	utxoList, foundAtoms := allUtxos(utxos)
+	if len(utxoList) == 0 {
+		return nil, fmt.Errorf("0 utxos found to assemble transaction")
+	}
+
+	unsignedTx, inputSizes, err := c.unsignedTx(ctx, req.RedeemScripts,
+		utxoList)
+	if err != nil {
+		return nil, err
+	}
+
+	// Output, the fee is taken from the swept amount
+	txOut := wire.NewTxOut(0, script)
+	unsignedTx.AddTxOut(txOut)
+	if memo != nil {
+		unsignedTx.AddTxOut(wire.NewTxOut(0, memo))
+	}
+	fee := EstimateFee(inputSizes, unsignedTx.TxOut, 0)
+	txOut.Value = int64(foundAtoms - fee)
+	if txrules.IsDustOutput(txOut, txrules.DefaultRelayFeePerKb) {
+		return nil, fmt.Errorf("sweep amount is dust: %v fee %v",
+			foundAtoms, fee)
+	}
+	SetTxTiming(unsignedTx, req.Expiry, req.LockTime)
+
+	return &TxResult{
+		Tx:     unsignedTx,
+		Inputs: utxoList,
+		Fee:    fee,
+	}, nil
+}
+
+// ConsolidationSets returns the utxos with a value below minValue, smallest
+// first, split into sets of at most maxInputs. Sets with a single utxo are
+// dropped since consolidating them saves nothing.
+func ConsolidationSets(utxos map[string]it.AddressTxnOutput, minValue dcrutil.Amount, maxInputs int) [][]it.AddressTxnOutput {
+	utxoList, _ := allUtxos(utxos)
+	small := make([]it.AddressTxnOutput, 0, len(utxoList))
+	for k := range utxoList {
+		if dcrutil.Amount(utxoList[k].Satoshis) < minValue {
+			small = append(small, utxoList[k])
+		}
+	}
+	sort.SliceStable(small, func(i, j int) bool {
+		return small[i].Satoshis < small[j].Satoshis
+	})
+
+	var sets [][]it.AddressTxnOutput
+	for len(small) > 1 {
+		n := maxInputs
+		if n > len(small) {
+			n = len(small)
+		}
+		sets = append(sets, small[:n])
+		small = small[n:]
+	}
+	return sets
+}
+
+// ConsolidateRequest describes the consolidation of the small utxos of a
+// multisig address into fewer, larger ones that pay back to it.
+type ConsolidateRequest struct {
+	Address       string
+	MinValue      dcrutil.Amount // Consolidate utxos below this value
+	MaxInputs     int            // Maximum inputs per transaction
+	Confirmations int64          // Minimum number of confirmations
+	Inputs        []string       // When set, only these txid:vout outpoints
+	Exclude       []string       // Never these txid:vout outpoints
+	RedeemScript  []byte         // Looked up with the wallet when nil
+
+	Expiry   uint32 // Absolute expiry height, zero means none
+	LockTime uint32 // Zero means none
+
+	// DryRun only estimates the consolidation, no transactions are
+	// built.
+	DryRun bool
+}
+
+// Consolidation is a single consolidation transaction.
+type Consolidation struct {
+	Tx     *wire.MsgTx // Unsigned transaction, nil on a dry run
+	Inputs []it.AddressTxnOutput
+	Value  dcrutil.Amount // Value of the inputs
+	Fee    dcrutil.Amount
+	Saved  dcrutil.Amount // Fees saved by future spends
+}
+
+// Consolidate returns the transactions that consolidate the requested small
+// utxos.
+func (c *Client) Consolidate(ctx context.Context, req *ConsolidateRequest) ([]Consolidation, error) {
+	script, err := c.payToScript(req.Address)
+	if err != nil {
+		return nil, err
+	}
+	if req.MaxInputs < 2 {
+		return nil, fmt.Errorf("maxinputs must be at least 2")
+	}
+
+	utxos, err := c.Utxos(ctx, &UtxoRequest{
+		Addresses:     []string{req.Address},
+		Confirmations: req.Confirmations,
+		Inputs:        req.Inputs,
+		Exclude:       req.Exclude,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sets := ConsolidationSets(utxos, req.MinValue, req.MaxInputs)
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("nothing to consolidate")
+	}
+
+	var known map[string][]byte
+	if req.RedeemScript != nil {
+		known = map[string][]byte{req.Address: req.RedeemScript}
+	}
+	consolidations := make([]Consolidation, 0, len(sets))
+	for _, set := range sets {
+		redeemScripts, err := c.redeemScripts(ctx, known, set)
+		if err != nil {
+			return nil, err
+		}
+		sizes, err := inputSizes(redeemScripts, set)
+		if err != nil {
+			return nil, err
+		}
+
+		var value dcrutil.Amount
+		for i := range set {
+			value += dcrutil.Amount(set[i].Satoshis)
+		}
+		txOut := wire.NewTxOut(0, script)
+		fee := EstimateFee(sizes, []*wire.TxOut{txOut}, 0)
+		txOut.Value = int64(value - fee)
+		if txrules.IsDustOutput(txOut, txrules.DefaultRelayFeePerKb) {
+			return nil, fmt.Errorf("consolidated amount is dust: "+
+				"%v fee %v", value, fee)
+		}
+
+		// Future spends need a single input instead of len(set).
+		var saved dcrutil.Amount
+		for _, size := range sizes[1:] {
+			saved += txrules.FeeForSerializeSize(
+				txrules.DefaultRelayFeePerKb,
+				txsizes.EstimateInputSize(size))
+		}
+
+		consolidation := Consolidation{
+			Inputs: set,
+			Value:  value,
+			Fee:    fee,
+			Saved:  saved,
+		}
+		if !req.DryRun {
+			unsignedTx, _, err := c.unsignedTx(ctx, known, set)
+			if err != nil {
+				return nil, err
+			}
+			unsignedTx.AddTxOut(txOut)
+			SetTxTiming(unsignedTx, req.Expiry, req.LockTime)
+			consolidation.Tx = unsignedTx
+		}
+		consolidations = append(consolidations, consolidation)
+	}
+	return consolidations, nil
+}
@@ -0,0 +1,80 @@
+package multisig
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+func TestConsolidationSets(t *testing.T) {
+	var utxos []it.AddressTxnOutput
+	for i, atoms := range []int64{5e6, 1e6, 3e8, 2e6, 4e6, 3e6} {
+		utxos = append(utxos, it.AddressTxnOutput{
+			Address:  escrowAddr,
+			TxnID:    fundingTxID,
+			Vout:     uint32(i),
+			Satoshis: atoms,
+		})
+	}
+	u, err := FilterUtxos(utxos, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		minValue  dcrutil.Amount
+		maxInputs int
+		want      [][]int64
+	}{
+		{1e8, 2, [][]int64{{1e6, 2e6}, {3e6, 4e6}}},
+		{1e8, 3, [][]int64{{1e6, 2e6, 3e6}, {4e6, 5e6}}},
+		{1e8, 100, [][]int64{{1e6, 2e6, 3e6, 4e6, 5e6}}},
+		{2e6, 100, nil},
+		{1e6, 100, nil},
+	}
+	for _, tt := range tests {
+		sets := ConsolidationSets(u, tt.minValue, tt.maxInputs)
+		if len(sets) != len(tt.want) {
+			t.Fatalf("%v/%v: got %v sets, want %v", tt.minValue,
+				tt.maxInputs, len(sets), len(tt.want))
+		}
+		for i := range sets {
+			if len(sets[i]) != len(tt.want[i]) {
+				t.Fatalf("%v/%v: set %v: got %v utxos, want %v",
+					tt.minValue, tt.maxInputs, i,
+					len(sets[i]), len(tt.want[i]))
+			}
+			for j := range sets[i] {
+				if sets[i][j].Satoshis != tt.want[i][j] {
+					t.Fatalf("%v/%v: set %v: got %v, want %v",
+						tt.minValue, tt.maxInputs, i,
+						sets[i][j].Satoshis, tt.want[i][j])
+				}
+			}
+		}
+	}
+}
+
+func TestSetTxTiming(t *testing.T) {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, 1e8, nil))
+
+	SetTxTiming(tx, 1000, 0)
+	if tx.Expiry != 1000 || tx.LockTime != 0 {
+		t.Fatalf("got expiry %v locktime %v", tx.Expiry, tx.LockTime)
+	}
+	if tx.TxIn[0].Sequence != wire.MaxTxInSequenceNum {
+		t.Fatalf("input must remain final without lock time")
+	}
+
+	// The lock time is only enforced with non final inputs.
+	SetTxTiming(tx, 1000, 900)
+	if tx.LockTime != 900 {
+		t.Fatalf("got locktime %v", tx.LockTime)
+	}
+	if tx.TxIn[0].Sequence == wire.MaxTxInSequenceNum {
+		t.Fatalf("input must not be final with lock time")
+	}
+}
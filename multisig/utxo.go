@@ -0,0 +1,513 @@
+package multisig
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/decred/dcrd/blockchain/stake/v3"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+// OutpointKey returns the key that identifies an unspent output. A
+// transaction lives in a single tree, so the transaction id and output index
+// uniquely identify the outpoint.
+func OutpointKey(txID string, vout uint32) string {
+	return txID + ":" + strconv.FormatUint(uint64(vout), 10)
+}
+
+// ParseOutpointKey parses and normalizes an outpoint of the form txid:vout.
+func ParseOutpointKey(s string) (string, error) {
+	a := strings.Split(s, ":")
+	if len(a) != 2 {
+		return "", fmt.Errorf("invalid outpoint: %v", s)
+	}
+	hash, err := chainhash.NewHashFromStr(a[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid outpoint %v: %v", s, err)
+	}
+	vout, err := strconv.ParseUint(a[1], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid outpoint %v: %v", s, err)
+	}
+	return OutpointKey(hash.String(), uint32(vout)), nil
+}
+
+// FilterUtxos returns a map, keyed by outpoint, of utxos that have had
+// enough confirmations.
+func FilterUtxos(utxos []it.AddressTxnOutput, confirmations int64) (map[string]it.AddressTxnOutput, error) {
+	u := make(map[string]it.AddressTxnOutput, len(utxos))
+	for k := range utxos {
+		if utxos[k].Confirmations < confirmations {
+			continue
+		}
+		key := OutpointKey(utxos[k].TxnID, utxos[k].Vout)
+		if _, ok := u[key]; ok {
+			return nil, fmt.Errorf("duplicate outpoint: %v", key)
+		}
+		u[key] = utxos[k]
+	}
+	return u, nil
+}
+
+// SelectUtxos selects utxos, in outpoint order, until their total value
+// exceeds amount plus the fee of spending the selected utxos as returned by
+// fee. A nil fee means no fee. Outpoints are ordered by transaction id and
+// then numerically by output index. It returns the selected utxos and their
+// total value.
+func SelectUtxos(utxos map[string]it.AddressTxnOutput, amount dcrutil.Amount, fee func([]it.AddressTxnOutput) dcrutil.Amount) ([]it.AddressTxnOutput, dcrutil.Amount) {
+	keys := make([]string, 0, len(utxos))
+	for k := range utxos {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := utxos[keys[i]], utxos[keys[j]]
+		if a.TxnID != b.TxnID {
+			return a.TxnID < b.TxnID
+		}
+		return a.Vout < b.Vout
+	})
+
+	utxoList := make([]it.AddressTxnOutput, 0, len(utxos))
+	var found dcrutil.Amount
+	for _, k := range keys {
+		utxoList = append(utxoList, utxos[k])
+		found += dcrutil.Amount(utxos[k].Satoshis)
+		log.Debugf("found %v", found)
+		need := amount
+		if fee != nil {
+			need += fee(utxoList)
+		}
+		if found > need {
+			break
+		}
+	}
+	return utxoList, found
+}
+
+// allUtxos returns all utxos in outpoint order and their total value.
+func allUtxos(utxos map[string]it.AddressTxnOutput) ([]it.AddressTxnOutput, dcrutil.Amount) {
+	return SelectUtxos(utxos, math.MaxInt64, nil)
+}
+
+// CoinControl restricts utxos to the explicitly selected inputs, if any, and
+// removes the excluded ones. Unknown outpoints are an error in order to catch
+// typos.
+func CoinControl(utxos map[string]it.AddressTxnOutput, inputs, exclude []string) (map[string]it.AddressTxnOutput, error) {
+	excluded := make(map[string]struct{}, len(exclude))
+	for _, e := range exclude {
+		key, err := ParseOutpointKey(e)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := utxos[key]; !ok {
+			return nil, fmt.Errorf("exclude: unknown or "+
+				"unconfirmed outpoint: %v", key)
+		}
+		excluded[key] = struct{}{}
+	}
+
+	u := make(map[string]it.AddressTxnOutput, len(utxos))
+	if len(inputs) == 0 {
+		for k, v := range utxos {
+			if _, ok := excluded[k]; !ok {
+				u[k] = v
+			}
+		}
+		return u, nil
+	}
+	for _, i := range inputs {
+		key, err := ParseOutpointKey(i)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := utxos[key]
+		if !ok {
+			return nil, fmt.Errorf("inputs: unknown or "+
+				"unconfirmed outpoint: %v", key)
+		}
+		if _, ok := excluded[key]; ok {
+			return nil, fmt.Errorf("outpoint both selected and "+
+				"excluded: %v", key)
+		}
+		if _, ok := u[key]; ok {
+			return nil, fmt.Errorf("duplicate input: %v", key)
+		}
+		u[key] = v
+	}
+	return u, nil
+}
+
+// UtxoRequest selects the utxos of one or more multisig addresses.
+type UtxoRequest struct {
+	Addresses     []string
+	Confirmations int64    // Minimum number of confirmations
+	Inputs        []string // When set, only these txid:vout outpoints
+	Exclude       []string // Never these txid:vout outpoints
+
+	// RedeemScripts are the redeem scripts of the addresses by address.
+	// Scripts that are not provided are looked up with the wallet.
+	RedeemScripts map[string][]byte
+}
+
+// Utxos returns the utxos, keyed by outpoint, of all requested multisig
+// addresses. Explicit input selection and exclusion is applied when the
+// request provides inputs and exclusions.
+func (c *Client) Utxos(ctx context.Context, req *UtxoRequest) (map[string]it.AddressTxnOutput, error) {
+	e, err := c.explorer()
+	if err != nil {
+		return nil, err
+	}
+	utxos := make(map[string]it.AddressTxnOutput)
+	for _, address := range req.Addresses {
+		_, err := dcrutil.DecodeAddress(address, c.cfg.Params)
+		if err != nil {
+			return nil, err
+		}
+		all, err := e.Utxos(ctx, address)
+		if err != nil {
+			return nil, fmt.Errorf("getUtxos: %v", err)
+		}
+		u, err := FilterUtxos(all, req.Confirmations)
+		if err != nil {
+			return nil, fmt.Errorf("getUtxos: %v", err)
+		}
+		for k, v := range u {
+			if _, ok := utxos[k]; ok {
+				return nil, fmt.Errorf("duplicate outpoint: %v",
+					k)
+			}
+			v.Address = address
+			utxos[k] = v
+		}
+	}
+
+	// Coin control
+	return CoinControl(utxos, req.Inputs, req.Exclude)
+}
+
+// Utxo is an unspent output and the tree of the transaction that created it.
+type Utxo struct {
+	it.AddressTxnOutput
+	Tree int8
+}
+
+// ListUtxos returns the requested utxos in outpoint order.
+func (c *Client) ListUtxos(ctx context.Context, req *UtxoRequest) ([]Utxo, error) {
+	utxos, err := c.Utxos(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	utxoList, _ := allUtxos(utxos)
+	_, trees, err := c.utxoPrevOuts(ctx, utxoList)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Utxo, 0, len(utxoList))
+	for k := range utxoList {
+		list = append(list, Utxo{
+			AddressTxnOutput: utxoList[k],
+			Tree:             trees[k],
+		})
+	}
+	return list, nil
+}
+
+// TreeString returns the human readable name of a transaction tree.
+func TreeString(tree int8) string {
+	switch tree {
+	case wire.TxTreeRegular:
+		return "regular"
+	case wire.TxTreeStake:
+		return "stake"
+	}
+	return fmt.Sprintf("unknown (%v)", tree)
+}
+
+// prevTx fetches the previous transaction with the provided hash and
+// verifies that the explorer returned the requested transaction.
+func (c *Client) prevTx(ctx context.Context, prevHash *chainhash.Hash, confirmed bool) (*wire.MsgTx, error) {
+	e, err := c.explorer()
+	if err != nil {
+		return nil, err
+	}
+	rawTxS, err := e.RawTx(ctx, prevHash, confirmed)
+	if err != nil {
+		return nil, fmt.Errorf("get hex tx %v: %v", prevHash, err)
+	}
+	rawTx, err := hex.DecodeString(string(rawTxS))
+	if err != nil {
+		return nil, fmt.Errorf("decode raw hex %v: %v", prevHash, err)
+	}
+	prevTx := wire.NewMsgTx()
+	err = prevTx.FromBytes(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("decode raw tx %v: %v", prevHash, err)
+	}
+	if txHash := prevTx.TxHash(); txHash != *prevHash {
+		return nil, fmt.Errorf("explorer returned tx %v for %v", txHash,
+			prevHash)
+	}
+	return prevTx, nil
+}
+
+// utxoPrevOut returns the output that created the provided utxo and the tree
+// of its transaction. The previous transaction is fetched in order to
+// determine its type, and the output must match the value and, if reported,
+// the script the explorer listed for the utxo.
+func (c *Client) utxoPrevOut(ctx context.Context, utxo *it.AddressTxnOutput) (*wire.TxOut, int8, error) {
+	prevHash, err := chainhash.NewHashFromStr(utxo.TxnID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode tx: %v", err)
+	}
+	prevTx, err := c.prevTx(ctx, prevHash, utxo.Confirmations > 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	op := OutpointKey(utxo.TxnID, utxo.Vout)
+	if int(utxo.Vout) >= len(prevTx.TxOut) {
+		return nil, 0, fmt.Errorf("utxo %v does not exist", op)
+	}
+	txOut := prevTx.TxOut[utxo.Vout]
+	if txOut.Value != utxo.Satoshis {
+		return nil, 0, fmt.Errorf("utxo %v: explorer value %v does "+
+			"not match output value %v", op,
+			dcrutil.Amount(utxo.Satoshis), dcrutil.Amount(txOut.Value))
+	}
+	if utxo.ScriptPubKey != "" &&
+		utxo.ScriptPubKey != hex.EncodeToString(txOut.PkScript) {
+		return nil, 0, fmt.Errorf("utxo %v: explorer script does not "+
+			"match output script", op)
+	}
+
+	// Find the tree, decred specific
+	tree := wire.TxTreeRegular
+	st := stake.DetermineTxType(prevTx, true)
+	if st != stake.TxTypeRegular {
+		tree = wire.TxTreeStake
+	}
+	return txOut, tree, nil
+}
+
+// utxoPrevOuts returns the outputs that created the provided utxos and the
+// trees of their transactions in the same order. Previous transactions are
+// fetched concurrently by a bounded number of workers and progress is
+// reported to the configured callback.
+func (c *Client) utxoPrevOuts(ctx context.Context, utxos []it.AddressTxnOutput) ([]*wire.TxOut, []int8, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+		done     int
+	)
+	prevOuts := make([]*wire.TxOut, len(utxos))
+	trees := make([]int8, len(utxos))
+	jobs := make(chan int)
+	workers := c.cfg.FetchWorkers
+	if workers > len(utxos) {
+		workers = len(utxos)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				prevOut, tree, err := c.utxoPrevOut(ctx,
+					&utxos[k])
+
+				mtx.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+				} else {
+					prevOuts[k] = prevOut
+					trees[k] = tree
+					done++
+					if c.cfg.Progress != nil {
+						c.cfg.Progress(done, len(utxos))
+					}
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for k := range utxos {
+		select {
+		case jobs <- k:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return prevOuts, trees, nil
+}
+
+// PrevOuts returns the previous outputs spent by the inputs of the provided
+// transaction. Unlike the ValueIn and the redeem script of the inputs, which
+// are provided by whoever created the transaction, they are read from the
+// hash checked previous transactions and can be relied upon.
+func (c *Client) PrevOuts(ctx context.Context, tx *wire.MsgTx) ([]*wire.TxOut, error) {
+	prevTxs := make(map[chainhash.Hash]*wire.MsgTx)
+	prevOuts := make([]*wire.TxOut, 0, len(tx.TxIn))
+	for k, txIn := range tx.TxIn {
+		op := txIn.PreviousOutPoint
+		prevTx, ok := prevTxs[op.Hash]
+		if !ok {
+			var err error
+			prevTx, err = c.prevTx(ctx, &op.Hash, false)
+			if err != nil {
+				return nil, err
+			}
+			prevTxs[op.Hash] = prevTx
+		}
+		if int(op.Index) >= len(prevTx.TxOut) {
+			return nil, fmt.Errorf("input %v: previous output %v "+
+				"does not exist", k, op)
+		}
+		prevOuts = append(prevOuts, prevTx.TxOut[op.Index])
+	}
+	return prevOuts, nil
+}
+
+// p2shScript returns the pay to script hash script of the provided redeem
+// script.
+func p2shScript(redeemScript []byte, params *chaincfg.Params) ([]byte, error) {
+	sh, err := dcrutil.NewAddressScriptHash(redeemScript, params)
+	if err != nil {
+		return nil, fmt.Errorf("NewAddressScriptHash: %v", err)
+	}
+	return txscript.PayToAddrScript(sh)
+}
+
+// paysToRedeemScript returns true if the provided output pays to the script
+// hash of the redeem script, either directly or tagged as a stake output.
+func paysToRedeemScript(txOut *wire.TxOut, redeemScript []byte, params *chaincfg.Params) (bool, error) {
+	script, err := p2shScript(redeemScript, params)
+	if err != nil {
+		return false, err
+	}
+	if txOut.Version != wire.DefaultPkScriptVersion {
+		return false, nil
+	}
+	pkScript := txOut.PkScript
+	if len(pkScript) == len(script)+1 {
+		switch pkScript[0] {
+		case txscript.OP_SSGEN, txscript.OP_SSRTX,
+			txscript.OP_SSTXCHANGE:
+			pkScript = pkScript[1:]
+		}
+	}
+	return bytes.Equal(pkScript, script), nil
+}
+
+// CheckPrevOuts verifies that every input of the provided multisig
+// transaction spends a previous output, as returned by PrevOuts, that pays to
+// the script hash of the redeem script the input carries and whose value is
+// the ValueIn of the input. Only then do the redeem scripts identify the
+// contracts that are spent.
+func CheckPrevOuts(tx *wire.MsgTx, prevOuts []*wire.TxOut, params *chaincfg.Params) error {
+	if len(prevOuts) != len(tx.TxIn) {
+		return fmt.Errorf("got %v previous outputs, want %v",
+			len(prevOuts), len(tx.TxIn))
+	}
+	status, err := SigningStatus(tx)
+	if err != nil {
+		return err
+	}
+	for k, txIn := range tx.TxIn {
+		ok, err := paysToRedeemScript(prevOuts[k],
+			status[k].RedeemScript, params)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("input %v %v: redeem script does "+
+				"not match previous output script %x", k,
+				txIn.PreviousOutPoint, prevOuts[k].PkScript)
+		}
+		if txIn.ValueIn != prevOuts[k].Value {
+			return fmt.Errorf("input %v %v: value %v does not "+
+				"match previous output value %v", k,
+				txIn.PreviousOutPoint,
+				dcrutil.Amount(txIn.ValueIn),
+				dcrutil.Amount(prevOuts[k].Value))
+		}
+	}
+	return nil
+}
+
+// assembleTxIns returns the transaction inputs for the provided utxos in the
+// same order. Every input carries the redeem script of the address it pays
+// to. The value of every input is read from the previous transaction, whose
+// output must pay to the script hash of the redeem script.
+func (c *Client) assembleTxIns(ctx context.Context, redeemScripts map[string][]byte, utxos []it.AddressTxnOutput) ([]*wire.TxIn, error) {
+	for k := range utxos {
+		if _, ok := redeemScripts[utxos[k].Address]; !ok {
+			return nil, fmt.Errorf("no redeem script for %v: %v",
+				OutpointKey(utxos[k].TxnID, utxos[k].Vout),
+				utxos[k].Address)
+		}
+	}
+
+	prevOuts, trees, err := c.utxoPrevOuts(ctx, utxos)
+	if err != nil {
+		return nil, err
+	}
+
+	// Spending the same outpoint twice yields an invalid transaction.
+	txIns := make([]*wire.TxIn, 0, len(utxos))
+	seen := make(map[wire.OutPoint]struct{}, len(utxos))
+	for k := range utxos {
+		prevHash, err := chainhash.NewHashFromStr(utxos[k].TxnID)
+		if err != nil {
+			return nil, fmt.Errorf("decode tx: %v", err)
+		}
+		outPoint := wire.NewOutPoint(prevHash, utxos[k].Vout, trees[k])
+		if _, ok := seen[*outPoint]; ok {
+			return nil, fmt.Errorf("duplicate outpoint: %v",
+				outPoint)
+		}
+		seen[*outPoint] = struct{}{}
+
+		redeemScript := redeemScripts[utxos[k].Address]
+		ok, err := paysToRedeemScript(prevOuts[k], redeemScript,
+			c.cfg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("utxo %v does not pay to %v",
+				outPoint, utxos[k].Address)
+		}
+
+		txIn := wire.NewTxIn(outPoint, prevOuts[k].Value, redeemScript)
+		txIns = append(txIns, txIn)
+	}
+	return txIns, nil
+}
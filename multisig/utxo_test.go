@@ -0,0 +1,435 @@
+package multisig
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"decred.org/dcrwallet/wallet/txsizes"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/dcrutil/v3"
+	"github.com/decred/dcrd/txscript/v3"
+	"github.com/decred/dcrd/wire"
+	it "github.com/decred/dcrdata/api/types"
+)
+
+func TestFilterUtxosMultiOutput(t *testing.T) {
+	u, err := FilterUtxos(multiOutputUtxos(), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u) != 2 {
+		t.Fatalf("got %v utxos, want 2", len(u))
+	}
+	for _, vout := range []uint32{0, 2} {
+		if _, ok := u[OutpointKey(fundingTxID, vout)]; !ok {
+			t.Fatalf("missing vout %v", vout)
+		}
+	}
+
+	// Not enough confirmations.
+	u, err = FilterUtxos(multiOutputUtxos(), 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u) != 0 {
+		t.Fatalf("got %v utxos, want 0", len(u))
+	}
+
+	// The same outpoint twice is an explorer error.
+	utxos := multiOutputUtxos()
+	utxos[1].Vout = utxos[0].Vout
+	_, err = FilterUtxos(utxos, 6)
+	if err == nil {
+		t.Fatal("expected duplicate outpoint error")
+	}
+}
+
+func TestSelectUtxosMultiOutput(t *testing.T) {
+	u, err := FilterUtxos(multiOutputUtxos(), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount dcrutil.Amount
+		want   []uint32
+		found  dcrutil.Amount
+	}{
+		{5e7, []uint32{0}, 1e8},
+		{1e8, []uint32{0, 2}, 3e8},
+		{1e8 - 1, []uint32{0}, 1e8},
+		{25e7, []uint32{0, 2}, 3e8},
+	}
+	for _, tt := range tests {
+		utxoList, found := SelectUtxos(u, tt.amount, nil)
+		if found != tt.found {
+			t.Fatalf("amount %v: found %v, want %v", tt.amount,
+				found, tt.found)
+		}
+		if len(utxoList) != len(tt.want) {
+			t.Fatalf("amount %v: got %v utxos, want %v", tt.amount,
+				len(utxoList), len(tt.want))
+		}
+		for k := range utxoList {
+			if utxoList[k].Vout != tt.want[k] {
+				t.Fatalf("amount %v: got vout %v, want %v",
+					tt.amount, utxoList[k].Vout, tt.want[k])
+			}
+		}
+	}
+}
+
+func TestSelectUtxosFee(t *testing.T) {
+	u, err := FilterUtxos(multiOutputUtxos(), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first utxo covers the amount but not the fee of spending it.
+	fee := func(selected []it.AddressTxnOutput) dcrutil.Amount {
+		return dcrutil.Amount(2 * len(selected))
+	}
+	utxoList, found := SelectUtxos(u, 1e8-2, fee)
+	if len(utxoList) != 2 || found != 3e8 {
+		t.Fatalf("got %v utxos, found %v", len(utxoList), found)
+	}
+	utxoList, found = SelectUtxos(u, 1e8-3, fee)
+	if len(utxoList) != 1 || found != 1e8 {
+		t.Fatalf("got %v utxos, found %v", len(utxoList), found)
+	}
+}
+
+func TestSelectUtxosOrder(t *testing.T) {
+	// Output 10 sorts before output 2 as a string.
+	var utxos []it.AddressTxnOutput
+	for _, vout := range []uint32{10, 2, 1} {
+		utxos = append(utxos, it.AddressTxnOutput{
+			Address:  escrowAddr,
+			TxnID:    fundingTxID,
+			Vout:     vout,
+			Satoshis: 1e8,
+		})
+	}
+	utxos = append(utxos, it.AddressTxnOutput{
+		Address:  escrowAddr,
+		TxnID:    "00" + fundingTxID[2:],
+		Vout:     3,
+		Satoshis: 1e8,
+	})
+	u, err := FilterUtxos(utxos, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoList, _ := allUtxos(u)
+	want := []uint32{3, 1, 2, 10}
+	for k := range utxoList {
+		if utxoList[k].Vout != want[k] {
+			t.Fatalf("%v: got vout %v, want %v", k,
+				utxoList[k].Vout, want[k])
+		}
+	}
+}
+
+func TestSelectUtxosAtoms(t *testing.T) {
+	// 0.1 + 0.2 DCR does not add up to 0.3 DCR in floating point.
+	utxos := multiOutputUtxos()
+	utxos[0].Amount, utxos[0].Satoshis = 0.1, 1e7
+	utxos[1].Amount, utxos[1].Satoshis = 0.2, 2e7
+	u, err := FilterUtxos(utxos, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxoList, found := SelectUtxos(u, 3e7-1, nil)
+	if len(utxoList) != 2 || found != 3e7 {
+		t.Fatalf("got %v utxos, found %v", len(utxoList), found)
+	}
+	_, found = SelectUtxos(u, 3e7, nil)
+	if found > 3e7 {
+		t.Fatalf("found %v", found)
+	}
+}
+
+func TestCoinControl(t *testing.T) {
+	u, err := FilterUtxos(multiOutputUtxos(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vout0 := OutpointKey(fundingTxID, 0)
+	vout2 := OutpointKey(fundingTxID, 2)
+
+	tests := []struct {
+		name    string
+		inputs  []string
+		exclude []string
+		want    []string
+		wantErr bool
+	}{
+		{"all", nil, nil, []string{vout0, vout2}, false},
+		{"inputs", []string{vout2}, nil, []string{vout2}, false},
+		{"exclude", nil, []string{vout0}, []string{vout2}, false},
+		{"unknown input", []string{fundingTxID + ":1"}, nil, nil, true},
+		{"unknown exclude", nil, []string{fundingTxID + ":1"}, nil, true},
+		{"invalid", []string{fundingTxID}, nil, nil, true},
+		{"both", []string{vout0}, []string{vout0}, nil, true},
+		{"duplicate", []string{vout0, vout0}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := CoinControl(u, tt.inputs, tt.exclude)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%v: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%v: got %v utxos, want %v", tt.name,
+				len(got), len(tt.want))
+		}
+		for _, key := range tt.want {
+			if _, ok := got[key]; !ok {
+				t.Fatalf("%v: missing %v", tt.name, key)
+			}
+		}
+	}
+}
+
+func TestAssembleTxInsMultiOutput(t *testing.T) {
+	script := mustDecodeHex(t, escrowScript)
+	fundingTx := p2shTx(t, script, 1e8, 5e7, 2e8)
+	e := &testExplorer{}
+	fundingTxID := e.addTx(fundingTx)
+	c := New(Config{
+		Params:   chaincfg.TestNet3Params(),
+		Explorer: e,
+	})
+
+	utxos := multiOutputUtxos()
+	for k := range utxos {
+		utxos[k].TxnID = fundingTxID
+	}
+	redeemScripts := map[string][]byte{escrowAddr: script}
+	txIns, err := c.assembleTxIns(context.Background(), redeemScripts,
+		utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txIns) != len(utxos) {
+		t.Fatalf("got %v inputs, want %v", len(txIns), len(utxos))
+	}
+	for k := range txIns {
+		op := txIns[k].PreviousOutPoint
+		if op.Hash.String() != fundingTxID {
+			t.Fatalf("got hash %v, want %v", op.Hash, fundingTxID)
+		}
+		if op.Index != utxos[k].Vout {
+			t.Fatalf("got index %v, want %v", op.Index,
+				utxos[k].Vout)
+		}
+		if op.Tree != wire.TxTreeRegular {
+			t.Fatalf("got tree %v, want regular", op.Tree)
+		}
+		if !bytes.Equal(txIns[k].SignatureScript, redeemScripts[escrowAddr]) {
+			t.Fatalf("got script %x", txIns[k].SignatureScript)
+		}
+		if txIns[k].ValueIn != utxos[k].Satoshis {
+			t.Fatalf("got value %v, want %v", txIns[k].ValueIn,
+				utxos[k].Satoshis)
+		}
+	}
+
+	// The values are those of the previous outputs, which must match
+	// what the explorer listed and pay to the contract.
+	tests := []struct {
+		name   string
+		utxo   func(*it.AddressTxnOutput)
+		redeem []byte
+		err    string
+	}{
+		{"value", func(u *it.AddressTxnOutput) { u.Satoshis = 2e8 },
+			script, "explorer value 2 DCR does not match output " +
+				"value 1 DCR"},
+		{"script", func(u *it.AddressTxnOutput) { u.ScriptPubKey = "51" },
+			script, "explorer script does not match"},
+		{"missing", func(u *it.AddressTxnOutput) { u.Vout = 3 },
+			script, "does not exist"},
+		{"contract", func(u *it.AddressTxnOutput) {}, []byte{0x52},
+			"does not pay to " + escrowAddr},
+	}
+	for _, tt := range tests {
+		u := utxos[0]
+		tt.utxo(&u)
+		_, err = c.assembleTxIns(context.Background(),
+			map[string][]byte{escrowAddr: tt.redeem},
+			[]it.AddressTxnOutput{u})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%v: got %v", tt.name, err)
+		}
+	}
+
+	// Spending the same outpoint twice must fail.
+	utxos[1].Vout = utxos[0].Vout
+	utxos[1].Satoshis = utxos[0].Satoshis
+	_, err = c.assembleTxIns(context.Background(), redeemScripts, utxos)
+	if err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatalf("got %v", err)
+	}
+
+	// The explorer must return the requested transaction.
+	forged := p2shTx(t, script, 100e8)
+	e.txs[fundingTx.TxHash()] = forged
+	_, err = c.assembleTxIns(context.Background(), redeemScripts,
+		utxos[:1])
+	if err == nil || !strings.Contains(err.Error(), "explorer returned") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestAssembleTxInsHeterogeneous(t *testing.T) {
+	params := chaincfg.TestNet3Params()
+
+	// A 1 of 2 contract that shares its keys with escrowScript.
+	script2, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+		AddData(mustDecodeHex(t, escrowScript)[2:35]).
+		AddData(mustDecodeHex(t, escrowScript)[36:69]).
+		AddOp(txscript.OP_2).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr2, err := dcrutil.NewAddressScriptHash(script2, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redeemScripts := map[string][]byte{
+		escrowAddr:      mustDecodeHex(t, escrowScript),
+		addr2.Address(): script2,
+	}
+
+	fundingTx := p2shTx(t, redeemScripts[escrowAddr], 1e8, 0)
+	fundingTx.AddTxOut(p2shTx(t, script2, 2e8).TxOut[0])
+	e := &testExplorer{}
+	fundingTxID := e.addTx(fundingTx)
+	c := New(Config{
+		Params:   params,
+		Explorer: e,
+	})
+
+	utxos := multiOutputUtxos()
+	for k := range utxos {
+		utxos[k].TxnID = fundingTxID
+	}
+	utxos[1].Address = addr2.Address()
+	txIns, err := c.assembleTxIns(context.Background(), redeemScripts,
+		utxos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range txIns {
+		want := redeemScripts[utxos[k].Address]
+		if !bytes.Equal(txIns[k].SignatureScript, want) {
+			t.Fatalf("input %v: got script %x, want %x", k,
+				txIns[k].SignatureScript, want)
+		}
+	}
+
+	// Fee estimation is done per input.
+	for address, want := range map[string]int{
+		escrowAddr:      2*txsizes.RedeemP2PKHSigScriptSize + 2 + 105,
+		addr2.Address(): 1*txsizes.RedeemP2PKHSigScriptSize + 1 + 71,
+	} {
+		size, err := SigScriptSize(redeemScripts[address])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != want {
+			t.Fatalf("%v: got size %v, want %v", address, size,
+				want)
+		}
+	}
+
+	// Inputs without a known redeem script are rejected.
+	utxos[1].Address = "TsoD8TRGwJdQ3DrxFaV537ffDHnoW3bfD5B"
+	_, err = c.assembleTxIns(context.Background(), redeemScripts, utxos)
+	if err == nil {
+		t.Fatal("expected missing redeem script error")
+	}
+}
+
+func TestPrevOuts(t *testing.T) {
+	script := mustDecodeHex(t, escrowScript)
+	fundingTx := p2shTx(t, script, 1e8, 2e8)
+	fundingTx.AddTxOut(wire.NewTxOut(3e8, []byte{0x51}))
+	e := &testExplorer{}
+	e.addTx(fundingTx)
+	params := chaincfg.TestNet3Params()
+	c := New(Config{
+		Params:   params,
+		Explorer: e,
+	})
+	fundingHash := fundingTx.TxHash()
+
+	tx := wire.NewMsgTx()
+	for _, vout := range []uint32{1, 0} {
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&fundingHash, vout,
+			wire.TxTreeRegular), 1e8, script))
+	}
+	prevOuts, err := c.PrevOuts(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prevOuts) != 2 || prevOuts[0].Value != 2e8 ||
+		prevOuts[1].Value != 1e8 {
+		t.Fatalf("got %v", prevOuts)
+	}
+	err = CheckPrevOuts(tx, prevOuts, params)
+	if err == nil || !strings.Contains(err.Error(), "input 0") {
+		t.Fatalf("got %v", err)
+	}
+	tx.TxIn[0].ValueIn = 2e8
+	err = CheckPrevOuts(tx, prevOuts, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The redeem script must be the one of the previous output.
+	spoofed := testSpoofedScript(t)
+	tx.TxIn[0].SignatureScript = spoofed
+	err = CheckPrevOuts(tx, prevOuts, params)
+	if err == nil || !strings.Contains(err.Error(),
+		"redeem script does not match") {
+		t.Fatalf("got %v", err)
+	}
+	tx.TxIn[0].SignatureScript = script
+	tx.TxIn[1].PreviousOutPoint.Index = 2
+	tx.TxIn[1].ValueIn = 3e8
+	prevOuts, err = c.PrevOuts(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckPrevOuts(tx, prevOuts, params)
+	if err == nil || !strings.Contains(err.Error(), "input 1") {
+		t.Fatalf("got %v", err)
+	}
+
+	// Outputs that do not exist have no value.
+	tx.TxIn[1].PreviousOutPoint.Index = 3
+	_, err = c.PrevOuts(context.Background(), tx)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// testSpoofedScript returns a 1 of 1 multisig redeem script of another key.
+func testSpoofedScript(t *testing.T) []byte {
+	t.Helper()
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+		AddData(mustDecodeHex(t, escrowScript)[2:35]).
+		AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}